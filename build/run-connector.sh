@@ -4,9 +4,9 @@ HOST_CMD="nsenter --mount=/proc/1/ns/mnt"
 BINARY_NAME="open-object"
 CONFIG_DIR=/host/etc/$BINARY_NAME
 
+# s3fs is the default mounter, volumes using the builtin mounter do not need it
 if [ ! `$HOST_CMD which s3fs` ]; then
-    echo "s3fs not found, plz install it first..."
-    exit 1
+    echo "s3fs not found, only the builtin mounter will work..."
 fi
 
 rm -f $CONFIG_DIR/connector.pid
@@ -16,6 +16,10 @@ cp -f /fuse-connector.service /host/usr/lib/systemd/system/fuse-connector.servic
 chmod 755 /host/usr/lib/systemd/system/fuse-connector.service
 
 cp -f /fuse-connector.conf $CONFIG_DIR/fuse-connector.conf
+# the connector only mounts the csi volumes of the pods of kubelet
+if [ -n "$KUBELET_DIR" ]; then
+    sed -i "s|^ARG=.*|& --kubelet-dir=$KUBELET_DIR|" $CONFIG_DIR/fuse-connector.conf
+fi
 chmod 755 $CONFIG_DIR/fuse-connector.conf
 
 cp -f /bin/$BINARY_NAME $CONFIG_DIR/$BINARY_NAME
@@ -5,10 +5,19 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var (
+	opt = connectorOption{}
+)
+
 var Cmd = &cobra.Command{
 	Use:   "connector",
 	Short: "command for running fuse connector in host",
 	Run: func(cmd *cobra.Command, args []string) {
-		common.RunConnector()
+		common.RunConnector(opt.config())
 	},
 }
+
+func init() {
+	opt.addFlags(Cmd.Flags())
+	Cmd.AddCommand(journalCmd)
+}
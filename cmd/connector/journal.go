@@ -0,0 +1,48 @@
+package connector
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	journalPath   string
+	journalOutput string
+)
+
+var journalCmd = &cobra.Command{
+	Use:   "journal",
+	Short: "list the mounts recorded in the connector journal",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := common.ReadMountJournal(journalPath)
+		if err != nil {
+			return fmt.Errorf("fail to read journal %s: %s", journalPath, err.Error())
+		}
+		switch journalOutput {
+		case "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(entries)
+		case "table":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "VOLUME\tTARGET\tBACKEND\tCOMMAND\tPID\tSECRET\tMOUNTED\tARGS")
+			for _, e := range entries {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", e.VolumeID, e.Target, e.Backend, e.Command, e.PID, e.SecretRef, e.MountedAt.Format(time.RFC3339), strings.Join(e.Args, " "))
+			}
+			return w.Flush()
+		default:
+			return fmt.Errorf("unknown output format %q, expect table or json", journalOutput)
+		}
+	},
+}
+
+func init() {
+	journalCmd.Flags().StringVar(&journalPath, "journal", common.ConnectorJournalFilename, "path of the connector journal")
+	journalCmd.Flags().StringVarP(&journalOutput, "output", "o", "table", "output format, table or json")
+}
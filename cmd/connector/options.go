@@ -0,0 +1,50 @@
+package connector
+
+import (
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	"github.com/spf13/pflag"
+)
+
+type connectorOption struct {
+	AllowedUIDs      []uint
+	AllowedGIDs      []uint
+	AllowedCgroups   []string
+	AllowedCommands  []string
+	AllowedEndpoints []string
+	KubeletDir       string
+	AuditLog         string
+	Journal          string
+}
+
+func (opt *connectorOption) addFlags(fs *pflag.FlagSet) {
+	defaults := common.DefaultConnectorConfig()
+	fs.UintSliceVar(&opt.AllowedUIDs, "allowed-uids", []uint{0}, "uids of the processes allowed to call the connector, empty allows any")
+	fs.UintSliceVar(&opt.AllowedGIDs, "allowed-gids", nil, "gids of the processes allowed to call the connector, empty allows any")
+	fs.StringSliceVar(&opt.AllowedCgroups, "allowed-cgroups", nil, "cgroup substrings one of which the caller must belong to, empty allows any")
+	fs.StringSliceVar(&opt.AllowedCommands, "allowed-commands", defaults.AllowedCommands, "fuse binaries the connector is allowed to run")
+	fs.StringSliceVar(&opt.AllowedEndpoints, "allowed-endpoints", nil, "urls of the object stores fuse clients may connect to, e.g. https://minio.example.com:9000, empty allows any")
+	fs.StringVar(&opt.KubeletDir, "kubelet-dir", defaults.KubeletDir, "root directory of kubelet, the connector only mounts the csi volumes of its pods")
+	fs.StringVar(&opt.AuditLog, "audit-log", common.ConnectorAuditLogFilename, "file recording every connector request, empty disables it")
+	fs.StringVar(&opt.Journal, "journal", common.ConnectorJournalFilename, "file recording the active mounts to recover them on restart, empty disables it")
+}
+
+func (opt *connectorOption) config() *common.ConnectorConfig {
+	return &common.ConnectorConfig{
+		AllowedUIDs:      toUint32(opt.AllowedUIDs),
+		AllowedGIDs:      toUint32(opt.AllowedGIDs),
+		AllowedCgroups:   opt.AllowedCgroups,
+		AllowedCommands:  opt.AllowedCommands,
+		AllowedEndpoints: opt.AllowedEndpoints,
+		KubeletDir:       opt.KubeletDir,
+		AuditLog:         opt.AuditLog,
+		Journal:          opt.Journal,
+	}
+}
+
+func toUint32(list []uint) []uint32 {
+	var out []uint32
+	for _, v := range list {
+		out = append(out, uint32(v))
+	}
+	return out
+}
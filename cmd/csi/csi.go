@@ -31,11 +31,13 @@ func init() {
 }
 
 func Start(opt *csiOption) error {
-	klog.Info("CSI Driver Name: %s, nodeID: %s, endPoints %s", opt.Driver, opt.NodeID, opt.Endpoint)
+	klog.Infof("CSI Driver Name: %s, nodeID: %s, endPoints %s", opt.Driver, opt.NodeID, opt.Endpoint)
 
 	if err := s3minio.DefaultMutableFeatureGate.SetFromMap(opt.FeatureGates); err != nil {
 		return fmt.Errorf("unable to setup feature gates: %s", err.Error())
 	}
+	s3minio.DefaultOwnerIdentity = s3minio.OwnerIdentity{Cluster: opt.ClusterID, Driver: opt.Driver}
+	s3minio.DefaultUsageStaleness = opt.UsageStaleness
 	cfg, err := clientcmd.BuildConfigFromFlags(opt.Master, opt.KubeConfig)
 	if err != nil {
 		klog.Fatalf("Error building kubeconfig: %s", err.Error())
@@ -45,7 +47,7 @@ func Start(opt *csiOption) error {
 		klog.Fatalf("Error building kubernetes clientset: %s", err.Error())
 	}
 
-	driver, err := csi.NewFuseDriver(opt.NodeID, opt.Endpoint, opt.Driver, kubeClient)
+	driver, err := csi.NewFuseDriver(opt.NodeID, opt.Endpoint, opt.Driver, opt.KubeletDir, opt.MetricsAddr, opt.Controller, opt.ReconcileInterval, kubeClient)
 	if err != nil {
 		klog.Fatal(err)
 	}
@@ -2,8 +2,10 @@ package csi
 
 import (
 	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	"github.com/guodoliu/csi-driver-s3/pkg/csi/s3minio"
 	"github.com/spf13/pflag"
 	cliflag "k8s.io/component-base/cli/flag"
+	"time"
 )
 
 type csiOption struct {
@@ -12,7 +14,16 @@ type csiOption struct {
 	Driver       string
 	Master       string
 	KubeConfig   string
+	KubeletDir   string
+	ClusterID    string
+	MetricsAddr  string
 	FeatureGates map[string]bool
+	// Controller runs the plugin next to the controller sidecars, instead of on a node
+	Controller bool
+	// ReconcileInterval is the period of the pass applying PVC annotations to existing volumes
+	ReconcileInterval time.Duration
+	// UsageStaleness is how long the usage of a volume is served from the cache
+	UsageStaleness time.Duration
 }
 
 func (opt *csiOption) addFlags(fs *pflag.FlagSet) {
@@ -21,5 +32,11 @@ func (opt *csiOption) addFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&opt.Driver, "driver", common.DefaultDriverName, "csi driver name")
 	fs.StringVar(&opt.Master, "master", "", "URL/IP for master")
 	fs.StringVar(&opt.KubeConfig, "kubeconfig", "", "path to the kubeconfig file")
+	fs.StringVar(&opt.KubeletDir, "kubelet-dir", common.DefaultKubeletDir, "root directory of kubelet, where published volumes are looked up after a restart")
+	fs.StringVar(&opt.MetricsAddr, "metrics-addr", "", "address serving the prometheus metrics of the builtin fuse processes of the volumes of the node, empty disables it")
+	fs.StringVar(&opt.ClusterID, "cluster-id", s3minio.DefaultOwnerIdentity.Cluster, "id of the cluster, recorded in the ownership tags of the buckets the driver creates")
+	fs.BoolVar(&opt.Controller, "controller", false, "run the plugin of the controller sidecars, which reconciles volumes and does not serve the volumes of a node")
+	fs.DurationVar(&opt.ReconcileInterval, "reconcile-interval", 5*time.Minute, "period of the pass applying PVC annotations, e.g. lifecycle rules, to the buckets of existing volumes, 0 disables it. Only used with --controller")
+	fs.DurationVar(&opt.UsageStaleness, "usage-staleness", s3minio.DefaultUsageStaleness, "how long the usage reported by the volume stats is served from the cache, 0 accounts it on every request")
 	fs.Var(cliflag.NewMapStringBool(&opt.FeatureGates), "feature-gates", "A set of key=value pairs that describe feature gates for alpha/experimental features.")
 }
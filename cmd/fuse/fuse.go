@@ -0,0 +1,119 @@
+package fuse
+
+import (
+	"fmt"
+	"github.com/guodoliu/csi-driver-s3/pkg/objectfs"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+var (
+	opt = fuseOption{}
+)
+
+var Cmd = &cobra.Command{
+	Use:   "fuse [flags] target",
+	Short: "command for mounting a bucket with the built-in fuse filesystem, in the foreground",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return run(args[0])
+	},
+}
+
+func init() {
+	opt.addFlags(Cmd.Flags())
+}
+
+func run(target string) error {
+	if opt.Bucket == "" {
+		return fmt.Errorf("--bucket is required")
+	}
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	if opt.MetricsAddr != "" {
+		listener, err := listenMetrics(opt.MetricsAddr)
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", promhttp.Handler())
+			klog.Errorf("metrics server stopped: %v", http.Serve(listener, mux))
+		}()
+	}
+
+	server, err := objectfs.Mount(client, target, objectfs.Options{
+		Bucket:        opt.Bucket,
+		Prefix:        opt.Prefix,
+		Capacity:      opt.Capacity,
+		UsageInterval: opt.UsageInterval,
+		CacheDir:      opt.CacheDir,
+		FileMode:      opt.FileMode,
+		DirMode:       opt.DirMode,
+		UID:           opt.UID,
+		GID:           opt.GID,
+		AttrTimeout:   opt.AttrTimeout,
+		ReadOnly:      opt.ReadOnly,
+	})
+	if err != nil {
+		return fmt.Errorf("fail to mount bucket %s on %s: %s", opt.Bucket, target, err.Error())
+	}
+	klog.Infof("bucket %s mounted on %s", opt.Bucket, target)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		klog.Infof("received %s, unmounting %s", sig, target)
+		if err := server.Unmount(); err != nil {
+			klog.Errorf("fail to unmount %s: %s", target, err.Error())
+		}
+	}()
+	server.Wait()
+	return nil
+}
+
+// listenMetrics listens on addr, a unix socket replacing the one of a previous process when it starts with unix:.
+func listenMetrics(addr string) (net.Listener, error) {
+	socket, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return net.Listen("unix", socket)
+}
+
+func newClient() (*minio.Client, error) {
+	u, err := url.Parse(opt.Endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", opt.Endpoint)
+	}
+	creds := credentials.NewEnvAWS()
+	if opt.CredentialFile != "" {
+		creds = credentials.NewFileAWSCredentials(opt.CredentialFile, "default")
+	}
+	return minio.New(u.Host, &minio.Options{
+		Creds:  creds,
+		Secure: u.Scheme == "https",
+		Region: opt.Region,
+	})
+}
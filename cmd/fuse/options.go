@@ -0,0 +1,42 @@
+package fuse
+
+import (
+	"github.com/spf13/pflag"
+	"time"
+)
+
+type fuseOption struct {
+	Endpoint       string
+	Region         string
+	Bucket         string
+	Prefix         string
+	CredentialFile string
+	Capacity       int64
+	CacheDir       string
+	MetricsAddr    string
+	ReadOnly       bool
+	UsageInterval  time.Duration
+	AttrTimeout    time.Duration
+	FileMode       uint32
+	DirMode        uint32
+	UID            uint32
+	GID            uint32
+}
+
+func (opt *fuseOption) addFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&opt.Endpoint, "endpoint", "", "endpoint of the object store, with its scheme")
+	fs.StringVar(&opt.Region, "region", "", "region of the bucket")
+	fs.StringVar(&opt.Bucket, "bucket", "", "bucket to mount")
+	fs.StringVar(&opt.Prefix, "prefix", "", "only mount the objects under this prefix")
+	fs.StringVar(&opt.CredentialFile, "credential-file", "", "shared credentials file with a default profile, the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables are used when empty")
+	fs.Int64Var(&opt.Capacity, "capacity", 0, "capacity in bytes reported by statfs, 0 reports an unlimited filesystem")
+	fs.StringVar(&opt.CacheDir, "cache-dir", "", "directory of the local copies of files being written, the temporary directory when empty")
+	fs.StringVar(&opt.MetricsAddr, "metrics-addr", "", "address serving prometheus metrics, a unix socket when it starts with unix:, empty disables them")
+	fs.BoolVar(&opt.ReadOnly, "read-only", false, "mount the bucket readonly")
+	fs.DurationVar(&opt.UsageInterval, "usage-interval", time.Minute, "period at which the usage reported by statfs is refreshed, 0 disables it")
+	fs.DurationVar(&opt.AttrTimeout, "attr-timeout", time.Second, "how long the kernel caches attributes and entries")
+	fs.Uint32Var(&opt.FileMode, "file-mode", 0666, "permission bits of files")
+	fs.Uint32Var(&opt.DirMode, "dir-mode", 0777, "permission bits of directories")
+	fs.Uint32Var(&opt.UID, "uid", 0, "owner of files and directories")
+	fs.Uint32Var(&opt.GID, "gid", 0, "group of files and directories")
+}
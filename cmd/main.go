@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/guodoliu/csi-driver-s3/cmd/connector"
 	"github.com/guodoliu/csi-driver-s3/cmd/csi"
+	"github.com/guodoliu/csi-driver-s3/cmd/fuse"
 	"github.com/guodoliu/csi-driver-s3/cmd/version"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -29,7 +30,7 @@ func init() {
 }
 
 func main() {
-	klog.Info("Version: %s, Commit: %s", VERSION, COMMITID)
+	klog.Infof("Version: %s, Commit: %s", VERSION, COMMITID)
 	if err := mainCmd.Execute(); err != nil {
 		fmt.Printf("open-object start error: %+v\n", err)
 		os.Exit(1)
@@ -40,7 +41,8 @@ func addCommands() {
 	mainCmd.AddCommand(
 		csi.Cmd,
 		version.Cmd,
-		connector.Cmd)
+		connector.Cmd,
+		fuse.Cmd)
 }
 
 // wordSepNormalizeFunc changes all flags that contain "_" separators
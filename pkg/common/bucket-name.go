@@ -0,0 +1,66 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+const (
+	MinBucketNameLen = 3
+	MaxBucketNameLen = 63
+	// bucketNameHashLen is the length of the hash suffix keeping sanitized names unique
+	bucketNameHashLen = 8
+)
+
+var (
+	bucketNameRegexp   = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]*[a-z0-9]$`)
+	invalidBucketChars = regexp.MustCompile(`[^a-z0-9-]+`)
+	repeatedDashes     = regexp.MustCompile(`-{2,}`)
+)
+
+// ValidateBucketName checks name against the S3 bucket naming rules.
+func ValidateBucketName(name string) error {
+	if len(name) < MinBucketNameLen || len(name) > MaxBucketNameLen {
+		return fmt.Errorf("bucket name %q must be between %d and %d characters long", name, MinBucketNameLen, MaxBucketNameLen)
+	}
+	if !bucketNameRegexp.MatchString(name) {
+		return fmt.Errorf("bucket name %q must only contain lowercase letters, digits, dots and hyphens, and start and end with a letter or digit", name)
+	}
+	if strings.Contains(name, "..") || strings.Contains(name, ".-") || strings.Contains(name, "-.") {
+		return fmt.Errorf("bucket name %q must not contain adjacent dots or hyphens next to dots", name)
+	}
+	if net.ParseIP(name) != nil {
+		return fmt.Errorf("bucket name %q must not be an ip address", name)
+	}
+	if strings.HasPrefix(name, "xn--") || strings.HasSuffix(name, "-s3alias") || strings.HasSuffix(name, "--ol-s3") {
+		return fmt.Errorf("bucket name %q uses a reserved prefix or suffix", name)
+	}
+	return nil
+}
+
+// SanitizeBucketName turns name into a valid bucket name. Names that had to be changed get a hash of the
+// original name as suffix, so different names never sanitize to the same bucket.
+func SanitizeBucketName(name string) string {
+	sanitized := strings.ToLower(name)
+	sanitized = invalidBucketChars.ReplaceAllString(sanitized, "-")
+	sanitized = repeatedDashes.ReplaceAllString(sanitized, "-")
+	sanitized = strings.Trim(sanitized, "-")
+	sanitized = strings.TrimPrefix(sanitized, "xn-")
+	if sanitized == name && ValidateBucketName(sanitized) == nil {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	suffix := hex.EncodeToString(sum[:])[:bucketNameHashLen]
+	if max := MaxBucketNameLen - len(suffix) - 1; len(sanitized) > max {
+		sanitized = strings.TrimRight(sanitized[:max], "-")
+	}
+	if sanitized == "" {
+		return suffix
+	}
+	return sanitized + "-" + suffix
+}
@@ -0,0 +1,34 @@
+package common
+
+import (
+	"github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+)
+
+func TestBucketName(t *testing.T) {
+	convey.Convey("test bucket name validation", t, func() {
+		convey.So(ValidateBucketName("team-a.logs"), convey.ShouldBeNil)
+		for _, name := range []string{"ab", strings.Repeat("a", 64), "Team-A", "team_a", "-team", "team..a", "192.168.1.1", "xn--team", "team-s3alias"} {
+			convey.So(ValidateBucketName(name), convey.ShouldNotBeNil)
+		}
+	})
+
+	convey.Convey("test bucket name sanitization", t, func() {
+		convey.So(SanitizeBucketName("default-data-pvc-1"), convey.ShouldEqual, "default-data-pvc-1")
+
+		sanitized := SanitizeBucketName("Team_A/Data")
+		convey.So(sanitized, convey.ShouldStartWith, "team-a-data-")
+		convey.So(ValidateBucketName(sanitized), convey.ShouldBeNil)
+		convey.So(sanitized, convey.ShouldNotEqual, SanitizeBucketName("team.a/data"))
+
+		long := SanitizeBucketName(strings.Repeat("namespace-", 10))
+		convey.So(len(long), convey.ShouldBeLessThanOrEqualTo, MaxBucketNameLen)
+		convey.So(ValidateBucketName(long), convey.ShouldBeNil)
+		convey.So(long, convey.ShouldNotEqual, SanitizeBucketName(strings.Repeat("namespace-", 11)))
+
+		for _, name := range []string{"a", "__", "xn--idn", "10.0.0.1"} {
+			convey.So(ValidateBucketName(SanitizeBucketName(name)), convey.ShouldBeNil)
+		}
+	})
+}
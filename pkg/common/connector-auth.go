@@ -0,0 +1,467 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"k8s.io/klog/v2"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConnectorConfig restricts who may call the connector and what it may run.
+type ConnectorConfig struct {
+	// AllowedUIDs and AllowedGIDs of peers, empty allows any
+	AllowedUIDs []uint32
+	AllowedGIDs []uint32
+	// AllowedCgroups are substrings one of which must appear in /proc/<peer pid>/cgroup, empty allows any
+	AllowedCgroups []string
+	// AllowedCommands are the fuse binaries the connector runs, by name or absolute path
+	AllowedCommands []string
+	// AllowedEndpoints are the urls of the object stores fuse clients may connect to, by scheme and host,
+	// empty allows any http or https url
+	AllowedEndpoints []string
+	// KubeletDir is the root directory of kubelet, mount targets must be csi volumes of its pods
+	KubeletDir string
+	// AuditLog records every request, empty disables it
+	AuditLog string
+	// Journal records the active mounts to recover them on restart, empty disables it
+	Journal string
+}
+
+// DefaultConnectorConfig only accepts root peers running the supported fuse clients.
+func DefaultConnectorConfig() *ConnectorConfig {
+	return &ConnectorConfig{
+		AllowedUIDs:     []uint32{0},
+		AllowedCommands: []string{"s3fs", "goofys", "geesefs", "rclone", "mount-s3", BinaryPath},
+		KubeletDir:      DefaultKubeletDir,
+		AuditLog:        ConnectorAuditLogFilename,
+		Journal:         ConnectorJournalFilename,
+	}
+}
+
+// PeerCred identifies the process on the other end of a connector connection.
+type PeerCred struct {
+	PID int32  `json:"pid"`
+	UID uint32 `json:"uid"`
+	GID uint32 `json:"gid"`
+}
+
+// shellMetaChars are rejected in arguments even though no shell is involved, as no fuse option needs them.
+const shellMetaChars = ";&|`$()<>\\\"'*?[]{}!\n\r\x00"
+
+// connectorFlag is a flag a fuse client may be run with.
+type connectorFlag struct {
+	// value reports whether the flag takes a value, after "=" or as the next argument
+	value bool
+	// values restricts the value, empty allows any value without shell metacharacters
+	values []string
+	// dir requires the value to be a file of the directory, after the prefix
+	dir    string
+	prefix string
+	// url requires an http or https url, of one of the allowed endpoints when they are configured
+	url bool
+}
+
+// connectorCommand is what a fuse client may be run with.
+type connectorCommand struct {
+	// subcommand is the first argument, if the client has subcommands
+	subcommand string
+	// source is the prefix of the bucket argument
+	source string
+	// bucketFlag reports whether the bucket is passed as a flag, the target is then the only argument
+	bucketFlag bool
+	flags      map[string]connectorFlag
+	// env maps the names of the environment variables to whether their value is a credential file
+	env map[string]bool
+}
+
+var (
+	valueFlag      = connectorFlag{value: true}
+	endpointFlag   = connectorFlag{value: true, url: true}
+	credentialFlag = connectorFlag{value: true, dir: CredentialDir}
+	awsEnv         = map[string]bool{"AWS_ACCESS_KEY_ID": false, "AWS_SECRET_ACCESS_KEY": false, "AWS_SHARED_CREDENTIALS_FILE": true}
+)
+
+// connectorCommands are the arguments and environment the mounters of the plugin run the fuse clients with,
+// keyed by the name of their binary. Anything else could make a client run code or write files as root.
+var connectorCommands = map[string]connectorCommand{
+	"s3fs": {
+		flags: map[string]connectorFlag{
+			"-f":                       {},
+			"-ourl":                    endpointFlag,
+			"-ouse_path_request_style": {},
+			"-oallow_other":            {},
+			"-omp_umask":               valueFlag,
+			"-oendpoint":               valueFlag,
+			"-opasswd_file":            credentialFlag,
+			"-ouse_sse":                {value: true, dir: CredentialDir, prefix: "custom:"},
+			"-oro":                     {},
+		},
+		env: map[string]bool{"AWSACCESSKEYID": false, "AWSSECRETACCESSKEY": false},
+	},
+	"goofys": {
+		flags: goofysFlags(nil),
+		env:   awsEnv,
+	},
+	"geesefs": {
+		flags: goofysFlags(map[string]connectorFlag{"--shared-config": credentialFlag}),
+		env:   awsEnv,
+	},
+	"rclone": {
+		subcommand: "mount",
+		source:     "s3:",
+		flags: map[string]connectorFlag{
+			"--s3-endpoint":    endpointFlag,
+			"--s3-region":      valueFlag,
+			"--allow-other":    {},
+			"--vfs-cache-mode": {value: true, values: []string{"off", "minimal", "writes", "full"}},
+			"--dir-perms":      valueFlag,
+			"--file-perms":     valueFlag,
+			"--config":         credentialFlag,
+			"--read-only":      {},
+		},
+		env: map[string]bool{
+			"RCLONE_CONFIG_S3_TYPE":              false,
+			"RCLONE_CONFIG_S3_PROVIDER":          false,
+			"RCLONE_CONFIG_S3_ACCESS_KEY_ID":     false,
+			"RCLONE_CONFIG_S3_SECRET_ACCESS_KEY": false,
+		},
+	},
+	"mount-s3": {
+		flags: map[string]connectorFlag{
+			"--foreground":       {},
+			"--endpoint-url":     endpointFlag,
+			"--force-path-style": {},
+			"--allow-other":      {},
+			"--allow-delete":     {},
+			"--allow-overwrite":  {},
+			"--file-mode":        valueFlag,
+			"--dir-mode":         valueFlag,
+			"--region":           valueFlag,
+			"--prefix":           valueFlag,
+			"--read-only":        {},
+		},
+		env: awsEnv,
+	},
+	filepath.Base(BinaryPath): {
+		subcommand: "fuse",
+		bucketFlag: true,
+		flags: map[string]connectorFlag{
+			"--endpoint":        endpointFlag,
+			"--bucket":          valueFlag,
+			"--prefix":          valueFlag,
+			"--region":          valueFlag,
+			"--credential-file": credentialFlag,
+			"--metrics-addr":    {value: true, dir: MetricsDir, prefix: "unix:"},
+			"--capacity":        valueFlag,
+			"--read-only":       {},
+		},
+		env: awsEnv,
+	},
+}
+
+// goofysFlags are the flags of goofys and its fork geesefs, with extra ones.
+func goofysFlags(extra map[string]connectorFlag) map[string]connectorFlag {
+	flags := map[string]connectorFlag{
+		"-f":          {},
+		"--endpoint":  endpointFlag,
+		"--region":    valueFlag,
+		"-o":          {value: true, values: []string{"allow_other", "ro"}},
+		"--file-mode": valueFlag,
+		"--dir-mode":  valueFlag,
+	}
+	for name, flag := range extra {
+		flags[name] = flag
+	}
+	return flags
+}
+
+// authorizePeer checks the credentials of the caller against the config.
+func (cfg *ConnectorConfig) authorizePeer(peer *PeerCred) error {
+	if len(cfg.AllowedUIDs) != 0 && !containsUint32(cfg.AllowedUIDs, peer.UID) {
+		return fmt.Errorf("uid %d is not allowed", peer.UID)
+	}
+	if len(cfg.AllowedGIDs) != 0 && !containsUint32(cfg.AllowedGIDs, peer.GID) {
+		return fmt.Errorf("gid %d is not allowed", peer.GID)
+	}
+	if len(cfg.AllowedCgroups) != 0 {
+		cgroup, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", peer.PID))
+		if err != nil {
+			return fmt.Errorf("fail to read cgroup of pid %d: %s", peer.PID, err.Error())
+		}
+		allowed := false
+		for _, c := range cfg.AllowedCgroups {
+			if strings.Contains(string(cgroup), c) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("cgroup of pid %d is not allowed", peer.PID)
+		}
+	}
+	return nil
+}
+
+// validateRequest checks that a request only runs allowed commands with safe arguments.
+func (cfg *ConnectorConfig) validateRequest(req *ConnectorRequest) error {
+	switch req.Op {
+	case ConnectorOpMount:
+		if req.Mount == nil {
+			return fmt.Errorf("mount request is empty")
+		}
+		if !cfg.commandAllowed(req.Mount.Command) {
+			return fmt.Errorf("command %q is not allowed", req.Mount.Command)
+		}
+		if err := cfg.validateTarget(req.Mount.Target); err != nil {
+			return err
+		}
+		return cfg.validateCommand(req.Mount)
+	case ConnectorOpUnmount:
+		if req.Unmount == nil {
+			return fmt.Errorf("unmount request is empty")
+		}
+		return cfg.validateTarget(req.Unmount.Target)
+	}
+	return nil
+}
+
+func (cfg *ConnectorConfig) commandAllowed(command string) bool {
+	for _, allowed := range cfg.AllowedCommands {
+		if command == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePath requires a clean absolute path without shell metacharacters.
+func validatePath(path string) error {
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+		return fmt.Errorf("path %q must be clean and absolute", path)
+	}
+	if strings.ContainsAny(path, shellMetaChars) {
+		return fmt.Errorf("path %q contains shell metacharacters", path)
+	}
+	return nil
+}
+
+// validateTarget requires the mount point of a csi volume of a pod, the only paths the plugin mounts.
+func (cfg *ConnectorConfig) validateTarget(target string) error {
+	if err := validatePath(target); err != nil {
+		return err
+	}
+	kubeletDir := cfg.KubeletDir
+	if kubeletDir == "" {
+		kubeletDir = DefaultKubeletDir
+	}
+	pattern := filepath.Join(kubeletDir, "pods", "*", "volumes", "kubernetes.io~csi", "*", "mount")
+	if matched, _ := filepath.Match(pattern, target); !matched {
+		return fmt.Errorf("path %q is not the mount point of a csi volume of %s", target, kubeletDir)
+	}
+	return nil
+}
+
+// validateCommand checks the arguments and the environment of a mount against those of its fuse client.
+func (cfg *ConnectorConfig) validateCommand(req *MountRequest) error {
+	command, ok := connectorCommands[filepath.Base(req.Command)]
+	if !ok {
+		return fmt.Errorf("command %q has no known arguments", req.Command)
+	}
+	var positional []string
+	for i := 0; i < len(req.Args); i++ {
+		arg := req.Args[i]
+		if strings.ContainsAny(arg, shellMetaChars) {
+			return fmt.Errorf("argument %q contains shell metacharacters", arg)
+		}
+		if !strings.HasPrefix(arg, "-") {
+			positional = append(positional, arg)
+			continue
+		}
+		name, value, inline := strings.Cut(arg, "=")
+		flag, ok := command.flags[name]
+		if !ok {
+			return fmt.Errorf("flag %q is not allowed for %s", name, req.Command)
+		}
+		if !flag.value {
+			if inline {
+				return fmt.Errorf("flag %q takes no value", name)
+			}
+			continue
+		}
+		if !inline {
+			i++
+			if i == len(req.Args) {
+				return fmt.Errorf("flag %q needs a value", name)
+			}
+			value = req.Args[i]
+		}
+		if err := flag.validate(name, value); err != nil {
+			return err
+		}
+		if flag.url {
+			if err := cfg.validateEndpoint(name, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	if command.subcommand != "" {
+		if len(positional) == 0 || positional[0] != command.subcommand {
+			return fmt.Errorf("%s must run its %s subcommand", req.Command, command.subcommand)
+		}
+		positional = positional[1:]
+	}
+	// the bucket, and the target last
+	if !command.bucketFlag {
+		if len(positional) == 0 || !strings.HasPrefix(positional[0], command.source) {
+			return fmt.Errorf("%s must mount a bucket of %s", req.Command, command.source)
+		}
+		positional = positional[1:]
+	}
+	if len(positional) != 1 || positional[0] != req.Target {
+		return fmt.Errorf("%s must mount a bucket on %s", req.Command, req.Target)
+	}
+
+	for _, env := range req.Env {
+		name, value, found := strings.Cut(env, "=")
+		file, ok := command.env[name]
+		if !found || !ok {
+			return fmt.Errorf("environment variable %q is not allowed for %s", name, req.Command)
+		}
+		if strings.ContainsAny(value, "\n\r\x00") {
+			return fmt.Errorf("malformed environment variable %q", name)
+		}
+		if file {
+			if err := validateDirFile(value, CredentialDir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f connectorFlag) validate(name, value string) error {
+	if strings.HasPrefix(value, "-") || strings.ContainsAny(value, shellMetaChars) {
+		return fmt.Errorf("value %q of flag %q is not allowed", value, name)
+	}
+	if len(f.values) != 0 && !containsString(f.values, value) {
+		return fmt.Errorf("value %q of flag %q is not allowed, expect one of %s", value, name, strings.Join(f.values, ", "))
+	}
+	if f.dir != "" {
+		if !strings.HasPrefix(value, f.prefix) {
+			return fmt.Errorf("value %q of flag %q must start with %s", value, name, f.prefix)
+		}
+		return validateDirFile(strings.TrimPrefix(value, f.prefix), f.dir)
+	}
+	return nil
+}
+
+// validateEndpoint requires an http or https url of an object store, without credentials nor path,
+// and one of the allowed endpoints when they are configured.
+func (cfg *ConnectorConfig) validateEndpoint(name, value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil ||
+		strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("value %q of flag %q is not the http or https url of an object store", value, name)
+	}
+	if len(cfg.AllowedEndpoints) == 0 {
+		return nil
+	}
+	for _, endpoint := range cfg.AllowedEndpoints {
+		allowed, err := url.Parse(endpoint)
+		if err == nil && allowed.Scheme == u.Scheme && strings.EqualFold(allowed.Host, u.Host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("endpoint %q of flag %q is not allowed", value, name)
+}
+
+// validateDirFile requires a file of dir, one of the directories the plugin keeps the files of mounts in.
+func validateDirFile(path, dir string) error {
+	if err := validatePath(path); err != nil {
+		return err
+	}
+	if filepath.Dir(path) != dir {
+		return fmt.Errorf("path %q is not in %s", path, dir)
+	}
+	return nil
+}
+
+func containsString(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsUint32(list []uint32, v uint32) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// auditEntry records a connector request. Environment variables are never recorded as they hold credentials.
+type auditEntry struct {
+	Time     time.Time   `json:"time"`
+	Peer     *PeerCred   `json:"peer,omitempty"`
+	Op       ConnectorOp `json:"op,omitempty"`
+	VolumeID string      `json:"volumeID,omitempty"`
+	Target   string      `json:"target,omitempty"`
+	Command  string      `json:"command,omitempty"`
+	Args     []string    `json:"args,omitempty"`
+	Allowed  bool        `json:"allowed"`
+	Reason   string      `json:"reason,omitempty"`
+}
+
+// auditLogger appends one JSON line per connector request to the audit log.
+type auditLogger struct {
+	sync.Mutex
+	path string
+}
+
+func (a *auditLogger) log(peer *PeerCred, req *ConnectorRequest, reason error) {
+	entry := auditEntry{Time: time.Now(), Peer: peer, Allowed: reason == nil}
+	if req != nil {
+		entry.Op = req.Op
+		if req.Mount != nil {
+			entry.VolumeID, entry.Target, entry.Command, entry.Args = req.Mount.VolumeID, req.Mount.Target, req.Mount.Command, req.Mount.Args
+		}
+		if req.Unmount != nil {
+			entry.Target = req.Unmount.Target
+		}
+	}
+	if reason != nil {
+		entry.Reason = reason.Error()
+		klog.Warningf("connector rejected %s request from %+v: %s", entry.Op, peer, entry.Reason)
+	}
+	if a == nil || a.path == "" {
+		return
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		klog.Errorf("fail to encode audit entry: %s", err.Error())
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		klog.Errorf("fail to open audit log %s: %s", a.path, err.Error())
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		klog.Errorf("fail to write audit log %s: %s", a.path, err.Error())
+	}
+}
@@ -0,0 +1,133 @@
+package common
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// The connector protocol exchanges one request and one response per connection.
+// Each message is a frame made of a 4 bytes big endian length followed by the JSON document.
+const (
+	ConnectorProtocolVersion = 1
+
+	maxConnectorFrameSize = 16 << 20
+	// DefaultConnectorTimeout bounds a request when the caller does not set one
+	DefaultConnectorTimeout = 30 * time.Second
+)
+
+type ConnectorOp string
+
+const (
+	ConnectorOpMount      ConnectorOp = "Mount"
+	ConnectorOpUnmount    ConnectorOp = "Unmount"
+	ConnectorOpListMounts ConnectorOp = "ListMounts"
+	ConnectorOpHealth     ConnectorOp = "Health"
+)
+
+type ConnectorErrorCode string
+
+const (
+	ConnectorErrInvalidRequest     ConnectorErrorCode = "InvalidRequest"
+	ConnectorErrUnsupportedVersion ConnectorErrorCode = "UnsupportedVersion"
+	ConnectorErrPermissionDenied   ConnectorErrorCode = "PermissionDenied"
+	ConnectorErrExecFailed         ConnectorErrorCode = "ExecFailed"
+	ConnectorErrTimeout            ConnectorErrorCode = "Timeout"
+	ConnectorErrInternal           ConnectorErrorCode = "Internal"
+)
+
+type ConnectorRequest struct {
+	Version int         `json:"version"`
+	Op      ConnectorOp `json:"op"`
+	// Timeout bounds the execution of the request in the connector
+	Timeout time.Duration   `json:"timeout,omitempty"`
+	Mount   *MountRequest   `json:"mount,omitempty"`
+	Unmount *UnmountRequest `json:"unmount,omitempty"`
+}
+
+type MountRequest struct {
+	VolumeID string `json:"volumeID"`
+	Target   string `json:"target"`
+	// Backend is the storage backend of the volume, it is only journaled
+	Backend string   `json:"backend,omitempty"`
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	// SecretRef names the secret holding the credentials of the mount, it is journaled in their place
+	SecretRef string `json:"secretRef,omitempty"`
+	// Env is only passed to the fuse process, it is never logged
+	Env []string `json:"env,omitempty"`
+	// Foreground commands keep running until unmounted, the connector supervises and restarts them.
+	// It is required, background mounts are rejected since they could not be recovered
+	Foreground bool `json:"foreground,omitempty"`
+}
+
+type UnmountRequest struct {
+	Target string `json:"target"`
+	Lazy   bool   `json:"lazy,omitempty"`
+}
+
+type ConnectorResponse struct {
+	Version int             `json:"version"`
+	Output  string          `json:"output,omitempty"`
+	Mounts  []MountInfo     `json:"mounts,omitempty"`
+	Error   *ConnectorError `json:"error,omitempty"`
+}
+
+// MountInfo describes a fuse mount on the host.
+type MountInfo struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	FSType string `json:"fsType"`
+	// VolumeID, PID and Restarts are only set for fuse processes supervised by the connector
+	VolumeID string `json:"volumeID,omitempty"`
+	PID      int    `json:"pid,omitempty"`
+	Restarts int    `json:"restarts,omitempty"`
+}
+
+type ConnectorError struct {
+	Code    ConnectorErrorCode `json:"code"`
+	Message string             `json:"message"`
+}
+
+func (e *ConnectorError) Error() string {
+	return fmt.Sprintf("fuse connector %s: %s", e.Code, e.Message)
+}
+
+func newConnectorError(code ConnectorErrorCode, format string, a ...interface{}) *ConnectorError {
+	return &ConnectorError{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// writeConnectorFrame encodes v as JSON and writes it as a single frame.
+func writeConnectorFrame(w io.Writer, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxConnectorFrameSize {
+		return fmt.Errorf("connector frame of %d bytes exceeds the limit of %d bytes", len(payload), maxConnectorFrameSize)
+	}
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+	_, err = w.Write(frame)
+	return err
+}
+
+// readConnectorFrame reads a single frame and decodes its JSON into v.
+func readConnectorFrame(r io.Reader, v interface{}) error {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxConnectorFrameSize {
+		return fmt.Errorf("connector frame of %d bytes exceeds the limit of %d bytes", size, maxConnectorFrameSize)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, v)
+}
@@ -0,0 +1,88 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// MountCredentialFile returns the host path of the credential file of a mount, keyed by volume ID and target path.
+func MountCredentialFile(volumeID, targetPath string) string {
+	sum := sha256.Sum256([]byte(volumeID + "\x00" + targetPath))
+	return filepath.Join(CredentialDir, hex.EncodeToString(sum[:16]))
+}
+
+// MountKeyFile returns the host path of the encryption key file of a mount, next to its credential file.
+func MountKeyFile(volumeID, targetPath string) string {
+	return MountCredentialFile(volumeID, targetPath) + ".key"
+}
+
+// MountMetricsSocket returns the host path of the socket the builtin fuse process of a mount serves its metrics on.
+func MountMetricsSocket(volumeID, targetPath string) string {
+	sum := sha256.Sum256([]byte(volumeID + "\x00" + targetPath))
+	return filepath.Join(MetricsDir, hex.EncodeToString(sum[:16])+".sock")
+}
+
+// WriteMountCredentials writes content to the credential file of a mount and returns its host path.
+// The file is created with 0600 permissions and replaced atomically, so concurrent mounts never see partial content.
+func WriteMountCredentials(volumeID, targetPath, content string) (string, error) {
+	return writeMountFile(MountCredentialFile(volumeID, targetPath), targetPath, content)
+}
+
+// WriteMountKey writes content to the encryption key file of a mount and returns its host path, like WriteMountCredentials.
+func WriteMountKey(volumeID, targetPath, content string) (string, error) {
+	return writeMountFile(MountKeyFile(volumeID, targetPath), targetPath, content)
+}
+
+// CheckCredentialDir makes sure the credential directory of the host is kept in memory, so secrets written there
+// never reach a persistent disk.
+func CheckCredentialDir() error {
+	dir := filepath.Join(HostDir, CredentialDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	memory, err := isMemoryFs(dir)
+	if err != nil {
+		return fmt.Errorf("fail to check the filesystem of %s: %s", dir, err.Error())
+	}
+	if !memory {
+		return fmt.Errorf("%s is not a tmpfs, refuse to write credentials to a persistent disk", dir)
+	}
+	return nil
+}
+
+func writeMountFile(file, targetPath, content string) (string, error) {
+	// the host may have remounted /run since the plugin started
+	if err := CheckCredentialDir(); err != nil {
+		return "", err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Join(HostDir, CredentialDir), ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	// CreateTemp opens the file with 0600 permissions
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.WriteString(content); err != nil {
+		tmpFile.Close()
+		return "", err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpFile.Name(), filepath.Join(HostDir, file)); err != nil {
+		return "", fmt.Errorf("fail to save credential file of %s: %s", targetPath, err.Error())
+	}
+	return file, nil
+}
+
+// RemoveMountCredentials removes the credential and key files of a mount, if any.
+func RemoveMountCredentials(volumeID, targetPath string) error {
+	for _, file := range []string{MountCredentialFile(volumeID, targetPath), MountKeyFile(volumeID, targetPath)} {
+		if err := os.Remove(filepath.Join(HostDir, file)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
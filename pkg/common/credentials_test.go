@@ -0,0 +1,18 @@
+package common
+
+import (
+	"github.com/smartystreets/goconvey/convey"
+	"path/filepath"
+	"testing"
+)
+
+func TestMountCredentialFile(t *testing.T) {
+	convey.Convey("test MountCredentialFile", t, func() {
+		file := MountCredentialFile("fuse-1", "/var/lib/kubelet/pods/a/volumes/kubernetes.io~csi/fuse-1/mount")
+		convey.So(filepath.Dir(file), convey.ShouldEqual, CredentialDir)
+		convey.So(file, convey.ShouldEqual, MountCredentialFile("fuse-1", "/var/lib/kubelet/pods/a/volumes/kubernetes.io~csi/fuse-1/mount"))
+		convey.So(file, convey.ShouldNotEqual, MountCredentialFile("fuse-1", "/var/lib/kubelet/pods/b/volumes/kubernetes.io~csi/fuse-1/mount"))
+		convey.So(file, convey.ShouldNotEqual, MountCredentialFile("fuse-2", "/var/lib/kubelet/pods/a/volumes/kubernetes.io~csi/fuse-1/mount"))
+		convey.So(MountKeyFile("fuse-1", "/var/lib/kubelet/pods/a/volumes/kubernetes.io~csi/fuse-1/mount"), convey.ShouldEqual, file+".key")
+	})
+}
@@ -1,16 +1,49 @@
 package common
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"github.com/sevlyar/go-daemon"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/mount"
 	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
-func RunConnector() {
+// connectorIOTimeout is the time granted on top of the request timeout to exchange frames.
+const connectorIOTimeout = 5 * time.Second
+
+// connectorServer serves the requests of authorized peers according to its config.
+type connectorServer struct {
+	cfg        *ConnectorConfig
+	audit      *auditLogger
+	journal    *mountJournal
+	supervisor *fuseSupervisor
+}
+
+func newConnectorServer(cfg *ConnectorConfig) *connectorServer {
+	var journal *mountJournal
+	if cfg.Journal != "" {
+		var err error
+		if journal, err = openMountJournal(cfg.Journal); err != nil {
+			klog.Errorf("fail to load mount journal %s, starting with an empty one: %s", cfg.Journal, err.Error())
+			journal = &mountJournal{path: cfg.Journal, entries: map[string]*JournalEntry{}}
+		}
+	}
+	return &connectorServer{
+		cfg:        cfg,
+		audit:      &auditLogger{path: cfg.AuditLog},
+		journal:    journal,
+		supervisor: newFuseSupervisor(journal),
+	}
+}
+
+func RunConnector(cfg *ConnectorConfig) {
 	cntxt := &daemon.Context{
 		PidFileName: ConnectorPIDFilename,
 		PidFilePerm: 0644,
@@ -34,36 +67,43 @@ func RunConnector() {
 	}()
 	klog.Info("Fuse Connector Daemon Is Starting...")
 
-	runFuseProxy()
+	s := newConnectorServer(cfg)
+	s.recoverMounts()
+	s.runFuseProxy()
 }
 
-func ConnectorRunInContainer(cmd string) (string, error) {
+// CallConnector sends a request to the fuse connector on the host and waits for its response.
+// Failures reported by the connector are returned as *ConnectorError.
+func CallConnector(req *ConnectorRequest) (*ConnectorResponse, error) {
+	req.Version = ConnectorProtocolVersion
+	if req.Timeout <= 0 {
+		req.Timeout = DefaultConnectorTimeout
+	}
+
 	c, err := net.Dial("unix", filepath.Join(HostDir, ConnectorSocketPath))
 	if err != nil {
 		klog.Infof("Fuse connector Dial error: %v", err)
-		return err.Error(), err
+		return nil, err
 	}
 	defer c.Close()
-
-	_, err = c.Write([]byte(cmd))
-	if err != nil {
-		return err.Error(), err
+	if err := c.SetDeadline(time.Now().Add(req.Timeout + connectorIOTimeout)); err != nil {
+		return nil, err
 	}
 
-	buf := make([]byte, 2048)
-	n, err := c.Read(buf[:])
-	if err != nil {
-		return err.Error(), err
+	if err := writeConnectorFrame(c, req); err != nil {
+		return nil, err
+	}
+	resp := &ConnectorResponse{}
+	if err := readConnectorFrame(bufio.NewReader(c), resp); err != nil {
+		return nil, err
 	}
-	response := string(buf[0:n])
-	if strings.HasPrefix(response, "Success") {
-		respstr := response[8:]
-		return respstr, nil
+	if resp.Error != nil {
+		return resp, resp.Error
 	}
-	return response, errors.New("Fuse connector exec command error:" + response)
+	return resp, nil
 }
 
-func runFuseProxy() {
+func (s *connectorServer) runFuseProxy() {
 	if IsDirExisting(ConnectorSocketPath) {
 		if err := os.Remove(ConnectorSocketPath); err != nil {
 			klog.Fatalf("fail to remove connector socket: %s", err.Error())
@@ -91,28 +131,197 @@ func runFuseProxy() {
 			klog.Infof("Server Accept error: %s", err.Error())
 			continue
 		}
-		go echoServer(fd)
+		go s.serveConnectorConn(fd)
 	}
 }
 
-func echoServer(c net.Conn) {
-	buf := make([]byte, 2048)
-	nr, err := c.Read(buf)
+func (s *connectorServer) serveConnectorConn(c net.Conn) {
+	defer c.Close()
+
+	peer, err := getPeerCred(c)
+	if err == nil {
+		err = s.cfg.authorizePeer(peer)
+	}
 	if err != nil {
+		s.audit.log(peer, nil, err)
+		_ = c.SetWriteDeadline(time.Now().Add(connectorIOTimeout))
+		_ = writeConnectorFrame(c, &ConnectorResponse{
+			Version: ConnectorProtocolVersion,
+			Error:   newConnectorError(ConnectorErrPermissionDenied, "peer is not allowed: %s", err.Error()),
+		})
+		return
+	}
+
+	req := &ConnectorRequest{}
+	_ = c.SetReadDeadline(time.Now().Add(connectorIOTimeout))
+	if err := readConnectorFrame(bufio.NewReader(c), req); err != nil {
 		klog.Infof("Server Read error: %s", err.Error())
+		_ = writeConnectorFrame(c, &ConnectorResponse{
+			Version: ConnectorProtocolVersion,
+			Error:   newConnectorError(ConnectorErrInvalidRequest, "fail to read request: %s", err.Error()),
+		})
 		return
 	}
 
-	cmd := string(buf[0:nr])
-	klog.Infof("server receive csi command: %s", cmd)
-	// run command
-	if out, err := RunCommand(cmd); err != nil {
-		reply := "Fail: " + cmd + ", error: " + err.Error()
-		_, _ = c.Write([]byte(reply))
-		klog.Info("server fail to run cmd: ", reply)
-	} else {
-		out = "Success:" + out
-		_, _ = c.Write([]byte(out))
-		klog.Info("server success to run cmd: ", out)
+	resp := s.handleConnectorRequest(peer, req)
+	resp.Version = ConnectorProtocolVersion
+	_ = c.SetWriteDeadline(time.Now().Add(connectorIOTimeout))
+	if err := writeConnectorFrame(c, resp); err != nil {
+		klog.Infof("Server Write error: %s", err.Error())
+	}
+}
+
+func (s *connectorServer) handleConnectorRequest(peer *PeerCred, req *ConnectorRequest) *ConnectorResponse {
+	if req.Version != ConnectorProtocolVersion {
+		return &ConnectorResponse{Error: newConnectorError(ConnectorErrUnsupportedVersion, "protocol version %d is not supported, expect %d", req.Version, ConnectorProtocolVersion)}
+	}
+	if err := s.cfg.validateRequest(req); err != nil {
+		s.audit.log(peer, req, err)
+		return &ConnectorResponse{Error: newConnectorError(ConnectorErrPermissionDenied, "request is not allowed: %s", err.Error())}
+	}
+	if req.Op == ConnectorOpMount || req.Op == ConnectorOpUnmount {
+		s.audit.log(peer, req, nil)
+	}
+	timeout := req.Timeout
+	if timeout <= 0 {
+		timeout = DefaultConnectorTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var (
+		resp = &ConnectorResponse{}
+		err  *ConnectorError
+	)
+	switch req.Op {
+	case ConnectorOpMount:
+		resp.Output, err = s.connectorMount(ctx, req.Mount)
+	case ConnectorOpUnmount:
+		resp.Output, err = s.connectorUnmount(ctx, req.Unmount)
+	case ConnectorOpListMounts:
+		resp.Mounts, err = s.connectorListMounts()
+	case ConnectorOpHealth:
+		resp.Output = "ok"
+	default:
+		err = newConnectorError(ConnectorErrInvalidRequest, "unknown operation %q", req.Op)
+	}
+	if err != nil {
+		klog.Infof("server fail to run %s: %s", req.Op, err.Error())
+		resp.Error = err
+	}
+	return resp
+}
+
+func (s *connectorServer) connectorMount(ctx context.Context, req *MountRequest) (string, *ConnectorError) {
+	if req == nil || req.Target == "" || req.Command == "" {
+		return "", newConnectorError(ConnectorErrInvalidRequest, "mount request needs a target and a command")
+	}
+	// only supervised mounts are journaled, a daemonized fuse process could not be recovered
+	if !req.Foreground {
+		return "", newConnectorError(ConnectorErrInvalidRequest, "mount request of %s must run its command in the foreground", req.Target)
+	}
+	klog.Infof("server mount volume %s to %s with command: %s and args: %s", req.VolumeID, req.Target, req.Command, req.Args)
+	return s.supervisor.mount(ctx, req)
+}
+
+func (s *connectorServer) connectorUnmount(ctx context.Context, req *UnmountRequest) (string, *ConnectorError) {
+	if req == nil || req.Target == "" {
+		return "", newConnectorError(ConnectorErrInvalidRequest, "unmount request needs a target")
+	}
+	klog.Infof("server unmount %s, lazy: %t", req.Target, req.Lazy)
+
+	args := []string{req.Target}
+	if req.Lazy {
+		args = append([]string{"-l"}, args...)
+	}
+	var out string
+	var cerr *ConnectorError
+	// a busy mount is still supervised, its fuse process is restarted if it exits
+	_ = s.supervisor.unmount(req.Target, func() error {
+		out, cerr = runConnectorCommand(ctx, nil, "umount", args...)
+		if cerr != nil {
+			return cerr
+		}
+		return nil
+	})
+	if cerr == nil {
+		s.journal.remove(req.Target)
+	}
+	return out, cerr
+}
+
+// recoverMounts reconciles the journal with the mounts of the host when the connector starts.
+// Mounts that are gone are forgotten, live fuse processes are supervised again, and disconnected
+// mounts are remounted unless their credentials were passed in the environment.
+func (s *connectorServer) recoverMounts() {
+	mounts, err := s.supervisor.mountinfo()
+	if err != nil {
+		klog.Errorf("fail to list mounts, skip recovering the journal: %s", err.Error())
+		return
+	}
+	mounted := map[string]bool{}
+	for _, m := range mounts {
+		mounted[m.Target] = true
+	}
+
+	for _, entry := range s.journal.list() {
+		if !mounted[entry.Target] {
+			klog.Infof("volume %s is no longer mounted on %s, removing it from the journal", entry.VolumeID, entry.Target)
+			s.journal.remove(entry.Target)
+			continue
+		}
+		if _, err := os.Stat(entry.Target); err == nil {
+			if processAlive(entry.PID, entry.Target) {
+				s.supervisor.adopt(entry)
+			}
+			continue
+		} else if !mount.IsCorruptedMnt(err) {
+			klog.Warningf("fail to check mount %s: %s", entry.Target, err.Error())
+			continue
+		}
+		if len(entry.EnvNames) != 0 {
+			// left to the node plugin, which publishes it again with its credentials
+			klog.Warningf("volume %s is disconnected on %s and its credentials were not journaled", entry.VolumeID, entry.Target)
+			s.journal.remove(entry.Target)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultConnectorTimeout)
+		if _, err := runConnectorCommand(ctx, nil, "umount", "-l", entry.Target); err != nil {
+			klog.Warningf("fail to detach dead mount %s: %s", entry.Target, err.Error())
+		}
+		req := entry.mountRequest()
+		if _, err := s.supervisor.mount(ctx, &req); err != nil {
+			klog.Errorf("fail to recover volume %s on %s: %s", entry.VolumeID, entry.Target, err.Error())
+			s.journal.remove(entry.Target)
+		} else {
+			klog.Infof("volume %s recovered on %s", entry.VolumeID, entry.Target)
+		}
+		cancel()
+	}
+}
+
+func (s *connectorServer) connectorListMounts() ([]MountInfo, *ConnectorError) {
+	mounts, err := ListFuseMounts()
+	if err != nil {
+		return nil, newConnectorError(ConnectorErrInternal, "fail to list mounts: %s", err.Error())
+	}
+	s.supervisor.annotate(mounts)
+	return mounts, nil
+}
+
+// runConnectorCommand runs a command without a shell, with envs added to the environment of the process.
+func runConnectorCommand(ctx context.Context, envs []string, name string, args ...string) (string, *ConnectorError) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if len(envs) != 0 {
+		cmd.Env = append(os.Environ(), envs...)
+	}
+	out, err := cmd.CombinedOutput()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return string(out), newConnectorError(ConnectorErrTimeout, "%s %s timed out, with out: %s", name, strings.Join(args, " "), string(out))
+	}
+	if err != nil {
+		return string(out), newConnectorError(ConnectorErrExecFailed, "%s %s failed, with out: %s, with error: %s", name, strings.Join(args, " "), string(out), err.Error())
 	}
+	return string(out), nil
 }
@@ -0,0 +1,336 @@
+package common
+
+import (
+	"context"
+	"k8s.io/klog/v2"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// maxFuseOutput is the tail of the fuse process output kept to report failures
+	maxFuseOutput = 4096
+
+	fuseReadyInterval   = 100 * time.Millisecond
+	fuseAdoptedInterval = time.Second
+	fuseRestartDelay    = time.Second
+	fuseRestartMaxDelay = 5 * time.Minute
+)
+
+// fuseProcess is a foreground fuse process started by the connector, or adopted from a previous connector.
+type fuseProcess struct {
+	req      MountRequest
+	pid      int
+	output   *tailBuffer
+	exited   chan struct{}
+	status   string
+	restarts int
+	// restartable is false for adopted processes whose environment was not journaled
+	restartable bool
+}
+
+// fuseSupervisor tracks the fuse processes started by the connector, by mount target,
+// and remounts the ones that exit while their mount is still wanted.
+type fuseSupervisor struct {
+	sync.Mutex
+	processes map[string]*fuseProcess
+	// stopped is closed when the target is unmounted, to cancel pending restarts
+	stopped map[string]chan struct{}
+	// unmounting is closed when the unmount in progress of the target is done, restarts wait for its outcome
+	unmounting map[string]chan struct{}
+	journal    *mountJournal
+	// command and mountinfo reach the host, they are replaced in tests
+	command   func(req *MountRequest) *exec.Cmd
+	mountinfo func() ([]MountInfo, error)
+}
+
+func newFuseSupervisor(journal *mountJournal) *fuseSupervisor {
+	return &fuseSupervisor{
+		processes:  map[string]*fuseProcess{},
+		stopped:    map[string]chan struct{}{},
+		unmounting: map[string]chan struct{}{},
+		journal:    journal,
+		command:    scopedFuseCommand,
+		mountinfo:  ListFuseMounts,
+	}
+}
+
+// mount starts a supervised fuse process and waits until its mount is ready.
+// It returns without starting anything when the target is already served by a live process.
+func (s *fuseSupervisor) mount(ctx context.Context, req *MountRequest) (string, *ConnectorError) {
+	s.Lock()
+	if p, ok := s.processes[req.Target]; ok {
+		s.Unlock()
+		select {
+		case <-p.exited:
+			return "", newConnectorError(ConnectorErrExecFailed, "fuse process of %s exited, it is being restarted", req.Target)
+		default:
+			klog.Infof("fuse process %d already serves %s", p.pid, req.Target)
+			return "", nil
+		}
+	}
+	if _, ok := s.stopped[req.Target]; ok {
+		s.Unlock()
+		return "", newConnectorError(ConnectorErrExecFailed, "mount of %s is already in progress", req.Target)
+	}
+	s.stopped[req.Target] = make(chan struct{})
+	s.Unlock()
+
+	p, err := s.start(ctx, req)
+	if err != nil {
+		s.Lock()
+		delete(s.stopped, req.Target)
+		s.Unlock()
+		return p.output.String(), err
+	}
+
+	s.Lock()
+	s.processes[req.Target] = p
+	s.Unlock()
+	s.journal.put(newJournalEntry(req, p.pid))
+	go s.watch(p)
+	return "", nil
+}
+
+// adopt supervises a fuse process left by a previous connector, which can only be polled as it is not a child.
+func (s *fuseSupervisor) adopt(entry *JournalEntry) {
+	p := &fuseProcess{
+		req:         entry.mountRequest(),
+		pid:         entry.PID,
+		output:      &tailBuffer{},
+		exited:      make(chan struct{}),
+		restartable: len(entry.EnvNames) == 0,
+	}
+	go func() {
+		for processAlive(p.pid, p.req.Target) {
+			time.Sleep(fuseAdoptedInterval)
+		}
+		p.status = "adopted process exited"
+		close(p.exited)
+	}()
+
+	s.Lock()
+	s.processes[entry.Target] = p
+	s.stopped[entry.Target] = make(chan struct{})
+	s.Unlock()
+	klog.Infof("adopted fuse process %d of volume %s on %s", p.pid, entry.VolumeID, entry.Target)
+	go s.watch(p)
+}
+
+// start runs the fuse process and waits for its mount to show up in mountinfo.
+func (s *fuseSupervisor) start(ctx context.Context, req *MountRequest) (*fuseProcess, *ConnectorError) {
+	p := &fuseProcess{req: *req, output: &tailBuffer{}, exited: make(chan struct{}), restartable: true}
+	cmd := s.command(req)
+	cmd.Stdout, cmd.Stderr = p.output, p.output
+	if len(req.Env) != 0 {
+		cmd.Env = append(os.Environ(), req.Env...)
+	}
+	if err := cmd.Start(); err != nil {
+		close(p.exited)
+		return p, newConnectorError(ConnectorErrExecFailed, "fail to start %s: %s", req.Command, err.Error())
+	}
+	p.pid = cmd.Process.Pid
+	go func() {
+		_ = cmd.Wait()
+		p.status = cmd.ProcessState.String()
+		close(p.exited)
+	}()
+
+	ticker := time.NewTicker(fuseReadyInterval)
+	defer ticker.Stop()
+	for {
+		if s.isMounted(req.Target) {
+			klog.Infof("fuse process %d of volume %s is ready on %s", p.pid, req.VolumeID, req.Target)
+			return p, nil
+		}
+		select {
+		case <-p.exited:
+			return p, newConnectorError(ConnectorErrExecFailed, "%s exited before mounting %s: %s, with out: %s", req.Command, req.Target, p.status, p.output.String())
+		case <-ctx.Done():
+			_ = cmd.Process.Kill()
+			<-p.exited
+			return p, newConnectorError(ConnectorErrTimeout, "timeout waiting for %s to mount %s, with out: %s", req.Command, req.Target, p.output.String())
+		case <-ticker.C:
+		}
+	}
+}
+
+// scopedFuseCommand runs the fuse command in its own scope, so it survives restarts of the connector.
+// systemd-run --scope executes the command in place, so the child is the fuse process itself.
+func scopedFuseCommand(req *MountRequest) *exec.Cmd {
+	return exec.Command("systemd-run", append([]string{"--scope", "--", req.Command}, req.Args...)...)
+}
+
+// watch waits for a fuse process to exit, and remounts its target with backoff unless it was unmounted.
+func (s *fuseSupervisor) watch(p *fuseProcess) {
+	<-p.exited
+	target := p.req.Target
+	s.waitUnmount(target)
+
+	s.Lock()
+	stopped, wanted := s.stopped[target]
+	if !wanted || s.processes[target] != p {
+		s.Unlock()
+		return
+	}
+	if !p.restartable {
+		// the mount is left disconnected, the node plugin publishes it again with its credentials
+		delete(s.processes, target)
+		delete(s.stopped, target)
+		s.Unlock()
+		s.journal.remove(target)
+		klog.Warningf("fuse process %d of volume %s exited and cannot be restarted by the connector", p.pid, p.req.VolumeID)
+		return
+	}
+	s.Unlock()
+	klog.Warningf("fuse process %d of volume %s exited unexpectedly: %s, with out: %s", p.pid, p.req.VolumeID, p.status, p.output.String())
+
+	delay := fuseRestartDelay
+	for restarts := p.restarts + 1; ; restarts++ {
+		s.waitUnmount(target)
+		select {
+		case <-stopped:
+			return
+		default:
+		}
+		// the dead mount answers "transport endpoint is not connected" until it is detached
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultConnectorTimeout)
+		if _, err := runConnectorCommand(ctx, nil, "umount", "-l", target); err != nil {
+			klog.Warningf("fail to detach dead mount %s: %s", target, err.Error())
+		}
+		next, err := s.start(ctx, &p.req)
+		cancel()
+
+		s.Lock()
+		if s.stopped[target] != stopped {
+			// unmounted while restarting
+			s.Unlock()
+			if err == nil {
+				_ = syscall.Kill(next.pid, syscall.SIGKILL)
+			}
+			return
+		}
+		if err == nil {
+			next.restarts = restarts
+			s.processes[target] = next
+			s.Unlock()
+			s.journal.put(newJournalEntry(&next.req, next.pid))
+			klog.Infof("volume %s remounted on %s after %d restarts", p.req.VolumeID, target, restarts)
+			go s.watch(next)
+			return
+		}
+		s.Unlock()
+
+		klog.Errorf("fail to remount volume %s on %s, retrying in %s: %s", p.req.VolumeID, target, delay, err.Error())
+		select {
+		case <-stopped:
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > fuseRestartMaxDelay {
+			delay = fuseRestartMaxDelay
+		}
+	}
+}
+
+// unmount unmounts target with umount, and stops supervising it once it is unmounted. A fuse process exiting
+// meanwhile is restarted only if the unmount fails, e.g. while the mount is busy.
+func (s *fuseSupervisor) unmount(target string, umount func() error) error {
+	done := make(chan struct{})
+	s.Lock()
+	s.unmounting[target] = done
+	s.Unlock()
+
+	err := umount()
+
+	s.Lock()
+	defer s.Unlock()
+	if s.unmounting[target] == done {
+		delete(s.unmounting, target)
+	}
+	close(done)
+	if err != nil {
+		return err
+	}
+	if stopped, ok := s.stopped[target]; ok {
+		close(stopped)
+		delete(s.stopped, target)
+	}
+	delete(s.processes, target)
+	return nil
+}
+
+// waitUnmount waits for the unmount in progress of target, if any.
+func (s *fuseSupervisor) waitUnmount(target string) {
+	s.Lock()
+	done, ok := s.unmounting[target]
+	s.Unlock()
+	if ok {
+		<-done
+	}
+}
+
+// annotate adds the supervision state of the fuse processes to mounts.
+func (s *fuseSupervisor) annotate(mounts []MountInfo) {
+	s.Lock()
+	defer s.Unlock()
+	for i := range mounts {
+		if p, ok := s.processes[mounts[i].Target]; ok {
+			mounts[i].VolumeID = p.req.VolumeID
+			mounts[i].PID = p.pid
+			mounts[i].Restarts = p.restarts
+		}
+	}
+}
+
+func (s *fuseSupervisor) isMounted(target string) bool {
+	mounts, err := s.mountinfo()
+	if err != nil {
+		klog.Warningf("fail to list mounts: %s", err.Error())
+		return false
+	}
+	for _, m := range mounts {
+		if m.Target == target {
+			return true
+		}
+	}
+	return false
+}
+
+// processAlive tells whether pid still runs the fuse process of target, and was not reused by another process.
+func processAlive(pid int, target string) bool {
+	if pid <= 0 {
+		return false
+	}
+	cmdLine, err := getCmdLine(pid)
+	if err != nil {
+		return false
+	}
+	return strings.Contains(cmdLine, target)
+}
+
+// tailBuffer keeps the last maxFuseOutput bytes written to it.
+type tailBuffer struct {
+	sync.Mutex
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > maxFuseOutput {
+		b.buf = b.buf[len(b.buf)-maxFuseOutput:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return string(b.buf)
+}
@@ -0,0 +1,62 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"github.com/smartystreets/goconvey/convey"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFuseSupervisor(t *testing.T) {
+	convey.Convey("test fuse supervisor", t, func() {
+		s := newFuseSupervisor(nil)
+		s.command = func(req *MountRequest) *exec.Cmd {
+			return exec.Command(req.Command, req.Args...)
+		}
+		s.mountinfo = func() ([]MountInfo, error) {
+			return []MountInfo{{Target: "/mnt/supervised"}}, nil
+		}
+		req := &MountRequest{VolumeID: "fuse-1", Target: "/mnt/supervised", Command: "sleep", Args: []string{"0.2"}, Foreground: true}
+
+		_, err := s.mount(context.Background(), req)
+		convey.So(err, convey.ShouldBeNil)
+
+		restarted := func() bool {
+			mounts := []MountInfo{{Target: req.Target}}
+			s.annotate(mounts)
+			return mounts[0].Restarts > 0 && mounts[0].VolumeID == req.VolumeID
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for !restarted() && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+		convey.So(restarted(), convey.ShouldBeTrue)
+
+		// a busy mount stays supervised
+		convey.So(s.unmount(req.Target, func() error { return errors.New("target is busy") }), convey.ShouldNotBeNil)
+		mounts := []MountInfo{{Target: req.Target}}
+		s.annotate(mounts)
+		restarts := mounts[0].Restarts
+		deadline = time.Now().Add(5 * time.Second)
+		for mounts[0].Restarts == restarts && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+			s.annotate(mounts)
+		}
+		convey.So(mounts[0].Restarts, convey.ShouldBeGreaterThan, restarts)
+
+		convey.So(s.unmount(req.Target, func() error { return nil }), convey.ShouldBeNil)
+		mounts = []MountInfo{{Target: req.Target}}
+		s.annotate(mounts)
+		convey.So(mounts[0].PID, convey.ShouldEqual, 0)
+
+		convey.Convey("processes exiting before the mount is ready fail", func() {
+			s.mountinfo = func() ([]MountInfo, error) { return nil, nil }
+			out, err := s.mount(context.Background(), &MountRequest{Target: "/mnt/failed", Command: "sh", Args: []string{"-c", "echo bad bucket; exit 1"}})
+			convey.So(err, convey.ShouldNotBeNil)
+			convey.So(strings.TrimSpace(out), convey.ShouldEqual, "bad bucket")
+		})
+	})
+}
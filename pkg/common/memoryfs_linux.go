@@ -0,0 +1,19 @@
+package common
+
+import (
+	"syscall"
+)
+
+const (
+	tmpfsMagic = 0x01021994
+	ramfsMagic = 0x858458f6
+)
+
+// isMemoryFs reports whether path is on a filesystem kept in memory, a tmpfs or a ramfs.
+func isMemoryFs(path string) (bool, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return false, err
+	}
+	return fs.Type == tmpfsMagic || fs.Type == ramfsMagic, nil
+}
@@ -0,0 +1,21 @@
+package common
+
+import (
+	"github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestIsMemoryFs(t *testing.T) {
+	convey.Convey("test filesystems kept in memory", t, func() {
+		memory, err := isMemoryFs("/dev/shm")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(memory, convey.ShouldBeTrue)
+
+		memory, err = isMemoryFs("/proc")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(memory, convey.ShouldBeFalse)
+
+		_, err = isMemoryFs("/not/exist")
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package common
+
+import (
+	"fmt"
+)
+
+// isMemoryFs is only supported on linux, so no path is trusted to be kept in memory elsewhere.
+func isMemoryFs(path string) (bool, error) {
+	return false, fmt.Errorf("filesystem types are not supported on this platform")
+}
@@ -0,0 +1,160 @@
+package common
+
+import (
+	"encoding/json"
+	"k8s.io/klog/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JournalEntry is a mount managed by the connector. Credentials are never journaled:
+// SecretRef names the secret they come from, and only the names of the environment variables are kept.
+type JournalEntry struct {
+	VolumeID  string    `json:"volumeID"`
+	Target    string    `json:"target"`
+	Backend   string    `json:"backend,omitempty"`
+	Command   string    `json:"command"`
+	Args      []string  `json:"args,omitempty"`
+	SecretRef string    `json:"secretRef,omitempty"`
+	EnvNames  []string  `json:"envNames,omitempty"`
+	PID       int       `json:"pid,omitempty"`
+	MountedAt time.Time `json:"mountedAt"`
+}
+
+func newJournalEntry(req *MountRequest, pid int) *JournalEntry {
+	entry := &JournalEntry{
+		VolumeID:  req.VolumeID,
+		Target:    req.Target,
+		Backend:   req.Backend,
+		Command:   req.Command,
+		Args:      req.Args,
+		SecretRef: req.SecretRef,
+		PID:       pid,
+		MountedAt: time.Now(),
+	}
+	for _, env := range req.Env {
+		name, _, _ := strings.Cut(env, "=")
+		entry.EnvNames = append(entry.EnvNames, name)
+	}
+	return entry
+}
+
+// mountRequest rebuilds the request of the entry, without the environment which was not journaled.
+func (e *JournalEntry) mountRequest() MountRequest {
+	return MountRequest{
+		VolumeID:   e.VolumeID,
+		Target:     e.Target,
+		Backend:    e.Backend,
+		Command:    e.Command,
+		Args:       e.Args,
+		SecretRef:  e.SecretRef,
+		Foreground: true,
+	}
+}
+
+// mountJournal persists the mounts of the connector by target. A nil journal records nothing.
+type mountJournal struct {
+	sync.Mutex
+	path    string
+	entries map[string]*JournalEntry
+}
+
+// openMountJournal loads the journal at path, which may not exist yet.
+func openMountJournal(path string) (*mountJournal, error) {
+	j := &mountJournal{path: path, entries: map[string]*JournalEntry{}}
+	entries, err := ReadMountJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		j.entries[entries[i].Target] = &entries[i]
+	}
+	return j, nil
+}
+
+// ReadMountJournal returns the entries of the journal at path, sorted by target.
+func ReadMountJournal(path string) ([]JournalEntry, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []JournalEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, k int) bool { return entries[i].Target < entries[k].Target })
+	return entries, nil
+}
+
+func (j *mountJournal) put(entry *JournalEntry) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	j.entries[entry.Target] = entry
+	j.save()
+}
+
+func (j *mountJournal) remove(target string) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	if _, ok := j.entries[target]; !ok {
+		return
+	}
+	delete(j.entries, target)
+	j.save()
+}
+
+func (j *mountJournal) list() []*JournalEntry {
+	if j == nil {
+		return nil
+	}
+	j.Lock()
+	defer j.Unlock()
+	var entries []*JournalEntry
+	for _, entry := range j.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, k int) bool { return entries[i].Target < entries[k].Target })
+	return entries
+}
+
+// save replaces the journal file atomically, the caller holds the lock.
+func (j *mountJournal) save() {
+	entries := []*JournalEntry{}
+	for _, entry := range j.entries {
+		entries = append(entries, entry)
+	}
+	content, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		klog.Errorf("fail to encode mount journal: %s", err.Error())
+		return
+	}
+	f, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".tmp-")
+	if err != nil {
+		klog.Errorf("fail to save mount journal %s: %s", j.path, err.Error())
+		return
+	}
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), j.path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		klog.Errorf("fail to save mount journal %s: %s", j.path, err.Error())
+	}
+}
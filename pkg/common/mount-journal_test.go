@@ -0,0 +1,52 @@
+package common
+
+import (
+	"github.com/smartystreets/goconvey/convey"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMountJournal(t *testing.T) {
+	convey.Convey("test mount journal", t, func() {
+		path := filepath.Join(t.TempDir(), "connector-journal.json")
+		journal, err := openMountJournal(path)
+		convey.So(err, convey.ShouldBeNil)
+
+		journal.put(newJournalEntry(&MountRequest{
+			VolumeID:  "fuse-1",
+			Target:    "/mnt/b",
+			Backend:   "s3minio",
+			Command:   "s3fs",
+			Args:      []string{"bucket:/", "/mnt/b", "-f"},
+			SecretRef: "kube-system/open-object",
+			Env:       []string{"AWSSECRETACCESSKEY=very-secret"},
+		}, 42))
+		journal.put(newJournalEntry(&MountRequest{VolumeID: "fuse-2", Target: "/mnt/a", Command: "s3fs"}, 0))
+
+		content, err := os.ReadFile(path)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(strings.Contains(string(content), "very-secret"), convey.ShouldBeFalse)
+
+		entries, err := ReadMountJournal(path)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(entries, convey.ShouldHaveLength, 2)
+		convey.So(entries[0].Target, convey.ShouldEqual, "/mnt/a")
+		convey.So(entries[1].PID, convey.ShouldEqual, 42)
+		convey.So(entries[1].EnvNames, convey.ShouldResemble, []string{"AWSSECRETACCESSKEY"})
+		convey.So(entries[1].SecretRef, convey.ShouldEqual, "kube-system/open-object")
+
+		convey.Convey("entries no longer mounted are removed on startup", func() {
+			cfg := DefaultConnectorConfig()
+			cfg.AuditLog, cfg.Journal = "", path
+			s := newConnectorServer(cfg)
+			s.supervisor.mountinfo = func() ([]MountInfo, error) { return nil, nil }
+			s.recoverMounts()
+
+			entries, err := ReadMountJournal(path)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(entries, convey.ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,71 @@
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const mountInfoPath = "/proc/self/mountinfo"
+
+// ListFuseMounts returns the fuse mounts of the current mount namespace.
+func ListFuseMounts() ([]MountInfo, error) {
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseFuseMounts(f)
+}
+
+// parseFuseMounts parses mountinfo lines, whose format is
+// "id parent major:minor root target options [optional...] - fstype source superoptions".
+func parseFuseMounts(r io.Reader) ([]MountInfo, error) {
+	var mounts []MountInfo
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i, field := range fields {
+			if field == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 5 || len(fields) < sep+3 {
+			return nil, fmt.Errorf("malformed mountinfo line: %q", scanner.Text())
+		}
+		fsType := fields[sep+1]
+		if fsType != "fuse" && !strings.HasPrefix(fsType, "fuse.") {
+			continue
+		}
+		mounts = append(mounts, MountInfo{
+			Source: unescapeMountInfo(fields[sep+2]),
+			Target: unescapeMountInfo(fields[4]),
+			FSType: fsType,
+		})
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountInfo decodes the octal escapes, e.g. \040 for a space, used by the kernel in mountinfo.
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
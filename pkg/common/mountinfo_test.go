@@ -0,0 +1,25 @@
+package common
+
+import (
+	"github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+)
+
+func TestParseFuseMounts(t *testing.T) {
+	convey.Convey("test parseFuseMounts", t, func() {
+		mountInfo := `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+635 22 0:55 / /var/lib/kubelet/pods/a/volumes/kubernetes.io~csi/fuse-1/mount rw,nosuid,nodev,relatime shared:300 - fuse.s3fs s3fs rw,user_id=0,group_id=0,allow_other
+636 22 0:56 / /mnt/with\040space rw,relatime - fuse rclone:bucket rw
+`
+		mounts, err := parseFuseMounts(strings.NewReader(mountInfo))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(mounts, convey.ShouldResemble, []MountInfo{
+			{Source: "s3fs", Target: "/var/lib/kubelet/pods/a/volumes/kubernetes.io~csi/fuse-1/mount", FSType: "fuse.s3fs"},
+			{Source: "rclone:bucket", Target: "/mnt/with space", FSType: "fuse"},
+		})
+
+		_, err = parseFuseMounts(strings.NewReader("broken line\n"))
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
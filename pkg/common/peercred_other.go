@@ -0,0 +1,13 @@
+//go:build !linux
+
+package common
+
+import (
+	"fmt"
+	"net"
+)
+
+// getPeerCred is only supported on linux, so every peer is rejected elsewhere.
+func getPeerCred(c net.Conn) (*PeerCred, error) {
+	return nil, fmt.Errorf("peer credentials are not supported on this platform")
+}
@@ -6,10 +6,18 @@ const (
 	ParamDriverName   = "driverName"
 	DefaultEndpoint   = "unix://tmp/csi.sock"
 	DefaultDriverName = "object.csi.gordon.com"
+	DefaultKubeletDir = "/var/lib/kubelet"
+	// DefaultNamespace is the namespace of the driver when POD_NAMESPACE is not set
+	DefaultNamespace = "kube-system"
 
 	HostDir             = "/host"
 	ConfigDir           = "/etc/open-object"
 	ConnectorSocketName = "connector.sock"
+	// CredentialDir keeps per-mount credential files, /run is a tmpfs so they never reach the host disk, which
+	// CheckCredentialDir verifies before any is written
+	CredentialDir = "/run/open-object/credentials"
+	// MetricsDir keeps the sockets builtin fuse processes serve their metrics on
+	MetricsDir = "/run/open-object/metrics"
 
 	NsenterCmd = "/bin/nsenter --mount=/proc/1/ns/mnt -ipc=/proc/1/ns/ipc --net=/proc/1/ns/net --uts=/proc/1/ns/uts"
 
@@ -26,4 +34,10 @@ var (
 	ConnectorLogFilename = filepath.Join(ConfigDir, "connector.log")
 	// ConnectorPIDFilename name of pid file
 	ConnectorPIDFilename = filepath.Join(ConfigDir, "connector.pid")
+	// ConnectorAuditLogFilename name of the file recording connector requests
+	ConnectorAuditLogFilename = filepath.Join(ConfigDir, "connector-audit.log")
+	// ConnectorJournalFilename name of the file recording the mounts of the connector
+	ConnectorJournalFilename = filepath.Join(ConfigDir, "connector-journal.json")
+	// BinaryPath is where run-connector.sh copies the open-object binary on the host
+	BinaryPath = filepath.Join(ConfigDir, "open-object")
 )
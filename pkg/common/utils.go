@@ -14,23 +14,21 @@ import (
 	"time"
 )
 
-func FuseMount(path string, command string, args []string) error {
-	klog.Infof("Mounting fuse with command: %s and args: %s", command, args)
+// FuseMount runs the fuse command of req on the host through the connector and waits for the mount.
+func FuseMount(req *MountRequest) error {
+	klog.Infof("Mounting fuse with command: %s and args: %s", req.Command, req.Args)
 
-	systemArgs := []string{"systemd-run", "--scope", "--", command}
-	systemArgs = append(systemArgs, args...)
-	cmdStr := strings.Join(systemArgs, " ")
-
-	if out, err := ConnectorRunInContainer(cmdStr); err != nil {
-		return errors.New("fuse mount failed: " + err.Error() + ", out: " + out)
+	if _, err := CallConnector(&ConnectorRequest{Op: ConnectorOpMount, Mount: req}); err != nil {
+		return errors.New("fuse mount failed: " + err.Error())
 	}
 
-	return waitForMount(path, 10*time.Second)
+	return waitForMount(req.Target, 10*time.Second)
 }
 
+// FuseUmount unmounts path through the connector, so it stops restarting the fuse process, and waits for the process to end.
 func FuseUmount(path string) error {
-	if err := mount.New("").Unmount(path); err != nil {
-		return err
+	if _, err := CallConnector(&ConnectorRequest{Op: ConnectorOpUnmount, Unmount: &UnmountRequest{Target: path}}); err != nil {
+		return errors.New("fuse umount failed: " + err.Error())
 	}
 	// as fuse quits immediately, we will try to wait until the process is done
 	process, err := findFuseMountProcess(path)
@@ -47,6 +45,14 @@ func FuseUmount(path string) error {
 	return waitForProcess(process, 1)
 }
 
+// FuseDetach lazily unmounts a disconnected fuse mount through the connector, so path can be mounted again.
+func FuseDetach(path string) error {
+	if _, err := CallConnector(&ConnectorRequest{Op: ConnectorOpUnmount, Unmount: &UnmountRequest{Target: path, Lazy: true}}); err != nil {
+		return errors.New("fuse detach failed: " + err.Error())
+	}
+	return nil
+}
+
 func RunCommand(command string) (string, error) {
 	out, err := exec.Command("sh", "-c", command).CombinedOutput()
 	if err != nil {
@@ -55,6 +61,14 @@ func RunCommand(command string) (string, error) {
 	return string(out), nil
 }
 
+// DriverNamespace is the namespace the driver runs in, from the downward api.
+func DriverNamespace() string {
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	return DefaultNamespace
+}
+
 func IsDirExisting(filename string) bool {
 	_, err := os.Stat(filename)
 	if err == nil {
@@ -0,0 +1,30 @@
+package common
+
+import "sync"
+
+// VolumeLocks serializes the operations on a volume, or on a target path, within the plugin.
+type VolumeLocks struct {
+	mux   sync.Mutex
+	locks map[string]struct{}
+}
+
+func NewVolumeLocks() *VolumeLocks {
+	return &VolumeLocks{locks: map[string]struct{}{}}
+}
+
+// TryAcquire returns false when an operation on id is already in progress.
+func (vl *VolumeLocks) TryAcquire(id string) bool {
+	vl.mux.Lock()
+	defer vl.mux.Unlock()
+	if _, ok := vl.locks[id]; ok {
+		return false
+	}
+	vl.locks[id] = struct{}{}
+	return true
+}
+
+func (vl *VolumeLocks) Release(id string) {
+	vl.mux.Lock()
+	defer vl.mux.Unlock()
+	delete(vl.locks, id)
+}
@@ -0,0 +1,163 @@
+package csi
+
+import (
+	"fmt"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BackendFactory creates the driver of a storage backend from the secrets of a CSI request.
+type BackendFactory func(secrets map[string]string) (Driver, error)
+
+// Backend describes a storage backend selected by the driverName parameter.
+type Backend struct {
+	Name    string
+	Factory BackendFactory
+	// ControllerCapabilities lists the controller RPCs the backend implements, e.g. expansion or snapshots
+	ControllerCapabilities []csi.ControllerServiceCapability_RPC_Type
+	// NodeCapabilities lists the node RPCs the backend implements
+	NodeCapabilities []csi.NodeServiceCapability_RPC_Type
+	// AccessModes lists the volume access modes the backend can serve
+	AccessModes []csi.VolumeCapability_AccessMode_Mode
+}
+
+var (
+	backendsLock sync.RWMutex
+	backends     = map[string]*Backend{}
+)
+
+// RegisterBackend makes a backend available to the driverName parameter.
+// It panics if the backend is invalid or registered twice, as this is a programming error.
+func RegisterBackend(b *Backend) {
+	if b == nil || b.Name == "" || b.Factory == nil {
+		panic("csi: backend must have a name and a factory")
+	}
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+	if _, exist := backends[b.Name]; exist {
+		panic(fmt.Sprintf("csi: backend %s registered twice", b.Name))
+	}
+	backends[b.Name] = b
+}
+
+// registeredBackends returns all registered backends sorted by name.
+func registeredBackends() []*Backend {
+	backendsLock.RLock()
+	defer backendsLock.RUnlock()
+	list := make([]*Backend, 0, len(backends))
+	for _, b := range backends {
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// getBackend returns the backend named in attr, which is either storageclass parameters or pv attributes.
+func getBackend(attr map[string]string) (*Backend, error) {
+	driverName := getDriverName(attr)
+	if driverName == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "%s not found in volume parameters", common.ParamDriverName)
+	}
+	backendsLock.RLock()
+	defer backendsLock.RUnlock()
+	b, exist := backends[driverName]
+	if !exist {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown storage driver: %s", driverName)
+	}
+	return b, nil
+}
+
+// getSnapshotBackend returns the backend of a snapshot, backends start their snapshot ids with their driverName.
+func getSnapshotBackend(snapshotID string) (*Backend, error) {
+	driverName, _, found := strings.Cut(snapshotID, "/")
+	if !found {
+		return nil, status.Errorf(codes.InvalidArgument, "malformed snapshot id %q, expect it to start with the storage driver", snapshotID)
+	}
+	return getBackend(map[string]string{common.ParamDriverName: driverName})
+}
+
+// newControllerDriver creates the backend driver, if the backend implements the controller RPC c.
+func (b *Backend) newControllerDriver(secrets map[string]string, c csi.ControllerServiceCapability_RPC_Type) (Driver, error) {
+	if !b.HasControllerCapability(c) {
+		return nil, status.Errorf(codes.Unimplemented, "storage driver %s does not support %s", b.Name, c)
+	}
+	return b.Factory(secrets)
+}
+
+// HasControllerCapability reports whether the backend implements the controller RPC c.
+func (b *Backend) HasControllerCapability(c csi.ControllerServiceCapability_RPC_Type) bool {
+	for _, cap := range b.ControllerCapabilities {
+		if cap == c {
+			return true
+		}
+	}
+	return false
+}
+
+// HasNodeCapability reports whether the backend implements the node RPC c.
+func (b *Backend) HasNodeCapability(c csi.NodeServiceCapability_RPC_Type) bool {
+	for _, cap := range b.NodeCapabilities {
+		if cap == c {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAccessMode reports whether the backend can serve volumes with access mode m.
+func (b *Backend) HasAccessMode(m csi.VolumeCapability_AccessMode_Mode) bool {
+	for _, mode := range b.AccessModes {
+		if mode == m {
+			return true
+		}
+	}
+	return false
+}
+
+// validateVolumeCapabilities checks that the backend serves the access modes of all caps.
+func (b *Backend) validateVolumeCapabilities(caps ...*csi.VolumeCapability) error {
+	for _, c := range caps {
+		if mode := c.GetAccessMode().GetMode(); !b.HasAccessMode(mode) {
+			return status.Errorf(codes.InvalidArgument, "storage driver %s does not support access mode %s", b.Name, mode)
+		}
+	}
+	return nil
+}
+
+// backendCapabilities merges the capabilities of all registered backends, as the plugin advertises them as a whole.
+func backendCapabilities() ([]csi.ControllerServiceCapability_RPC_Type, []csi.NodeServiceCapability_RPC_Type, []csi.VolumeCapability_AccessMode_Mode) {
+	var (
+		cscs   []csi.ControllerServiceCapability_RPC_Type
+		nscs   []csi.NodeServiceCapability_RPC_Type
+		modes  []csi.VolumeCapability_AccessMode_Mode
+		seenCS = map[csi.ControllerServiceCapability_RPC_Type]bool{}
+		seenNS = map[csi.NodeServiceCapability_RPC_Type]bool{}
+		seenAM = map[csi.VolumeCapability_AccessMode_Mode]bool{}
+	)
+	for _, b := range registeredBackends() {
+		for _, c := range b.ControllerCapabilities {
+			if !seenCS[c] {
+				seenCS[c] = true
+				cscs = append(cscs, c)
+			}
+		}
+		for _, c := range b.NodeCapabilities {
+			if !seenNS[c] {
+				seenNS[c] = true
+				nscs = append(nscs, c)
+			}
+		}
+		for _, m := range b.AccessModes {
+			if !seenAM[m] {
+				seenAM[m] = true
+				modes = append(modes, m)
+			}
+		}
+	}
+	return cscs, nscs, modes
+}
@@ -0,0 +1,64 @@
+package csi
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	"github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestBackendRegistry(t *testing.T) {
+	convey.Convey("test backend registry", t, func() {
+		fake := &Backend{
+			Name:    "fake",
+			Factory: func(secrets map[string]string) (Driver, error) { return nil, nil },
+			ControllerCapabilities: []csi.ControllerServiceCapability_RPC_Type{
+				csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+				csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+			},
+			AccessModes: []csi.VolumeCapability_AccessMode_Mode{
+				csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		}
+		RegisterBackend(fake)
+		defer func() {
+			backendsLock.Lock()
+			delete(backends, fake.Name)
+			backendsLock.Unlock()
+		}()
+
+		convey.Convey("duplicate registration panics", func() {
+			convey.So(func() { RegisterBackend(fake) }, convey.ShouldPanic)
+		})
+
+		convey.Convey("dispatch by driverName", func() {
+			b, err := getBackend(map[string]string{common.ParamDriverName: "fake"})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(b, convey.ShouldEqual, fake)
+
+			_, err = getBackend(map[string]string{common.ParamDriverName: "unknown"})
+			convey.So(status.Code(err), convey.ShouldEqual, codes.InvalidArgument)
+			_, err = getBackend(map[string]string{})
+			convey.So(status.Code(err), convey.ShouldEqual, codes.InvalidArgument)
+		})
+
+		convey.Convey("unsupported capabilities are rejected", func() {
+			_, err := fake.newControllerDriver(nil, csi.ControllerServiceCapability_RPC_EXPAND_VOLUME)
+			convey.So(status.Code(err), convey.ShouldEqual, codes.Unimplemented)
+
+			err = fake.validateVolumeCapabilities(&csi.VolumeCapability{
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+			})
+			convey.So(status.Code(err), convey.ShouldEqual, codes.InvalidArgument)
+		})
+
+		convey.Convey("capabilities are merged across backends", func() {
+			controllerCaps, _, accessModes := backendCapabilities()
+			convey.So(controllerCaps, convey.ShouldContain, csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT)
+			convey.So(controllerCaps, convey.ShouldContain, csi.ControllerServiceCapability_RPC_EXPAND_VOLUME)
+			convey.So(accessModes, convey.ShouldContain, csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)
+		})
+	})
+}
@@ -6,9 +6,9 @@ import (
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/guodoliu/csi-driver-s3/pkg/common"
 	csi_common "github.com/guodoliu/csi-driver-s3/pkg/csi/csi-common"
-	"github.com/guodoliu/csi-driver-s3/pkg/csi/s3minio"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
@@ -48,20 +48,16 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 	}
 
 	// get driver
-	var err error
-	driverName := getDriverName(req.GetParameters())
-	if len(driverName) == 0 {
-		return &csi.CreateVolumeResponse{}, status.Errorf(codes.InvalidArgument, "%s not found in storageclass parameters", common.ParamDriverName)
-	}
-	var driver Driver
-	switch driverName {
-	case s3minio.DriverName:
-		driver, err = getMinIODriver(req.Secrets)
-		if err != nil {
-			return &csi.CreateVolumeResponse{}, status.Errorf(codes.InvalidArgument, "fail to get minio driver: %s", err.Error())
-		}
-	default:
-		return &csi.CreateVolumeResponse{}, status.Errorf(codes.Internal, "unknown storage driver: %s", driverName)
+	backend, err := getBackend(req.GetParameters())
+	if err != nil {
+		return &csi.CreateVolumeResponse{}, err
+	}
+	if err := backend.validateVolumeCapabilities(req.GetVolumeCapabilities()...); err != nil {
+		return &csi.CreateVolumeResponse{}, err
+	}
+	driver, err := backend.newControllerDriver(req.GetSecrets(), csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME)
+	if err != nil {
+		return &csi.CreateVolumeResponse{}, err
 	}
 
 	// create volume
@@ -80,19 +76,13 @@ func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 	}
 
 	// get driver
-	driverName := getDriverName(pv.Spec.CSI.VolumeAttributes)
-	if driverName == "" {
-		return &csi.DeleteVolumeResponse{}, status.Errorf(codes.InvalidArgument, "%s not found in pv %s attributes", common.ParamDriverName, pv.Name)
-	}
-	var driver Driver
-	switch driverName {
-	case s3minio.DriverName:
-		driver, err = getMinIODriver(req.Secrets)
-		if err != nil {
-			return &csi.DeleteVolumeResponse{}, status.Errorf(codes.Internal, "fail to get minio driver: %s", err.Error())
-		}
-	default:
-		return &csi.DeleteVolumeResponse{}, status.Errorf(codes.Internal, "unknown driver: %s", driverName)
+	backend, err := getBackend(pv.Spec.CSI.VolumeAttributes)
+	if err != nil {
+		return &csi.DeleteVolumeResponse{}, err
+	}
+	driver, err := backend.newControllerDriver(req.GetSecrets(), csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME)
+	if err != nil {
+		return &csi.DeleteVolumeResponse{}, err
 	}
 
 	return driver.DeleteVolume(ctx, req)
@@ -110,19 +100,13 @@ func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi
 	}
 
 	// get driver
-	driverName := getDriverName(pv.Spec.CSI.VolumeAttributes)
-	if driverName == "" {
-		return &csi.ControllerExpandVolumeResponse{}, status.Errorf(codes.InvalidArgument, "%s not found in pv %s attributes", common.ParamDriverName, pv.Name)
-	}
-	var driver Driver
-	switch driverName {
-	case s3minio.DriverName:
-		driver, err = getMinIODriver(req.Secrets)
-		if err != nil {
-			return &csi.ControllerExpandVolumeResponse{}, status.Errorf(codes.Internal, "fail to get minio driver: %s", err.Error())
-		}
-	default:
-		return &csi.ControllerExpandVolumeResponse{}, status.Errorf(codes.Internal, "unknown driver: %s", driverName)
+	backend, err := getBackend(pv.Spec.CSI.VolumeAttributes)
+	if err != nil {
+		return &csi.ControllerExpandVolumeResponse{}, err
+	}
+	driver, err := backend.newControllerDriver(req.GetSecrets(), csi.ControllerServiceCapability_RPC_EXPAND_VOLUME)
+	if err != nil {
+		return &csi.ControllerExpandVolumeResponse{}, err
 	}
 
 	// expand volume
@@ -135,7 +119,32 @@ func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *cs
 }
 
 func (cs *controllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
-	return cs.DefaultControllerServer.ListSnapshots(ctx, req)
+	// get driver, snapshots are kept by the backends, so they are only listed by snapshot or source volume
+	var backend *Backend
+	var err error
+	if snapshotID := req.GetSnapshotId(); snapshotID != "" {
+		if backend, err = getSnapshotBackend(snapshotID); err != nil {
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+	} else if volumeID := req.GetSourceVolumeId(); volumeID != "" {
+		pv, err := cs.kubeClient.CoreV1().PersistentVolumes().Get(ctx, volumeID, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return &csi.ListSnapshotsResponse{}, nil
+		} else if err != nil {
+			return &csi.ListSnapshotsResponse{}, status.Error(codes.Internal, err.Error())
+		}
+		if backend, err = getBackend(pv.Spec.CSI.VolumeAttributes); err != nil {
+			return &csi.ListSnapshotsResponse{}, err
+		}
+	} else {
+		return &csi.ListSnapshotsResponse{}, nil
+	}
+	driver, err := backend.newControllerDriver(req.GetSecrets(), csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS)
+	if err != nil {
+		return &csi.ListSnapshotsResponse{}, err
+	}
+
+	return driver.ListSnapshots(ctx, req)
 }
 
 func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
@@ -143,7 +152,22 @@ func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req
 }
 
 func (cs *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
-	return cs.DefaultControllerServer.DeleteSnapshot(ctx, req)
+	snapshotID := req.GetSnapshotId()
+	if len(snapshotID) == 0 {
+		return &csi.DeleteSnapshotResponse{}, status.Error(codes.InvalidArgument, "Snapshot ID missing in request")
+	}
+
+	// get driver
+	backend, err := getSnapshotBackend(snapshotID)
+	if err != nil {
+		return &csi.DeleteSnapshotResponse{}, err
+	}
+	driver, err := backend.newControllerDriver(req.GetSecrets(), csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT)
+	if err != nil {
+		return &csi.DeleteSnapshotResponse{}, err
+	}
+
+	return driver.DeleteSnapshot(ctx, req)
 }
 
 func (cs *controllerServer) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
@@ -151,7 +175,33 @@ func (cs *controllerServer) ControllerGetCapabilities(ctx context.Context, req *
 }
 
 func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
-	return cs.DefaultControllerServer.CreateSnapshot(ctx, req)
+	if len(req.GetName()) == 0 {
+		return &csi.CreateSnapshotResponse{}, status.Error(codes.InvalidArgument, "Name missing in request")
+	}
+	volumeID := req.GetSourceVolumeId()
+	if len(volumeID) == 0 {
+		return &csi.CreateSnapshotResponse{}, status.Error(codes.InvalidArgument, "Source Volume ID missing in request")
+	}
+
+	pv, err := cs.kubeClient.CoreV1().PersistentVolumes().Get(ctx, volumeID, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return &csi.CreateSnapshotResponse{}, status.Errorf(codes.NotFound, "volume %s not found", volumeID)
+	} else if err != nil {
+		return &csi.CreateSnapshotResponse{}, status.Error(codes.Internal, err.Error())
+	}
+
+	// get driver
+	backend, err := getBackend(pv.Spec.CSI.VolumeAttributes)
+	if err != nil {
+		return &csi.CreateSnapshotResponse{}, err
+	}
+	driver, err := backend.newControllerDriver(req.GetSecrets(), csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT)
+	if err != nil {
+		return &csi.CreateSnapshotResponse{}, err
+	}
+
+	// create snapshot
+	return driver.CreateSnapshot(ctx, req)
 }
 
 func (cs *controllerServer) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
@@ -170,28 +220,6 @@ func getDriverName(attr map[string]string) string {
 	return attr[common.ParamDriverName]
 }
 
-func getMinIODriver(secrets map[string]string) (Driver, error) {
-	endpoint, err := GetS3Endpoint(secrets[s3minio.SecretMinIOHost])
-	if err != nil {
-		return nil, err
-	}
-	klog.Infof("endpoint: %s", endpoint)
-
-	//cfg := &s3minio.S3Config{
-	//	AK:       secrets[s3minio.SecretAK],
-	//	SK:       secrets[s3minio.SecretSK],
-	//	Region:   secrets[s3minio.SecretRegion],
-	//	Endpoint: endpoint,
-	//}
-	cfg := &s3minio.S3Config{
-		AK:       "NvmbqoUxn50jqcHlHBEG",
-		SK:       "EAGogws8UcIA8WuBMOuGDRKIC7MXvWcswD3dHpzW",
-		Region:   "china",
-		Endpoint: "http://192.168.165.50:9000",
-	}
-	return s3minio.NewMinIODriver(cfg)
-}
-
 func GetS3Endpoint(s3host string) (string, error) {
 	// endpoint
 	endpoint := ""
@@ -202,6 +230,10 @@ func GetS3Endpoint(s3host string) (string, error) {
 	scheme := strings.ToLower(u.Scheme)
 	host := u.Hostname()
 	port := u.Port()
+	// tls certificates are issued for the hostname, so it must not be replaced by its ip
+	if scheme == "https" {
+		return s3host, nil
+	}
 	// check if is ip
 	addr := net.ParseIP(host)
 	if addr == nil {
@@ -219,9 +251,12 @@ func GetS3Endpoint(s3host string) (string, error) {
 	}
 
 	if addr.To4() == nil {
-		endpoint = fmt.Sprintf("%s://[%s]:%s", scheme, addr.String(), port)
+		endpoint = fmt.Sprintf("%s://[%s]", scheme, addr.String())
 	} else {
-		endpoint = fmt.Sprintf("%s://%s:%s", scheme, addr.String(), port)
+		endpoint = fmt.Sprintf("%s://%s", scheme, addr.String())
+	}
+	if port != "" {
+		endpoint = fmt.Sprintf("%s:%s", endpoint, port)
 	}
 
 	return endpoint, nil
@@ -13,6 +13,7 @@ type CSIDriver struct {
 	nodeID  string
 	version string
 	cap     []*csi.ControllerServiceCapability
+	nscap   []*csi.NodeServiceCapability
 	vc      []*csi.VolumeCapability_AccessMode
 }
 
@@ -69,6 +70,21 @@ func (d *CSIDriver) AddControllerServiceCapabilities(cl []csi.ControllerServiceC
 	return
 }
 
+func (d *CSIDriver) AddNodeServiceCapabilities(nl []csi.NodeServiceCapability_RPC_Type) {
+	var nsc []*csi.NodeServiceCapability
+
+	for _, n := range nl {
+		glog.Infof("Enabling node service capability: %v", n.String())
+		nsc = append(nsc, NewNodeServiceCapability(n))
+	}
+
+	d.nscap = nsc
+}
+
+func (d *CSIDriver) GetNodeServiceCapabilities() []*csi.NodeServiceCapability {
+	return d.nscap
+}
+
 func (d *CSIDriver) AddVolumeCapabilityAccessModes(vc []csi.VolumeCapability_AccessMode_Mode) []*csi.VolumeCapability_AccessMode {
 	var vca []*csi.VolumeCapability_AccessMode
 	for _, c := range vc {
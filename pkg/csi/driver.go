@@ -3,16 +3,22 @@ package csi
 import (
 	"context"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
 	csi_common "github.com/guodoliu/csi-driver-s3/pkg/csi/csi-common"
 	"github.com/guodoliu/csi-driver-s3/pkg/version"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
+	"time"
 )
 
 type Driver interface {
 	CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error)
 	DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error)
 	ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error)
+	CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error)
+	DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error)
+	ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error)
 	NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error)
 	NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error)
 	NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error)
@@ -22,40 +28,66 @@ type Driver interface {
 }
 
 type FuseDriver struct {
-	driver   *csi_common.CSIDriver
-	endpoint string
+	driver     *csi_common.CSIDriver
+	endpoint   string
+	kubeletDir string
+	// metricsAddr serves the metrics of the fuse processes of the node, empty disables it
+	metricsAddr string
+	// controller is set for the plugin of the controller sidecars, which serves no volume of its node
+	controller bool
 
 	ids *identityServer
 	ns  *nodeServer
 	cs  *controllerServer
+	// reconciler is nil when reconciliation is disabled
+	reconciler *reconciler
 }
 
-func NewFuseDriver(nodeID, endpoint, driverName string, kubeClient *kubernetes.Clientset) (*FuseDriver, error) {
+func NewFuseDriver(nodeID, endpoint, driverName, kubeletDir, metricsAddr string, controller bool, reconcileInterval time.Duration, kubeClient *kubernetes.Clientset) (*FuseDriver, error) {
 	driver := csi_common.NewCSIDriver(driverName, version.Version, nodeID)
 	if driver == nil {
 		klog.Fatalln("Failed to initialize CSI Driver.")
 	}
 
 	s3Driver := &FuseDriver{
-		endpoint: endpoint,
-		driver:   driver,
-		ids:      newIdentityServer(driver),
-		cs:       newControllerServer(driver),
-		ns:       newNodeServer(driver),
+		endpoint:    endpoint,
+		kubeletDir:  kubeletDir,
+		metricsAddr: metricsAddr,
+		controller:  controller,
+		driver:      driver,
+		ids:         newIdentityServer(driver),
+		cs:          newControllerServer(driver),
+		ns:          newNodeServer(driver, driverName, kubeClient),
+	}
+	// a single controller reconciles the volumes, the plugins of the nodes never compete for its lease
+	if controller && reconcileInterval > 0 {
+		s3Driver.reconciler = newReconciler(driverName, nodeID, reconcileInterval, kubeClient)
 	}
 	return s3Driver, nil
 }
 
 func (s3 *FuseDriver) Run() {
-	// Initialize default library driver
-	s3.driver.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{
-		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
-	})
-	s3.driver.AddVolumeCapabilityAccessModes([]csi.VolumeCapability_AccessMode_Mode{
-		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
-	})
+	// Initialize default library driver with the capabilities of all registered backends
+	controllerCaps, nodeCaps, accessModes := backendCapabilities()
+	s3.driver.AddControllerServiceCapabilities(controllerCaps)
+	s3.driver.AddNodeServiceCapabilities(nodeCaps)
+	s3.driver.AddVolumeCapabilityAccessModes(accessModes)
 	s := csi_common.NewNonBlockingGRPCServer()
 	s.Start(s3.endpoint, s3.ids, s3.cs, s3.ns)
+	if s3.controller {
+		if s3.reconciler != nil {
+			go wait.Forever(s3.reconciler.run, reconcilerRetryPeriod)
+		}
+		s.Wait()
+		return
+	}
+
+	if err := common.CheckCredentialDir(); err != nil {
+		klog.Errorf("volumes mounted with credential files will fail to publish: %s", err.Error())
+	}
+	go wait.Forever(func() { s3.ns.remountVolumes(s3.kubeletDir) }, remountInterval)
+	if s3.metricsAddr != "" {
+		go serveVolumeMetrics(s3.metricsAddr, s3.ns.driverName, s3.kubeletDir)
+	}
 	s.Wait()
 }
@@ -0,0 +1,109 @@
+package csi
+
+import (
+	"context"
+	"fmt"
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/klog/v2"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// volumeMetricsTimeout bounds the scrape of the metrics socket of a fuse process.
+const volumeMetricsTimeout = 5 * time.Second
+
+// volumeMetrics gathers the metrics the builtin fuse processes of the volumes published on this node serve on
+// their sockets, labeled with the volume and the pod.
+type volumeMetrics struct {
+	driverName string
+	kubeletDir string
+}
+
+func (m *volumeMetrics) Gather() ([]*dto.MetricFamily, error) {
+	volumes, err := findPublishedVolumes(m.kubeletDir, m.driverName)
+	if err != nil {
+		return nil, err
+	}
+	var gatherers prometheus.Gatherers
+	for _, vol := range volumes {
+		socket := filepath.Join(common.HostDir, common.MountMetricsSocket(vol.VolumeHandle, vol.TargetPath))
+		if _, err := os.Stat(socket); err != nil {
+			// the volume is not mounted by the builtin mounter
+			continue
+		}
+		labels := map[string]string{"volume": vol.VolumeHandle, "pod_uid": podUID(m.kubeletDir, vol.TargetPath)}
+		gatherers = append(gatherers, prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			families, err := gatherSocket(socket, labels)
+			if err != nil {
+				klog.Warningf("fail to gather metrics of volume %s on %s: %s", labels["volume"], socket, err.Error())
+				return nil, nil
+			}
+			return families, nil
+		}))
+	}
+	return gatherers.Gather()
+}
+
+// gatherSocket scrapes the metrics served on socket, and adds labels to them.
+func gatherSocket(socket string, labels map[string]string) ([]*dto.MetricFamily, error) {
+	client := &http.Client{
+		Timeout: volumeMetricsTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	resp, err := client.Get("http://localhost/metrics")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	parser := expfmt.TextParser{}
+	parsed, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	families := make([]*dto.MetricFamily, 0, len(parsed))
+	for _, family := range parsed {
+		for _, metric := range family.Metric {
+			for name, value := range labels {
+				metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+			}
+			sort.Slice(metric.Label, func(i, j int) bool { return metric.Label[i].GetName() < metric.Label[j].GetName() })
+		}
+		families = append(families, family)
+	}
+	return families, nil
+}
+
+// podUID returns the uid of the pod a volume is published to, from its target path.
+func podUID(kubeletDir, targetPath string) string {
+	rel, err := filepath.Rel(filepath.Join(kubeletDir, "pods"), targetPath)
+	if err != nil {
+		return ""
+	}
+	uid, _, _ := strings.Cut(rel, string(filepath.Separator))
+	return uid
+}
+
+// serveVolumeMetrics serves the metrics of the fuse processes of the volumes of driverName on addr.
+func serveVolumeMetrics(addr, driverName, kubeletDir string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(&volumeMetrics{driverName: driverName, kubeletDir: kubeletDir}, promhttp.HandlerOpts{}))
+	klog.Errorf("volume metrics server stopped: %v", http.ListenAndServe(addr, mux))
+}
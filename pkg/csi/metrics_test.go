@@ -0,0 +1,47 @@
+package csi
+
+import (
+	"fmt"
+	"github.com/smartystreets/goconvey/convey"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVolumeMetrics(t *testing.T) {
+	convey.Convey("test gathering the metrics of a fuse process", t, func() {
+		// unix socket paths are short, test temporary directories may not be
+		dir, err := os.MkdirTemp("", "metrics")
+		convey.So(err, convey.ShouldBeNil)
+		defer os.RemoveAll(dir)
+		socket := filepath.Join(dir, "fuse.sock")
+		listener, err := net.Listen("unix", socket)
+		convey.So(err, convey.ShouldBeNil)
+		defer listener.Close()
+		go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "# HELP open_object_fuse_operations_total Filesystem operations, by operation.\n"+
+				"# TYPE open_object_fuse_operations_total counter\n"+
+				"open_object_fuse_operations_total{op=\"read\"} 3\n")
+		}))
+
+		families, err := gatherSocket(socket, map[string]string{"volume": "pv-1", "pod_uid": "uid-1"})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(families, convey.ShouldHaveLength, 1)
+		convey.So(families[0].GetName(), convey.ShouldEqual, "open_object_fuse_operations_total")
+		var labels []string
+		for _, label := range families[0].Metric[0].Label {
+			labels = append(labels, label.GetName()+"="+label.GetValue())
+		}
+		convey.So(labels, convey.ShouldResemble, []string{"op=read", "pod_uid=uid-1", "volume=pv-1"})
+		convey.So(families[0].Metric[0].GetCounter().GetValue(), convey.ShouldEqual, 3)
+
+		_, err = gatherSocket(filepath.Join(dir, "missing.sock"), nil)
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+
+	convey.Convey("test pod uid of a target path", t, func() {
+		convey.So(podUID("/var/lib/kubelet", "/var/lib/kubelet/pods/uid-1/volumes/kubernetes.io~csi/pv-1/mount"), convey.ShouldEqual, "uid-1")
+	})
+}
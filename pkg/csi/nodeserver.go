@@ -5,18 +5,27 @@ import (
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/guodoliu/csi-driver-s3/pkg/common"
 	csi_common "github.com/guodoliu/csi-driver-s3/pkg/csi/csi-common"
-	"github.com/guodoliu/csi-driver-s3/pkg/csi/s3minio"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 )
 
 type nodeServer struct {
+	driverName string
+	kubeClient kubernetes.Interface
+	// locks serializes publish and unpublish of a target path
+	locks *common.VolumeLocks
 	*csi_common.DefaultNodeServer
 }
 
-func newNodeServer(d *csi_common.CSIDriver) *nodeServer {
+func newNodeServer(d *csi_common.CSIDriver, driverName string, kubeClient kubernetes.Interface) *nodeServer {
 	return &nodeServer{
+		driverName:        driverName,
+		kubeClient:        kubeClient,
+		locks:             common.NewVolumeLocks(),
 		DefaultNodeServer: csi_common.NewDefaultNodeServer(d),
 	}
 }
@@ -35,22 +44,22 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	if len(targetPath) == 0 {
 		return &csi.NodePublishVolumeResponse{}, status.Error(codes.InvalidArgument, "Target path missing in request")
 	}
+	if !ns.locks.TryAcquire(targetPath) {
+		return &csi.NodePublishVolumeResponse{}, status.Errorf(codes.Aborted, common.VolumeOperationAlreadyExists, targetPath)
+	}
+	defer ns.locks.Release(targetPath)
 
 	// get driver
-	var err error
-	driverName := getDriverName(req.GetVolumeContext())
-	if driverName == "" {
-		return &csi.NodePublishVolumeResponse{}, status.Errorf(codes.InvalidArgument, "%s not found in storageclass parameters", common.ParamDriverName)
-	}
-	var driver Driver
-	switch driverName {
-	case s3minio.DriverName:
-		driver, err = getMinIODriver(req.Secrets)
-		if err != nil {
-			return &csi.NodePublishVolumeResponse{}, status.Errorf(codes.Internal, "fail to get minio driver: %s", err.Error())
-		}
-	default:
-		return &csi.NodePublishVolumeResponse{}, status.Errorf(codes.Internal, "unknown driver: %s", driverName)
+	backend, err := getBackend(req.GetVolumeContext())
+	if err != nil {
+		return &csi.NodePublishVolumeResponse{}, err
+	}
+	if err := backend.validateVolumeCapabilities(req.GetVolumeCapability()); err != nil {
+		return &csi.NodePublishVolumeResponse{}, err
+	}
+	driver, err := backend.Factory(req.GetSecrets())
+	if err != nil {
+		return &csi.NodePublishVolumeResponse{}, err
 	}
 
 	return driver.NodePublishVolume(ctx, req)
@@ -67,10 +76,17 @@ func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 	if len(targetPath) == 0 {
 		return &csi.NodeUnpublishVolumeResponse{}, status.Error(codes.InvalidArgument, "Target path missing in request")
 	}
+	if !ns.locks.TryAcquire(targetPath) {
+		return &csi.NodeUnpublishVolumeResponse{}, status.Errorf(codes.Aborted, common.VolumeOperationAlreadyExists, targetPath)
+	}
+	defer ns.locks.Release(targetPath)
 
 	if err := common.FuseUmount(targetPath); err != nil {
 		return &csi.NodeUnpublishVolumeResponse{}, status.Error(codes.Internal, err.Error())
 	}
+	if err := common.RemoveMountCredentials(volumeID, targetPath); err != nil {
+		return &csi.NodeUnpublishVolumeResponse{}, status.Error(codes.Internal, err.Error())
+	}
 	klog.Infof("s3: mountpoint %s has been unmounted.", targetPath)
 
 	return &csi.NodeUnpublishVolumeResponse{}, nil
@@ -89,7 +105,42 @@ func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 }
 
 func (ns *nodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
-	return nil, nil
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return &csi.NodeGetVolumeStatsResponse{}, status.Error(codes.InvalidArgument, "Volume ID missing in request")
+	}
+	if len(req.GetVolumePath()) == 0 {
+		return &csi.NodeGetVolumeStatsResponse{}, status.Error(codes.InvalidArgument, "Volume path missing in request")
+	}
+
+	// kubelet sends neither the volume context nor the secrets, both are taken from the PV
+	pv, err := ns.kubeClient.CoreV1().PersistentVolumes().Get(ctx, volumeID, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return &csi.NodeGetVolumeStatsResponse{}, status.Errorf(codes.NotFound, "volume %s not found", volumeID)
+		}
+		return &csi.NodeGetVolumeStatsResponse{}, status.Error(codes.Internal, err.Error())
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.VolumeHandle != volumeID {
+		return &csi.NodeGetVolumeStatsResponse{}, status.Errorf(codes.NotFound, "pv %s does not back volume %s", pv.Name, volumeID)
+	}
+	backend, err := getBackend(pv.Spec.CSI.VolumeAttributes)
+	if err != nil {
+		return &csi.NodeGetVolumeStatsResponse{}, err
+	}
+	if !backend.HasNodeCapability(csi.NodeServiceCapability_RPC_GET_VOLUME_STATS) {
+		return &csi.NodeGetVolumeStatsResponse{}, status.Errorf(codes.Unimplemented, "storage driver %s does not support %s", backend.Name, csi.NodeServiceCapability_RPC_GET_VOLUME_STATS)
+	}
+	secrets, err := ns.nodePublishSecrets(ctx, pv)
+	if err != nil {
+		return &csi.NodeGetVolumeStatsResponse{}, status.Error(codes.Internal, err.Error())
+	}
+	driver, err := backend.Factory(secrets)
+	if err != nil {
+		return &csi.NodeGetVolumeStatsResponse{}, err
+	}
+
+	return driver.NodeGetVolumeStats(ctx, req)
 }
 
 func (ns *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
@@ -97,26 +148,7 @@ func (ns *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoReque
 }
 
 func (ns *nodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	nscap := &csi.NodeServiceCapability{
-		Type: &csi.NodeServiceCapability_Rpc{
-			Rpc: &csi.NodeServiceCapability_RPC{
-				Type: csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
-			},
-		},
-	}
-
-	nscap3 := &csi.NodeServiceCapability{
-		Type: &csi.NodeServiceCapability_Rpc{
-			Rpc: &csi.NodeServiceCapability_RPC{
-				Type: csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
-			},
-		},
-	}
-
 	return &csi.NodeGetCapabilitiesResponse{
-		Capabilities: []*csi.NodeServiceCapability{
-			nscap,
-			nscap3,
-		},
+		Capabilities: ns.Driver.GetNodeServiceCapabilities(),
 	}, nil
 }
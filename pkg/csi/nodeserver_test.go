@@ -0,0 +1,88 @@
+package csi
+
+import (
+	"context"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	"github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+// statsDriver reports the access key it was created with as the used bytes of every volume.
+type statsDriver struct {
+	Driver
+	secrets map[string]string
+}
+
+func (d *statsDriver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage: []*csi.VolumeUsage{{Used: int64(len(d.secrets["accessKey"])), Unit: csi.VolumeUsage_BYTES}},
+	}, nil
+}
+
+func TestNodeGetVolumeStats(t *testing.T) {
+	convey.Convey("test volume stats dispatch", t, func() {
+		backend := &Backend{
+			Name: "stats",
+			Factory: func(secrets map[string]string) (Driver, error) {
+				return &statsDriver{secrets: secrets}, nil
+			},
+			NodeCapabilities: []csi.NodeServiceCapability_RPC_Type{csi.NodeServiceCapability_RPC_GET_VOLUME_STATS},
+		}
+		noStats := &Backend{
+			Name:    "nostats",
+			Factory: func(secrets map[string]string) (Driver, error) { return &statsDriver{}, nil },
+		}
+		RegisterBackend(backend)
+		RegisterBackend(noStats)
+		defer func() {
+			backendsLock.Lock()
+			delete(backends, backend.Name)
+			delete(backends, noStats.Name)
+			backendsLock.Unlock()
+		}()
+
+		newPV := func(name, backendName string) *corev1.PersistentVolume {
+			return &corev1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: name},
+				Spec: corev1.PersistentVolumeSpec{
+					PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+						Driver:               "object.csi.test",
+						VolumeHandle:         name,
+						VolumeAttributes:     map[string]string{common.ParamDriverName: backendName},
+						NodePublishSecretRef: &corev1.SecretReference{Namespace: "default", Name: "publish"},
+					}},
+				},
+			}
+		}
+		ns := &nodeServer{driverName: "object.csi.test", kubeClient: fake.NewSimpleClientset(
+			newPV("pv-1", backend.Name),
+			newPV("pv-2", noStats.Name),
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "publish"},
+				Data:       map[string][]byte{"accessKey": []byte("publisher")},
+			},
+		), locks: common.NewVolumeLocks()}
+		ctx := context.Background()
+		stats := func(volumeID string) (*csi.NodeGetVolumeStatsResponse, error) {
+			return ns.NodeGetVolumeStats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: volumeID, VolumePath: "/var/lib/kubelet/pods/p/volumes/kubernetes.io~csi/" + volumeID + "/mount"})
+		}
+
+		resp, err := stats("pv-1")
+		convey.So(err, convey.ShouldBeNil)
+		// the driver is created with the node publish secret of the pv
+		convey.So(resp.GetUsage()[0].GetUsed(), convey.ShouldEqual, len("publisher"))
+
+		_, err = stats("pv-2")
+		convey.So(status.Code(err), convey.ShouldEqual, codes.Unimplemented)
+		_, err = stats("pv-missing")
+		convey.So(status.Code(err), convey.ShouldEqual, codes.NotFound)
+		_, err = stats("")
+		convey.So(status.Code(err), convey.ShouldEqual, codes.InvalidArgument)
+	})
+}
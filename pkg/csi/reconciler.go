@@ -0,0 +1,142 @@
+package csi
+
+import (
+	"context"
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+	"strings"
+	"time"
+)
+
+// VolumeReconciler is implemented by the drivers keeping the buckets of their volumes in line with the PVCs,
+// e.g. with the annotations users change after the volumes are provisioned.
+type VolumeReconciler interface {
+	ReconcileVolume(ctx context.Context, pv *corev1.PersistentVolume, pvc *corev1.PersistentVolumeClaim) error
+}
+
+const (
+	// annotations the external-provisioner records the provisioner secret of a volume in
+	annProvisionerSecretName      = "volume.kubernetes.io/provisioner-deletion-secret-name"
+	annProvisionerSecretNamespace = "volume.kubernetes.io/provisioner-deletion-secret-namespace"
+
+	reconcilerLeaseDuration = 15 * time.Second
+	reconcilerRenewDeadline = 10 * time.Second
+	reconcilerRetryPeriod   = 2 * time.Second
+)
+
+// reconciler passes the bound volumes of the driver to the VolumeReconciler of their backends. Every plugin runs
+// one, only the holder of the lease of the driver reconciles.
+type reconciler struct {
+	driverName string
+	identity   string
+	interval   time.Duration
+	kubeClient kubernetes.Interface
+}
+
+func newReconciler(driverName, identity string, interval time.Duration, kubeClient kubernetes.Interface) *reconciler {
+	return &reconciler{driverName: driverName, identity: identity, interval: interval, kubeClient: kubeClient}
+}
+
+// run reconciles every interval while the plugin holds the lease, it returns when the lease is lost.
+func (r *reconciler) run() {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      strings.ReplaceAll(r.driverName, ".", "-") + "-reconciler",
+			Namespace: common.DriverNamespace(),
+		},
+		Client:     r.kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: r.identity},
+	}
+	leaderelection.RunOrDie(context.Background(), leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   reconcilerLeaseDuration,
+		RenewDeadline:   reconcilerRenewDeadline,
+		RetryPeriod:     reconcilerRetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				klog.Infof("%s starts reconciling volumes every %s", r.identity, r.interval)
+				wait.UntilWithContext(ctx, r.reconcile, r.interval)
+			},
+			OnStoppedLeading: func() {
+				klog.Infof("%s stops reconciling volumes", r.identity)
+			},
+		},
+	})
+}
+
+// reconcile passes every bound volume of the driver to its backend once.
+func (r *reconciler) reconcile(ctx context.Context) {
+	pvs, err := r.kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("fail to list persistent volumes: %s", err.Error())
+		return
+	}
+	// a driver per backend and secret, the volumes of a StorageClass share them
+	drivers := map[string]Driver{}
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != r.driverName || pv.Spec.ClaimRef == nil || pv.Status.Phase != corev1.VolumeBound {
+			continue
+		}
+		if err := r.reconcileVolume(ctx, pv, drivers); err != nil {
+			klog.Errorf("fail to reconcile volume %s: %s", pv.Name, err.Error())
+		}
+	}
+}
+
+func (r *reconciler) reconcileVolume(ctx context.Context, pv *corev1.PersistentVolume, drivers map[string]Driver) error {
+	backend, err := getBackend(pv.Spec.CSI.VolumeAttributes)
+	if err != nil {
+		return err
+	}
+	pvc, err := r.kubeClient.CoreV1().PersistentVolumeClaims(pv.Spec.ClaimRef.Namespace).Get(ctx, pv.Spec.ClaimRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	ref := controllerSecretRef(pv)
+	key := backend.Name
+	if ref != nil {
+		key += "/" + ref.Namespace + "/" + ref.Name
+	}
+	driver, ok := drivers[key]
+	if !ok {
+		secrets := map[string]string{}
+		if ref != nil {
+			secret, err := r.kubeClient.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			for k, v := range secret.Data {
+				secrets[k] = string(v)
+			}
+		}
+		if driver, err = backend.Factory(secrets); err != nil {
+			return err
+		}
+		drivers[key] = driver
+	}
+	vr, ok := driver.(VolumeReconciler)
+	if !ok {
+		return nil
+	}
+	return vr.ReconcileVolume(ctx, pv, pvc)
+}
+
+// controllerSecretRef returns the secret of the controller calls on pv: the provisioner secret the
+// external-provisioner recorded, else the expansion or the publish secret, nil when the volume has none.
+func controllerSecretRef(pv *corev1.PersistentVolume) *corev1.SecretReference {
+	if name := pv.Annotations[annProvisionerSecretName]; name != "" {
+		return &corev1.SecretReference{Name: name, Namespace: pv.Annotations[annProvisionerSecretNamespace]}
+	}
+	if ref := pv.Spec.CSI.ControllerExpandSecretRef; ref != nil {
+		return ref
+	}
+	return pv.Spec.CSI.NodePublishSecretRef
+}
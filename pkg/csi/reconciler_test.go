@@ -0,0 +1,85 @@
+package csi
+
+import (
+	"context"
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	"github.com/smartystreets/goconvey/convey"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+	"time"
+)
+
+// reconcilingDriver records the claims of the volumes it reconciles.
+type reconcilingDriver struct {
+	Driver
+	secrets map[string]string
+	claims  *[]string
+}
+
+func (d *reconcilingDriver) ReconcileVolume(ctx context.Context, pv *corev1.PersistentVolume, pvc *corev1.PersistentVolumeClaim) error {
+	*d.claims = append(*d.claims, pvc.Namespace+"/"+pvc.Name+"@"+d.secrets["accessKey"])
+	return nil
+}
+
+func TestReconciler(t *testing.T) {
+	convey.Convey("test volume reconciliation", t, func() {
+		var claims []string
+		backend := &Backend{
+			Name: "reconciling",
+			Factory: func(secrets map[string]string) (Driver, error) {
+				return &reconcilingDriver{secrets: secrets, claims: &claims}, nil
+			},
+		}
+		RegisterBackend(backend)
+		defer func() {
+			backendsLock.Lock()
+			delete(backends, backend.Name)
+			backendsLock.Unlock()
+		}()
+
+		newPV := func(name, driver string, bound bool) *corev1.PersistentVolume {
+			pv := &corev1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{
+					annProvisionerSecretName:      "creds",
+					annProvisionerSecretNamespace: "kube-system",
+				}},
+				Spec: corev1.PersistentVolumeSpec{
+					PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+						Driver:           driver,
+						VolumeHandle:     name,
+						VolumeAttributes: map[string]string{common.ParamDriverName: backend.Name},
+					}},
+					ClaimRef: &corev1.ObjectReference{Namespace: "default", Name: "claim-" + name},
+				},
+			}
+			if bound {
+				pv.Status.Phase = corev1.VolumeBound
+			}
+			return pv
+		}
+		kubeClient := fake.NewSimpleClientset(
+			newPV("pv-1", "object.csi.test", true),
+			newPV("pv-2", "object.csi.test", false),
+			newPV("pv-3", "other.csi.test", true),
+			&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "claim-pv-1"}},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "creds"},
+				Data:       map[string][]byte{"accessKey": []byte("ak")},
+			},
+		)
+
+		r := newReconciler("object.csi.test", "node-1", time.Minute, kubeClient)
+		r.reconcile(context.Background())
+		convey.So(claims, convey.ShouldResemble, []string{"default/claim-pv-1@ak"})
+
+		convey.Convey("the provisioner secret is preferred", func() {
+			pv := newPV("pv-4", "object.csi.test", true)
+			pv.Spec.CSI.NodePublishSecretRef = &corev1.SecretReference{Namespace: "default", Name: "publish"}
+			convey.So(controllerSecretRef(pv), convey.ShouldResemble, &corev1.SecretReference{Namespace: "kube-system", Name: "creds"})
+			pv.Annotations = nil
+			convey.So(controllerSecretRef(pv), convey.ShouldResemble, pv.Spec.CSI.NodePublishSecretRef)
+		})
+	})
+}
@@ -0,0 +1,144 @@
+package csi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/mount"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// remountInterval is the period of the pass restoring disconnected mounts, the first pass runs at startup.
+const remountInterval = time.Minute
+
+// volumeData is the vol_data.json kubelet writes next to the target path of every published csi volume.
+type volumeData struct {
+	DriverName   string `json:"driverName"`
+	VolumeHandle string `json:"volumeHandle"`
+	SpecVolID    string `json:"specVolID"`
+}
+
+type publishedVolume struct {
+	volumeData
+	TargetPath string
+}
+
+// findPublishedVolumes returns the volumes of driverName published to the pods of this node.
+func findPublishedVolumes(kubeletDir, driverName string) ([]publishedVolume, error) {
+	files, err := filepath.Glob(filepath.Join(kubeletDir, "pods", "*", "volumes", "kubernetes.io~csi", "*", "vol_data.json"))
+	if err != nil {
+		return nil, err
+	}
+	var volumes []publishedVolume
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			klog.Warningf("fail to read %s: %s", file, err.Error())
+			continue
+		}
+		vol := publishedVolume{TargetPath: filepath.Join(filepath.Dir(file), "mount")}
+		if err := json.Unmarshal(content, &vol.volumeData); err != nil {
+			klog.Warningf("fail to parse %s: %s", file, err.Error())
+			continue
+		}
+		if vol.DriverName == driverName {
+			volumes = append(volumes, vol)
+		}
+	}
+	return volumes, nil
+}
+
+// remountVolumes publishes again the volumes whose fuse process died, as kubelet never calls NodePublishVolume
+// for the pods that are already running.
+func (ns *nodeServer) remountVolumes(kubeletDir string) {
+	volumes, err := findPublishedVolumes(kubeletDir, ns.driverName)
+	if err != nil {
+		klog.Errorf("fail to find published volumes in %s: %s", kubeletDir, err.Error())
+		return
+	}
+	for _, vol := range volumes {
+		if _, err := os.Stat(vol.TargetPath); err == nil || !mount.IsCorruptedMnt(err) {
+			continue
+		}
+		klog.Warningf("mountpoint %s of volume %s is disconnected, remounting it", vol.TargetPath, vol.VolumeHandle)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*common.DefaultConnectorTimeout)
+		req, err := ns.publishRequest(ctx, vol)
+		if err == nil {
+			_, err = ns.NodePublishVolume(ctx, req)
+		}
+		cancel()
+		if err != nil {
+			klog.Errorf("fail to remount volume %s to %s: %s", vol.VolumeHandle, vol.TargetPath, err.Error())
+			continue
+		}
+		klog.Infof("volume %s remounted to %s", vol.VolumeHandle, vol.TargetPath)
+	}
+}
+
+// publishRequest rebuilds the NodePublishVolume request of a published volume from its PV.
+func (ns *nodeServer) publishRequest(ctx context.Context, vol publishedVolume) (*csi.NodePublishVolumeRequest, error) {
+	pv, err := ns.kubeClient.CoreV1().PersistentVolumes().Get(ctx, vol.SpecVolID, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("fail to get pv %s: %s", vol.SpecVolID, err.Error())
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.VolumeHandle != vol.VolumeHandle {
+		return nil, fmt.Errorf("pv %s does not back volume %s", pv.Name, vol.VolumeHandle)
+	}
+
+	secrets, err := ns.nodePublishSecrets(ctx, pv)
+	if err != nil {
+		return nil, err
+	}
+
+	return &csi.NodePublishVolumeRequest{
+		VolumeId:   vol.VolumeHandle,
+		TargetPath: vol.TargetPath,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: accessMode(pv.Spec.AccessModes)},
+		},
+		Readonly:      pv.Spec.CSI.ReadOnly,
+		Secrets:       secrets,
+		VolumeContext: pv.Spec.CSI.VolumeAttributes,
+	}, nil
+}
+
+// nodePublishSecrets returns the secrets kubelet passes to NodePublishVolume of pv.
+func (ns *nodeServer) nodePublishSecrets(ctx context.Context, pv *corev1.PersistentVolume) (map[string]string, error) {
+	secrets := map[string]string{}
+	ref := pv.Spec.CSI.NodePublishSecretRef
+	if ref == nil {
+		return secrets, nil
+	}
+	secret, err := ns.kubeClient.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("fail to get node publish secret %s/%s: %s", ref.Namespace, ref.Name, err.Error())
+	}
+	for k, v := range secret.Data {
+		secrets[k] = string(v)
+	}
+	return secrets, nil
+}
+
+// accessMode maps the access modes of a PV to the csi access mode kubelet would have published it with.
+func accessMode(modes []corev1.PersistentVolumeAccessMode) csi.VolumeCapability_AccessMode_Mode {
+	if len(modes) == 0 {
+		return csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER
+	}
+	switch modes[0] {
+	case corev1.ReadOnlyMany:
+		return csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+	case corev1.ReadWriteMany:
+		return csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER
+	default:
+		return csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER
+	}
+}
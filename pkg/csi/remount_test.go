@@ -0,0 +1,29 @@
+package csi
+
+import (
+	"github.com/smartystreets/goconvey/convey"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindPublishedVolumes(t *testing.T) {
+	convey.Convey("test findPublishedVolumes", t, func() {
+		kubeletDir := t.TempDir()
+		publish := func(pod, name, content string) string {
+			dir := filepath.Join(kubeletDir, "pods", pod, "volumes", "kubernetes.io~csi", name)
+			convey.So(os.MkdirAll(filepath.Join(dir, "mount"), 0750), convey.ShouldBeNil)
+			convey.So(os.WriteFile(filepath.Join(dir, "vol_data.json"), []byte(content), 0600), convey.ShouldBeNil)
+			return filepath.Join(dir, "mount")
+		}
+		target := publish("pod-a", "pv-a", `{"driverName":"object.csi.gordon.com","volumeHandle":"pv-a","specVolID":"pv-a"}`)
+		publish("pod-b", "pv-b", `{"driverName":"other.csi.io","volumeHandle":"pv-b","specVolID":"pv-b"}`)
+		publish("pod-c", "pv-c", `{`)
+
+		volumes, err := findPublishedVolumes(kubeletDir, "object.csi.gordon.com")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(volumes, convey.ShouldHaveLength, 1)
+		convey.So(volumes[0].VolumeHandle, convey.ShouldEqual, "pv-a")
+		convey.So(volumes[0].TargetPath, convey.ShouldEqual, target)
+	})
+}
@@ -0,0 +1,75 @@
+package csi
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/guodoliu/csi-driver-s3/pkg/csi/s3minio"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+var (
+	s3ControllerCapabilities = []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+	}
+	s3NodeCapabilities = []csi.NodeServiceCapability_RPC_Type{
+		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+	}
+	s3AccessModes = []csi.VolumeCapability_AccessMode_Mode{
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+	}
+)
+
+func init() {
+	RegisterBackend(&Backend{
+		Name:                   s3minio.DriverName,
+		Factory:                getMinIODriver,
+		ControllerCapabilities: s3ControllerCapabilities,
+		NodeCapabilities:       s3NodeCapabilities,
+		AccessModes:            s3AccessModes,
+	})
+	// hard quotas of either backend are only enforced when the minio admin api answers
+	RegisterBackend(&Backend{
+		Name:                   s3minio.S3DriverName,
+		Factory:                getS3Driver,
+		ControllerCapabilities: s3ControllerCapabilities,
+		NodeCapabilities:       s3NodeCapabilities,
+		AccessModes:            s3AccessModes,
+	})
+}
+
+func getMinIODriver(secrets map[string]string) (Driver, error) {
+	return newS3MinIODriver(secrets, s3minio.NewMinIODriver)
+}
+
+func getS3Driver(secrets map[string]string) (Driver, error) {
+	return newS3MinIODriver(secrets, s3minio.NewS3Driver)
+}
+
+func newS3MinIODriver(secrets map[string]string, newDriver func(*s3minio.S3Config) (*s3minio.MinIODriver, error)) (Driver, error) {
+	cfg, err := s3minio.NewS3ConfigFromSecrets(secrets)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := GetS3Endpoint(cfg.Endpoint)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "fail to resolve %s %s: %s", s3minio.SecretMinIOHost, cfg.Endpoint, err.Error())
+	}
+	klog.Infof("endpoint: %s", endpoint)
+	cfg.Endpoint = endpoint
+
+	driver, err := newDriver(cfg)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "fail to get minio driver: %s", err.Error())
+	}
+	return driver, nil
+}
@@ -0,0 +1,39 @@
+package s3minio
+
+import (
+	"context"
+	"github.com/minio/madmin-go/v3"
+	"k8s.io/klog/v2"
+	"sync"
+	"time"
+)
+
+const adminProbeTimeout = 5 * time.Second
+
+// adminProbes caches whether the admin api is usable, keyed by endpoint and access key,
+// so drivers created for every request do not probe the service again.
+var adminProbes sync.Map
+
+// adminAvailable reports whether the MinIO admin API answers with the given credentials.
+// Generic S3 services, or MinIO with non-admin credentials, reject the call and are served without it.
+func adminAvailable(endpoint, accessKey string, adminClient *madmin.AdminClient) bool {
+	key := endpoint + "/" + accessKey
+	if available, ok := adminProbes.Load(key); ok {
+		return available.(bool)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), adminProbeTimeout)
+	defer cancel()
+	_, err := adminClient.ServerInfo(ctx)
+	if err == nil {
+		klog.Infof("minio admin api is available on %s", endpoint)
+		adminProbes.Store(key, true)
+		return true
+	}
+	// only remember answers of the service, transport errors are probed again next time
+	if _, answered := err.(madmin.ErrorResponse); answered {
+		adminProbes.Store(key, false)
+	}
+	klog.Infof("minio admin api is unavailable on %s, quota is disabled: %s", endpoint, err.Error())
+	return false
+}
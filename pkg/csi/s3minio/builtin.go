@@ -0,0 +1,47 @@
+package s3minio
+
+import (
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	"github.com/guodoliu/csi-driver-s3/pkg/objectfs"
+	"strconv"
+)
+
+func init() {
+	registerMounter(&builtinMounter{fuseMounter{fsType: "fuse." + objectfs.FSName}})
+}
+
+// builtinMounter mounts buckets with the fuse filesystem of open-object itself, so nodes need no fuse client.
+type builtinMounter struct {
+	fuseMounter
+}
+
+func (m *builtinMounter) Name() string {
+	return MounterBuiltin
+}
+
+func (m *builtinMounter) Credentials(opts *MountOptions) (string, []string) {
+	return awsCredentials(opts)
+}
+
+func (m *builtinMounter) Command(opts *MountOptions, target, credentialFile string) (string, []string, []string) {
+	args := []string{"fuse", "--endpoint", opts.Endpoint, "--bucket", opts.Bucket}
+	if opts.Prefix != "" {
+		args = append(args, "--prefix", opts.Prefix)
+	}
+	if opts.Region != "" {
+		args = append(args, "--region", opts.Region)
+	}
+	if credentialFile != "" {
+		args = append(args, "--credential-file", credentialFile)
+	}
+	if opts.Capacity > 0 {
+		args = append(args, "--capacity", strconv.FormatInt(opts.Capacity, 10))
+	}
+	if opts.ReadOnly {
+		args = append(args, "--read-only")
+	}
+	if opts.MetricsSocket != "" {
+		args = append(args, "--metrics-addr", "unix:"+opts.MetricsSocket)
+	}
+	return common.BinaryPath, append(args, target), nil
+}
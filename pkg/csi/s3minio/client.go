@@ -1,6 +1,7 @@
 package s3minio
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/minio/madmin-go/v3"
@@ -9,28 +10,23 @@ import (
 	"github.com/minio/minio-go/v7/pkg/tags"
 	"k8s.io/klog/v2"
 	"net/url"
+	"path"
 	"strconv"
+	"strings"
 )
 
 type MinIOClient struct {
-	region  string
+	region string
+	// madmin is nil when the endpoint has no usable MinIO admin API, e.g. a generic S3 service
 	madmin  *madmin.AdminClient
 	mclient *minio.Client
 }
 
 func NewMinIOClient(cfg *S3Config) (*MinIOClient, error) {
-	// endpoint
-	u, err := url.Parse(cfg.Endpoint)
+	endpoint, useSSL, err := parseEndpoint(cfg.Endpoint)
 	if err != nil {
 		return nil, err
 	}
-	useSSL := u.Scheme == "https"
-	var endpoint string
-	if useSSL {
-		endpoint = cfg.Endpoint[len("https://"):]
-	} else {
-		endpoint = cfg.Endpoint[len("http://"):]
-	}
 
 	// minio admin
 	minioAdmin, err := madmin.NewWithOptions(endpoint, &madmin.Options{
@@ -57,37 +53,116 @@ func NewMinIOClient(cfg *S3Config) (*MinIOClient, error) {
 	}, nil
 }
 
-func (driver *MinIOClient) CreateBucket(bucketName string, capacityBytes int64) error {
+// NewS3Client creates a client for an S3 compatible service using standard S3 calls only.
+// The MinIO admin API is probed once per endpoint, and features relying on it are skipped when it is unavailable.
+func NewS3Client(cfg *S3Config) (*MinIOClient, error) {
+	endpoint, useSSL, err := parseEndpoint(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	minioClient, err := minio.New(endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.AK, cfg.SK, ""),
+		Secure:       useSSL,
+		Region:       cfg.Region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client := &MinIOClient{
+		mclient: minioClient,
+		region:  cfg.Region,
+	}
+	minioAdmin, err := madmin.NewWithOptions(endpoint, &madmin.Options{
+		Creds:  credentials.NewStaticV4(cfg.AK, cfg.SK, ""),
+		Secure: useSSL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if adminAvailable(cfg.Endpoint, cfg.AK, minioAdmin) {
+		client.madmin = minioAdmin
+	}
+	return client, nil
+}
+
+// HasAdmin reports whether the MinIO admin API, and so bucket quota, is available.
+func (driver *MinIOClient) HasAdmin() bool {
+	return driver.madmin != nil
+}
+
+// CreateBucket creates the bucket of owner with settings and stamps it with the owner tags. An existing bucket is only
+// accepted when it already belongs to owner, e.g. on a retry, or when adopt is set and it belongs to no one.
+func (driver *MinIOClient) CreateBucket(bucketName string, capacityBytes int64, owner bucketOwner, adopt bool, settings bucketSettings) error {
 	ctx := context.Background()
 	exists, err := driver.mclient.BucketExists(ctx, bucketName)
 	if err != nil {
 		return fmt.Errorf("failed to check if bucket %s exists: %s", bucketName, err.Error())
 	}
-	if !exists {
-		if err = driver.mclient.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{Region: driver.region}); err != nil {
-			return fmt.Errorf("failed to create bucket %s: %s", bucketName, err.Error())
+	bucketMap := map[string]string{}
+	if exists {
+		if bucketMap, err = driver.bucketTags(bucketName); err != nil {
+			return err
+		}
+		if _, owned := ownerOf(bucketMap); owned || !adopt {
+			if err := checkOwner(bucketName, bucketMap, owner); err != nil {
+				return err
+			}
+		} else {
+			klog.Infof("adopting bucket %s for %s", bucketName, owner)
+		}
+		if settings.ObjectLock {
+			locked, err := driver.objectLockEnabled(bucketName)
+			if err != nil {
+				return err
+			}
+			if !locked {
+				return fmt.Errorf("bucket %s exists without object lock, which can only be enabled when a bucket is created", bucketName)
+			}
 		}
+	} else if err = driver.mclient.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{Region: driver.region, ObjectLocking: settings.ObjectLock}); err != nil {
+		return fmt.Errorf("failed to create bucket %s: %s", bucketName, err.Error())
+	}
+	// only roll back buckets created here, an adopted bucket keeps its data
+	rollback := func() error {
+		if exists {
+			return nil
+		}
+		return driver.DeleteBucket(bucketName)
 	}
 
-	// set bucket quota
-	if DefaultFeatureGate.Enabled(Quota) {
+	// set bucket quota, soft quotas are only reported
+	if settings.Quota.hard() && DefaultFeatureGate.Enabled(Quota) && driver.HasAdmin() {
 		if err = driver.SetBucketQuota(bucketName, capacityBytes, madmin.HardQuota); err != nil {
 			// 创建 bucket 时设置 quota 若失败，回滚
-			if err := driver.DeleteBucket(bucketName); err != nil {
+			if err := rollback(); err != nil {
 				return fmt.Errorf("fail to delete bucket %s: %s", bucketName, err.Error())
 			}
 			return err
 		}
 	}
 
-	// set bucket metadata
-	bucketMap := map[string]string{
-		MetaDataCapacity:      strconv.FormatInt(capacityBytes, 10),
-		MetaDataPrivisionType: string(ProvisionTypeBucketOrCreate),
+	if err = driver.applyBucketSettings(bucketName, settings); err != nil {
+		if err := rollback(); err != nil {
+			return fmt.Errorf("fail to delete bucket %s: %s", bucketName, err.Error())
+		}
+		return err
+	}
+
+	// set bucket metadata, keeping the tags of an adopted bucket
+	bucketMap[MetaDataCapacity] = strconv.FormatInt(capacityBytes, 10)
+	bucketMap[MetaDataPrivisionType] = string(ProvisionTypeBucketOrCreate)
+	for k, v := range owner.tags() {
+		bucketMap[k] = v
+	}
+	for k, v := range settings.Encryption.tags() {
+		bucketMap[k] = v
 	}
 	if err = driver.SetBucketMetadata(bucketName, bucketMap); err != nil {
 		// 创建 bucket 时打 tag 若失败，回滚
-		if err := driver.DeleteBucket(bucketName); err != nil {
+		if err := rollback(); err != nil {
 			return fmt.Errorf("fail to delete bucket %s: %s", bucketName, err.Error())
 		}
 		return fmt.Errorf("failed to set bucket metadata: %s", err.Error())
@@ -97,9 +172,11 @@ func (driver *MinIOClient) CreateBucket(bucketName string, capacityBytes int64)
 }
 
 func (driver *MinIOClient) SetBucketQuota(bucketName string, capacityBytes int64, qType madmin.QuotaType) error {
+	if !driver.HasAdmin() {
+		return fmt.Errorf("bucket quota is not supported without the minio admin api")
+	}
 	ctx := context.Background()
-	// set bucket quota restriction
-	klog.Infof("quota type is %s", ParamQuotaType)
+	klog.Infof("set %s quota of bucket %s to %d bytes", qType, bucketName, capacityBytes)
 	if err := driver.madmin.SetBucketQuota(ctx, bucketName, &madmin.BucketQuota{
 		Quota: uint64(capacityBytes),
 		Type:  qType,
@@ -126,47 +203,102 @@ func (driver *MinIOClient) DeleteBucket(bucketName string) error {
 }
 
 func (driver *MinIOClient) EmptyBucket(bucketName string) error {
+	return driver.removeObjects(bucketName, "")
+}
+
+// CreatePrefix creates the shared bucket of prefix volumes when it does not exist yet, and the directory marker
+// of prefix, so fuse clients find the directory they mount before anything is written to it.
+func (driver *MinIOClient) CreatePrefix(bucketName, prefix string) error {
+	if err := driver.ensureBucket(bucketName); err != nil {
+		return err
+	}
+	if _, err := driver.mclient.PutObject(context.Background(), bucketName, prefix+"/", bytes.NewReader(nil), 0, minio.PutObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to create prefix %s in bucket %s: %s", prefix, bucketName, err.Error())
+	}
+	return nil
+}
+
+// ensureBucket creates bucketName when it does not exist, for buckets shared by volumes like prefix and archive buckets.
+func (driver *MinIOClient) ensureBucket(bucketName string) error {
 	ctx := context.Background()
+	exists, err := driver.mclient.BucketExists(ctx, bucketName)
+	if err != nil {
+		return fmt.Errorf("failed to check if bucket %s exists: %s", bucketName, err.Error())
+	}
+	if !exists {
+		if err = driver.mclient.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{Region: driver.region}); err != nil {
+			return fmt.Errorf("failed to create bucket %s: %s", bucketName, err.Error())
+		}
+	}
+	return nil
+}
+
+// DeletePrefix removes the objects of a prefix volume, and leaves the shared bucket and its other prefixes alone.
+func (driver *MinIOClient) DeletePrefix(bucketName, prefix string) error {
+	exists, err := driver.mclient.BucketExists(context.Background(), bucketName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		klog.Infof("bucket %s does not exist, ignoring", bucketName)
+		return nil
+	}
+	return driver.removeObjects(bucketName, prefix)
+}
+
+// removeObjects removes the objects under prefix, every object of the bucket when prefix is empty.
+// Every version of the objects of a versioned bucket is removed, or the bucket could not be removed.
+func (driver *MinIOClient) removeObjects(bucketName, prefix string) error {
+	ctx := context.Background()
+	versioned, err := driver.versioned(bucketName)
+	if err != nil {
+		return err
+	}
 	objectCh := make(chan minio.ObjectInfo)
-	var listErr error
+	listErrCh := make(chan error, 1)
 
 	go func() {
 		defer close(objectCh)
-
-		doneCh := make(chan struct{})
-		defer close(doneCh)
+		defer close(listErrCh)
 
 		for object := range driver.mclient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
-			UseV1:     true,
-			Recursive: true,
+			Prefix:       objectPrefix(prefix),
+			UseV1:        true,
+			Recursive:    true,
+			WithVersions: versioned,
 		}) {
 			if object.Err != nil {
-				listErr = object.Err
+				listErrCh <- object.Err
 				return
 			}
 			objectCh <- object
 		}
-
 	}()
 
-	if listErr != nil {
-		klog.Errorf("fail to list objects: %s", listErr.Error())
-		return listErr
-	}
-
+	var removeErr error
 	errorCh := driver.mclient.RemoveObjects(ctx, bucketName, objectCh, minio.RemoveObjectsOptions{})
 	for err := range errorCh {
 		klog.Errorf("failed to remove object %s, error: %s", err.ObjectName, err.Err)
+		removeErr = err.Err
 	}
-	if len(errorCh) != 0 {
-		return fmt.Errorf("failed to remove all objects: %s", listErr.Error())
+	if listErr := <-listErrCh; listErr != nil {
+		klog.Errorf("fail to list objects: %s", listErr.Error())
+		return listErr
+	}
+	if removeErr != nil {
+		return fmt.Errorf("failed to remove all objects: %s", removeErr.Error())
 	}
 
 	return nil
 }
 
 func (driver *MinIOClient) ListBucketObjects(bucketName string) ([]minio.ObjectInfo, error) {
+	return driver.listObjects(bucketName, "")
+}
+
+func (driver *MinIOClient) listObjects(bucketName, prefix string) ([]minio.ObjectInfo, error) {
 	objectCh := driver.mclient.ListObjects(context.Background(), bucketName, minio.ListObjectsOptions{
+		Prefix:    objectPrefix(prefix),
 		UseV1:     true,
 		Recursive: true,
 	})
@@ -182,6 +314,60 @@ func (driver *MinIOClient) ListBucketObjects(bucketName string) ([]minio.ObjectI
 	return objects, nil
 }
 
+// copyObjects copies the objects under srcPrefix to dstPrefix in dstBucket with server side copies,
+// and returns the number and the size of the objects copied.
+func (driver *MinIOClient) copyObjects(srcBucket, srcPrefix, dstBucket, dstPrefix string) (int, int64, error) {
+	objects, err := driver.listObjects(srcBucket, srcPrefix)
+	if err != nil {
+		return 0, 0, err
+	}
+	var count int
+	var size int64
+	for _, object := range objects {
+		key, ok := relocateKey(object.Key, srcPrefix, dstPrefix)
+		if !ok {
+			continue
+		}
+		if err := driver.copyObject(minio.CopySrcOptions{Bucket: srcBucket, Object: object.Key}, object.Size, dstBucket, key); err != nil {
+			return 0, 0, err
+		}
+		count++
+		size += object.Size
+	}
+	return count, size, nil
+}
+
+// copyObject copies src of size bytes to dstKey in dstBucket. Objects above the 5GiB limit of a single copy
+// are composed in parts.
+func (driver *MinIOClient) copyObject(src minio.CopySrcOptions, size int64, dstBucket, dstKey string) error {
+	ctx := context.Background()
+	dst := minio.CopyDestOptions{Bucket: dstBucket, Object: dstKey}
+	var err error
+	if size <= maxSingleCopySize {
+		_, err = driver.mclient.CopyObject(ctx, dst, src)
+	} else {
+		_, err = driver.mclient.ComposeObject(ctx, dst, src)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy object %s/%s to %s/%s: %s", src.Bucket, src.Object, dstBucket, dstKey, err.Error())
+	}
+	return nil
+}
+
+// relocateKey moves key from under srcPrefix to under dstPrefix. The directory marker of srcPrefix
+// has no place at the root of a bucket, ok is false for it.
+func relocateKey(key, srcPrefix, dstPrefix string) (string, bool) {
+	rel := strings.TrimPrefix(key, objectPrefix(srcPrefix))
+	if strings.Trim(rel, "/") == "" && dstPrefix == "" {
+		return "", false
+	}
+	dst := path.Join(dstPrefix, rel)
+	if strings.HasSuffix(key, "/") {
+		dst += "/"
+	}
+	return dst, true
+}
+
 func (driver *MinIOClient) SetBucketMetadata(bucketName string, bucketMap map[string]string) error {
 	bucketTags, err := tags.NewTags(bucketMap, false)
 	if err != nil {
@@ -205,15 +391,11 @@ func (driver *MinIOClient) RemoveBucketMetadata(bucketName string) error {
 }
 
 func (driver *MinIOClient) GetBucketUsage(bucketName string) (int64, error) {
-	objects, err := driver.ListBucketObjects(bucketName)
+	usage, err := driver.bucketUsage(context.Background(), bucketName, "")
 	if err != nil {
 		return 0, err
 	}
-	var usage int64 = 0
-	for _, object := range objects {
-		usage += object.Size
-	}
-	return usage, nil
+	return usage.Bytes, nil
 }
 
 func (driver *MinIOClient) GetBucketCapacity(bucketName string) (int64, error) {
@@ -233,30 +415,54 @@ func (driver *MinIOClient) GetBucketCapacity(bucketName string) (int64, error) {
 }
 
 func (driver *MinIOClient) FsInfo(bucketName string) (int64, int64, int64, int64, int64, int64, error) {
-
-	var available, capacity, usage, inodes, inodesFree, inodesUsed int64
 	capacity, err := driver.GetBucketCapacity(bucketName)
 	if err != nil {
 		return 0, 0, 0, 0, 0, 0, err
 	}
-	usage, err = driver.GetBucketUsage(bucketName)
+	return driver.fsInfo(bucketName, "", capacity)
+}
+
+// PrefixFsInfo is FsInfo of a prefix volume, whose capacity is the capacity of its PV.
+func (driver *MinIOClient) PrefixFsInfo(bucketName, prefix string, capacity int64) (int64, int64, int64, int64, int64, int64, error) {
+	return driver.fsInfo(bucketName, prefix, capacity)
+}
+
+// fsInfo reports the usage of the objects under prefix of bucketName from the usage cache, as kubelet polls it.
+func (driver *MinIOClient) fsInfo(bucketName, prefix string, capacity int64) (int64, int64, int64, int64, int64, int64, error) {
+	var available, inodes, inodesFree int64
+	usage, err := volumeUsageCache.get(context.Background(), driver, bucketName, prefix)
 	if err != nil {
 		return 0, 0, 0, 0, 0, 0, err
 	}
-	available = capacity - usage
+	available = capacity - usage.Bytes
 	if available < 0 {
 		available = 0
 	}
 	inodes = maxObjectNum
-	objects, err := driver.ListBucketObjects(bucketName)
-	if err != nil {
-		return 0, 0, 0, 0, 0, 0, err
-	}
-	inodesUsed = int64(len(objects))
-	inodesFree = inodes - inodesUsed
+	inodesFree = inodes - usage.Objects
 	if inodesFree < 0 {
 		inodesFree = 0
 	}
 
-	return available, capacity, usage, inodes, inodesFree, inodesUsed, nil
+	return available, capacity, usage.Bytes, inodes, inodesFree, usage.Objects, nil
+}
+
+// objectPrefix returns the key prefix of the objects of a volume prefix, which is empty for whole buckets.
+func objectPrefix(prefix string) string {
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// parseEndpoint splits an endpoint url into the host:port expected by minio clients and whether it uses TLS.
+func parseEndpoint(rawURL string) (string, bool, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false, err
+	}
+	if u.Host == "" {
+		return "", false, fmt.Errorf("no host found in endpoint %s", rawURL)
+	}
+	return u.Host, strings.ToLower(u.Scheme) == "https", nil
 }
@@ -0,0 +1,180 @@
+package s3minio
+
+import (
+	"context"
+	"fmt"
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	"github.com/minio/minio-go/v7"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"path"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// EventReasonCloneProgress is the reason of the PVC events reporting the progress of a clone
+	EventReasonCloneProgress = "CloneProgress"
+
+	defaultCloneWorkers = 8
+	cloneReportInterval = 30 * time.Second
+)
+
+// cloneRef is the source of a volume cloned from another volume of the backend.
+type cloneRef struct {
+	VolumeID string
+	Bucket   string
+	Prefix   string
+}
+
+// lookupClone finds the volume a volume of capacity bytes is cloned from, NotFound when it does not exist.
+func (driver *MinIODriver) lookupClone(ctx context.Context, volumeID string, capacity int64) (*cloneRef, error) {
+	pv, err := driver.kubeClient.CoreV1().PersistentVolumes().Get(ctx, volumeID, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, status.Errorf(codes.NotFound, "volume %s not found", volumeID)
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.VolumeAttributes[common.ParamDriverName] != driver.name {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s is not a volume of storage driver %s", volumeID, driver.name)
+	}
+	if err := checkCopyable(volumeID, pv.Spec.CSI.VolumeAttributes); err != nil {
+		return nil, err
+	}
+	if source, ok := pv.Spec.Capacity[corev1.ResourceStorage]; ok && capacity > 0 && capacity < source.Value() {
+		return nil, status.Errorf(codes.OutOfRange, "volume %s of %d bytes does not fit in %d bytes", volumeID, source.Value(), capacity)
+	}
+	bucketName, prefix, err := volumeSource(volumeID, pv.Spec.CSI.VolumeAttributes)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &cloneRef{VolumeID: volumeID, Bucket: bucketName, Prefix: prefix}, nil
+}
+
+// cloneWorkers parses the clone workers of a StorageClass.
+func cloneWorkers(params map[string]string) (int, error) {
+	value := params[ParamCloneWorkers]
+	if value == "" {
+		return defaultCloneWorkers, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q, expect a positive number", ParamCloneWorkers, value)
+	}
+	return n, nil
+}
+
+// cloneProgress counts the objects and bytes of a clone. Skipped objects were copied by an earlier attempt.
+type cloneProgress struct {
+	Objects     int64
+	Bytes       int64
+	Copied      int64
+	Skipped     int64
+	BytesCopied int64
+}
+
+func (p cloneProgress) String() string {
+	return fmt.Sprintf("%d/%d objects done, %d skipped as already copied, %d/%d bytes copied",
+		p.Copied+p.Skipped, p.Objects, p.Skipped, p.BytesCopied, p.Bytes)
+}
+
+// cloneCounters are the counters of a clone in progress, shared by its workers.
+type cloneCounters struct {
+	copied, skipped, bytesCopied atomic.Int64
+}
+
+// CloneObjects copies the objects under srcPrefix of srcBucket to dstPrefix in dstBucket, with workers server side
+// copies at a time. Objects an earlier attempt already copied are skipped, so a retried clone resumes where it stopped.
+// report is called with the progress every cloneReportInterval, and once more when the clone ends.
+func (driver *MinIOClient) CloneObjects(srcBucket, srcPrefix, dstBucket, dstPrefix string, workers int, report func(cloneProgress)) (cloneProgress, error) {
+	sources, err := driver.listObjects(srcBucket, srcPrefix)
+	if err != nil {
+		return cloneProgress{}, err
+	}
+	existing, err := driver.listObjects(dstBucket, dstPrefix)
+	if err != nil {
+		return cloneProgress{}, err
+	}
+	copied := make(map[string]minio.ObjectInfo, len(existing))
+	for _, object := range existing {
+		copied[object.Key] = object
+	}
+
+	progress := cloneProgress{Objects: int64(len(sources))}
+	for _, object := range sources {
+		progress.Bytes += object.Size
+	}
+	var counters cloneCounters
+	current := func() cloneProgress {
+		p := progress
+		p.Copied, p.Skipped, p.BytesCopied = counters.copied.Load(), counters.skipped.Load(), counters.bytesCopied.Load()
+		return p
+	}
+
+	jobs := make(chan minio.ObjectInfo)
+	errCh := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for object := range jobs {
+				key, ok := relocateKey(object.Key, srcPrefix, dstPrefix)
+				// a copy is never older than its source, a newer source has changed since it was copied
+				if dst, exist := copied[key]; !ok || exist && dst.Size == object.Size && !dst.LastModified.Before(object.LastModified) {
+					counters.skipped.Add(1)
+					continue
+				}
+				if err := driver.copyObject(minio.CopySrcOptions{Bucket: srcBucket, Object: object.Key}, object.Size, dstBucket, key); err != nil {
+					errCh <- err
+					return
+				}
+				counters.copied.Add(1)
+				counters.bytesCopied.Add(object.Size)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(cloneReportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				report(current())
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// a failed worker stops taking objects, the clone stops when all of them failed or the first error is seen
+	var cloneErr error
+	for _, object := range sources {
+		select {
+		case jobs <- object:
+		case cloneErr = <-errCh:
+		}
+		if cloneErr != nil {
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(done)
+	if cloneErr == nil && len(errCh) > 0 {
+		cloneErr = <-errCh
+	}
+	result := current()
+	report(result)
+	if cloneErr != nil {
+		return result, fmt.Errorf("failed to clone %s to %s: %s", path.Join(srcBucket, srcPrefix), path.Join(dstBucket, dstPrefix), cloneErr.Error())
+	}
+	return result, nil
+}
@@ -0,0 +1,54 @@
+package s3minio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestCloneObjects(t *testing.T) {
+	convey.Convey("test resumable clones against a stand-in server", t, func() {
+		fake := newFakeS3()
+		defer fake.Close()
+		ctx := context.Background()
+
+		c, err := NewS3Client(fake.config())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c.mclient.MakeBucket(ctx, "source", minio.MakeBucketOptions{}), convey.ShouldBeNil)
+		convey.So(c.CreatePrefix("shared", "pvc-2"), convey.ShouldBeNil)
+		for i := 0; i < 20; i++ {
+			data := []byte("0123456789")
+			_, err := c.mclient.PutObject(ctx, "source", fmt.Sprintf("dir/%02d.txt", i), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+			convey.So(err, convey.ShouldBeNil)
+		}
+
+		var reports []cloneProgress
+		report := func(p cloneProgress) { reports = append(reports, p) }
+		progress, err := c.CloneObjects("source", "", "shared", "pvc-2", 4, report)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(progress, convey.ShouldResemble, cloneProgress{Objects: 20, Bytes: 200, Copied: 20, BytesCopied: 200})
+		convey.So(reports[len(reports)-1], convey.ShouldResemble, progress)
+		objects, err := c.listObjects("shared", "pvc-2")
+		convey.So(err, convey.ShouldBeNil)
+		// the objects and the directory marker of the prefix
+		convey.So(objects, convey.ShouldHaveLength, 21)
+
+		convey.Convey("a retry resumes the clone", func() {
+			convey.So(c.mclient.RemoveObject(ctx, "shared", "pvc-2/dir/07.txt", minio.RemoveObjectOptions{}), convey.ShouldBeNil)
+			progress, err := c.CloneObjects("source", "", "shared", "pvc-2", 4, report)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(progress.Copied, convey.ShouldEqual, 1)
+			convey.So(progress.Skipped, convey.ShouldEqual, 19)
+			_, err = c.mclient.StatObject(ctx, "shared", "pvc-2/dir/07.txt", minio.StatObjectOptions{})
+			convey.So(err, convey.ShouldBeNil)
+		})
+
+		convey.Convey("a failed copy fails the clone", func() {
+			_, err := c.CloneObjects("source", "", "missing", "", 4, report)
+			convey.So(err, convey.ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,58 @@
+package s3minio
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/url"
+	"strings"
+)
+
+// NewS3ConfigFromSecrets builds the backend config from the secrets passed in a CSI request.
+// Both the MinIO schema (rootUser/rootPassword) and the generic S3 schema (accesskey/secretkey)
+// are accepted, so a single driver install can serve several object storage clusters.
+func NewS3ConfigFromSecrets(secrets map[string]string) (*S3Config, error) {
+	if len(secrets) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "secrets missing in request")
+	}
+
+	host := strings.TrimSpace(secrets[SecretMinIOHost])
+	if host == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "%s not found in secrets", SecretMinIOHost)
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "fail to parse %s in secrets: %s", SecretMinIOHost, err.Error())
+	}
+	if scheme := strings.ToLower(u.Scheme); scheme != "http" && scheme != "https" {
+		return nil, status.Errorf(codes.InvalidArgument, "%s in secrets must start with http:// or https://, got %q", SecretMinIOHost, host)
+	}
+	if u.Hostname() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "%s in secrets has no hostname: %q", SecretMinIOHost, host)
+	}
+
+	ak, err := lookupSecret(secrets, SecretAK, SecretAccessKey)
+	if err != nil {
+		return nil, err
+	}
+	sk, err := lookupSecret(secrets, SecretSK, SecretSecretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Config{
+		AK:       ak,
+		SK:       sk,
+		Region:   strings.TrimSpace(secrets[SecretRegion]),
+		Endpoint: host,
+	}, nil
+}
+
+// lookupSecret returns the first non-empty value among keys, which are listed in order of preference.
+func lookupSecret(secrets map[string]string, keys ...string) (string, error) {
+	for _, key := range keys {
+		if value := strings.TrimSpace(secrets[key]); value != "" {
+			return value, nil
+		}
+	}
+	return "", status.Errorf(codes.InvalidArgument, "%s not found in secrets", strings.Join(keys, " or "))
+}
@@ -0,0 +1,54 @@
+package s3minio
+
+import (
+	"github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestNewS3ConfigFromSecrets(t *testing.T) {
+	convey.Convey("test NewS3ConfigFromSecrets", t, func() {
+		convey.Convey("minio schema", func() {
+			cfg, err := NewS3ConfigFromSecrets(map[string]string{
+				SecretMinIOHost: "http://minio.example.com:9000",
+				SecretAK:        "root",
+				SecretSK:        "password",
+				SecretRegion:    "china",
+			})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(*cfg, convey.ShouldResemble, S3Config{AK: "root", SK: "password", Region: "china", Endpoint: "http://minio.example.com:9000"})
+		})
+
+		convey.Convey("generic schema", func() {
+			cfg, err := NewS3ConfigFromSecrets(map[string]string{
+				SecretMinIOHost: "https://s3.example.com",
+				SecretAccessKey: "ak",
+				SecretSecretKey: "sk",
+			})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(cfg.AK, convey.ShouldEqual, "ak")
+			convey.So(cfg.SK, convey.ShouldEqual, "sk")
+			convey.So(cfg.Region, convey.ShouldBeEmpty)
+		})
+
+		convey.Convey("missing key", func() {
+			_, err := NewS3ConfigFromSecrets(map[string]string{
+				SecretMinIOHost: "http://minio.example.com:9000",
+				SecretAccessKey: "ak",
+			})
+			convey.So(status.Code(err), convey.ShouldEqual, codes.InvalidArgument)
+			convey.So(err.Error(), convey.ShouldContainSubstring, SecretSecretKey)
+		})
+
+		convey.Convey("malformed host", func() {
+			_, err := NewS3ConfigFromSecrets(map[string]string{
+				SecretMinIOHost: "minio.example.com:9000",
+				SecretAK:        "root",
+				SecretSK:        "password",
+			})
+			convey.So(status.Code(err), convey.ShouldEqual, codes.InvalidArgument)
+			convey.So(err.Error(), convey.ShouldContainSubstring, SecretMinIOHost)
+		})
+	})
+}
@@ -0,0 +1,208 @@
+package s3minio
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	"github.com/minio/madmin-go/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"path"
+	"strings"
+)
+
+const (
+	scopedAccessKeyPrefix = "csi-"
+	volumeSecretPrefix    = "open-object-volume-"
+	// minio accepts access keys of at most 20 characters and secret keys of at most 40 characters
+	scopedAccessKeyHashLen = 16
+	scopedSecretKeyLen     = 40
+
+	adminErrPolicyAlreadyApplied = "XMinioAdminPolicyChangeAlreadyApplied"
+	adminErrNoSuchUser           = "XMinioAdminNoSuchUser"
+	adminErrNoSuchPolicy         = "XMinioAdminNoSuchPolicy"
+)
+
+// scopedAccessKey returns the access key of the user dedicated to a volume.
+func scopedAccessKey(volumeID string) string {
+	sum := sha256.Sum256([]byte(volumeID))
+	return scopedAccessKeyPrefix + hex.EncodeToString(sum[:])[:scopedAccessKeyHashLen]
+}
+
+// scopedSecretKey derives the secret key of a scoped user from the credentials that created it. Only volumes created
+// before the scoped credentials were stored in the secret of the volume still mount with it.
+func scopedSecretKey(rootSecretKey, accessKey string) string {
+	mac := hmac.New(sha256.New, []byte(rootSecretKey))
+	mac.Write([]byte(accessKey))
+	return hex.EncodeToString(mac.Sum(nil))[:scopedSecretKeyLen]
+}
+
+// newScopedSecretKey generates the secret key of a scoped user.
+func newScopedSecretKey() (string, error) {
+	key := make([]byte, scopedSecretKeyLen/2)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// volumeSecretName returns the name of the secret keeping the scoped credentials of a volume, in the namespace of the
+// driver. StorageClasses pass it to the nodes with csi.storage.k8s.io/node-publish-secret-name set to
+// open-object-volume-${pv.name}, so nodes never receive the credentials that created it. The secret outlives rotations
+// of the root secret key.
+func volumeSecretName(volumeID string) string {
+	return volumeSecretPrefix + volumeID
+}
+
+// ensureVolumeSecret stores the credentials of the scoped user of a volume in the secret of the volume, and returns
+// the secret key stored. A retried CreateVolume gets the key of the secret it created.
+func (driver *MinIODriver) ensureVolumeSecret(ctx context.Context, volumeID, accessKey, secretKey string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        volumeSecretName(volumeID),
+			Namespace:   common.DriverNamespace(),
+			Annotations: map[string]string{AnnoSecretVolume: volumeID},
+		},
+		StringData: map[string]string{
+			SecretMinIOHost: driver.Endpoint,
+			SecretRegion:    driver.Region,
+			SecretAccessKey: accessKey,
+			SecretSecretKey: secretKey,
+		},
+	}
+	created, err := driver.kubeClient.CoreV1().Secrets(secret.Namespace).Create(ctx, secret, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		created, err = driver.kubeClient.CoreV1().Secrets(secret.Namespace).Get(ctx, secret.Name, metav1.GetOptions{})
+		if err == nil && created.Annotations[AnnoSecretVolume] != volumeID {
+			return nil, fmt.Errorf("secret %s/%s does not belong to volume %s", secret.Namespace, secret.Name, volumeID)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fail to save the credentials of volume %s: %s", volumeID, err.Error())
+	}
+	return created, nil
+}
+
+// volumeCredentials returns the scoped credentials of the secret of a volume, referenced by namespace/name.
+func (driver *MinIODriver) volumeCredentials(ctx context.Context, ref string) (string, string, error) {
+	namespace, name, _ := strings.Cut(ref, "/")
+	secret, err := driver.kubeClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("fail to get the credentials of the volume from secret %s: %s", ref, err.Error())
+	}
+	cfg, err := NewS3ConfigFromSecrets(secretData(secret))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid credentials in secret %s: %s", ref, err.Error())
+	}
+	return cfg.AK, cfg.SK, nil
+}
+
+// removeVolumeSecret removes the secret of a volume referenced by namespace/name, if any.
+func (driver *MinIODriver) removeVolumeSecret(ctx context.Context, ref string) error {
+	namespace, name, _ := strings.Cut(ref, "/")
+	err := driver.kubeClient.CoreV1().Secrets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("fail to remove the credentials of the volume in secret %s: %s", ref, err.Error())
+	}
+	return nil
+}
+
+// secretData returns the data of secret, with the string data of secrets not read back from the api server.
+func secretData(secret *corev1.Secret) map[string]string {
+	data := map[string]string{}
+	for key, value := range secret.Data {
+		data[key] = string(value)
+	}
+	for key, value := range secret.StringData {
+		data[key] = value
+	}
+	return data
+}
+
+type policyStatement struct {
+	Effect    string                         `json:"Effect"`
+	Action    []string                       `json:"Action"`
+	Resource  []string                       `json:"Resource"`
+	Condition map[string]map[string][]string `json:"Condition,omitempty"`
+}
+
+type policyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []policyStatement `json:"Statement"`
+}
+
+// bucketPolicy returns a policy granting read and write access to a single bucket,
+// or only to the objects under prefix when it is not empty.
+func bucketPolicy(bucketName, prefix string) ([]byte, error) {
+	list := policyStatement{
+		Effect:   "Allow",
+		Action:   []string{"s3:GetBucketLocation", "s3:ListBucket", "s3:ListBucketMultipartUploads"},
+		Resource: []string{"arn:aws:s3:::" + bucketName},
+	}
+	if prefix != "" {
+		list.Condition = map[string]map[string][]string{
+			"StringLike": {"s3:prefix": {prefix, objectPrefix(prefix) + "*"}},
+		}
+	}
+	return json.Marshal(policyDocument{
+		Version: "2012-10-17",
+		Statement: []policyStatement{
+			list,
+			{
+				Effect:   "Allow",
+				Action:   []string{"s3:GetObject", "s3:PutObject", "s3:DeleteObject", "s3:AbortMultipartUpload", "s3:ListMultipartUploadParts"},
+				Resource: []string{"arn:aws:s3:::" + bucketName + "/" + objectPrefix(prefix) + "*"},
+			},
+		},
+	})
+}
+
+// CreateScopedUser creates a user, and a policy with the same name, that can only access bucketName,
+// or its objects under prefix. It is idempotent, so CreateVolume can be retried.
+func (driver *MinIOClient) CreateScopedUser(accessKey, secretKey, bucketName, prefix string) error {
+	if !driver.HasAdmin() {
+		return fmt.Errorf("scoped users are not supported without the minio admin api")
+	}
+	ctx := context.Background()
+	policy, err := bucketPolicy(bucketName, prefix)
+	if err != nil {
+		return err
+	}
+	if err := driver.madmin.AddCannedPolicy(ctx, accessKey, policy); err != nil {
+		return fmt.Errorf("fail to add policy %s: %s", accessKey, err.Error())
+	}
+	if err := driver.madmin.AddUser(ctx, accessKey, secretKey); err != nil {
+		return fmt.Errorf("fail to add user %s: %s", accessKey, err.Error())
+	}
+	if _, err := driver.madmin.AttachPolicy(ctx, madmin.PolicyAssociationReq{
+		Policies: []string{accessKey},
+		User:     accessKey,
+	}); err != nil && madmin.ToErrorResponse(err).Code != adminErrPolicyAlreadyApplied {
+		return fmt.Errorf("fail to attach policy to user %s: %s", accessKey, err.Error())
+	}
+	klog.Infof("scoped user %s created for bucket %s", accessKey, path.Join(bucketName, prefix))
+	return nil
+}
+
+// RemoveScopedUser revokes a user created by CreateScopedUser, ignoring parts already removed.
+func (driver *MinIOClient) RemoveScopedUser(accessKey string) error {
+	if !driver.HasAdmin() {
+		return fmt.Errorf("scoped users are not supported without the minio admin api")
+	}
+	ctx := context.Background()
+	if err := driver.madmin.RemoveUser(ctx, accessKey); err != nil && madmin.ToErrorResponse(err).Code != adminErrNoSuchUser {
+		return fmt.Errorf("fail to remove user %s: %s", accessKey, err.Error())
+	}
+	if err := driver.madmin.RemoveCannedPolicy(ctx, accessKey); err != nil && madmin.ToErrorResponse(err).Code != adminErrNoSuchPolicy {
+		return fmt.Errorf("fail to remove policy %s: %s", accessKey, err.Error())
+	}
+	klog.Infof("scoped user %s removed", accessKey)
+	return nil
+}
@@ -0,0 +1,86 @@
+package s3minio
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/smartystreets/goconvey/convey"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+func TestScopedCredentials(t *testing.T) {
+	convey.Convey("test scoped credentials", t, func() {
+		accessKey := scopedAccessKey("fuse-3bcc7b3d-c8c3-418b-85f6-08b083faa329")
+		convey.So(len(accessKey), convey.ShouldBeLessThanOrEqualTo, 20)
+		convey.So(accessKey, convey.ShouldEqual, scopedAccessKey("fuse-3bcc7b3d-c8c3-418b-85f6-08b083faa329"))
+		convey.So(accessKey, convey.ShouldNotEqual, scopedAccessKey("fuse-other"))
+
+		secretKey := scopedSecretKey("root-secret", accessKey)
+		convey.So(len(secretKey), convey.ShouldEqual, scopedSecretKeyLen)
+		convey.So(secretKey, convey.ShouldEqual, scopedSecretKey("root-secret", accessKey))
+		convey.So(secretKey, convey.ShouldNotEqual, scopedSecretKey("other-secret", accessKey))
+
+		policy, err := bucketPolicy("fuse-bucket", "")
+		convey.So(err, convey.ShouldBeNil)
+		var doc policyDocument
+		convey.So(json.Unmarshal(policy, &doc), convey.ShouldBeNil)
+		convey.So(doc.Statement, convey.ShouldHaveLength, 2)
+		convey.So(doc.Statement[0].Resource, convey.ShouldResemble, []string{"arn:aws:s3:::fuse-bucket"})
+		convey.So(doc.Statement[1].Resource, convey.ShouldResemble, []string{"arn:aws:s3:::fuse-bucket/*"})
+		convey.So(doc.Statement[0].Condition, convey.ShouldBeNil)
+
+		// users of prefix volumes only reach the objects of their prefix
+		policy, err = bucketPolicy("shared-bucket", "pvc-1")
+		convey.So(err, convey.ShouldBeNil)
+		doc = policyDocument{}
+		convey.So(json.Unmarshal(policy, &doc), convey.ShouldBeNil)
+		convey.So(doc.Statement[0].Condition["StringLike"]["s3:prefix"], convey.ShouldResemble, []string{"pvc-1", "pvc-1/*"})
+		convey.So(doc.Statement[1].Resource, convey.ShouldResemble, []string{"arn:aws:s3:::shared-bucket/pvc-1/*"})
+	})
+}
+
+func TestVolumeSecret(t *testing.T) {
+	convey.Convey("test the secret keeping the scoped credentials of a volume", t, func() {
+		ctx := context.Background()
+		driver := &MinIODriver{S3Config: S3Config{Endpoint: "http://minio:9000", Region: "us-east-1", AK: "root", SK: "root-secret"},
+			name: DriverName, kubeClient: fake.NewSimpleClientset()}
+		accessKey := scopedAccessKey("pv-1")
+		secretKey, err := newScopedSecretKey()
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(len(secretKey), convey.ShouldEqual, scopedSecretKeyLen)
+		other, _ := newScopedSecretKey()
+		convey.So(other, convey.ShouldNotEqual, secretKey)
+
+		secret, err := driver.ensureVolumeSecret(ctx, "pv-1", accessKey, secretKey)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(secret.Name, convey.ShouldEqual, "open-object-volume-pv-1")
+		ref := secret.Namespace + "/" + secret.Name
+
+		// a retry keeps the key of the user it created
+		secret, err = driver.ensureVolumeSecret(ctx, "pv-1", accessKey, other)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(secretData(secret)[SecretSecretKey], convey.ShouldEqual, secretKey)
+
+		// nodes mount with the scoped credentials, whatever the root key becomes
+		driver.SK = "rotated-secret"
+		ak, sk, err := driver.volumeCredentials(ctx, ref)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(ak, convey.ShouldEqual, accessKey)
+		convey.So(sk, convey.ShouldEqual, secretKey)
+
+		// the secret of another volume is never taken over
+		_, err = driver.ensureVolumeSecret(ctx, "pv-1", accessKey, secretKey)
+		convey.So(err, convey.ShouldBeNil)
+		secret.Annotations[AnnoSecretVolume] = "pv-2"
+		_, err = driver.kubeClient.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+		convey.So(err, convey.ShouldBeNil)
+		_, err = driver.ensureVolumeSecret(ctx, "pv-1", accessKey, secretKey)
+		convey.So(err, convey.ShouldNotBeNil)
+
+		convey.So(driver.removeVolumeSecret(ctx, ref), convey.ShouldBeNil)
+		convey.So(driver.removeVolumeSecret(ctx, ref), convey.ShouldBeNil)
+		_, _, err = driver.volumeCredentials(ctx, ref)
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
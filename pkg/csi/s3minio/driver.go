@@ -8,20 +8,25 @@ import (
 	"github.com/minio/madmin-go/v3"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 	"k8s.io/utils/mount"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 )
 
 type MinIODriver struct {
 	S3Config
+	// name is the driverName of the backend, recorded in the volume context
+	name        string
 	minioClient *MinIOClient
-	kubeClient  *kubernetes.Clientset
+	kubeClient  kubernetes.Interface
 }
 
 func NewMinIODriver(config *S3Config) (*MinIODriver, error) {
@@ -29,7 +34,19 @@ func NewMinIODriver(config *S3Config) (*MinIODriver, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newDriver(DriverName, config, minioClient), nil
+}
+
+// NewS3Driver creates the driver of the generic S3 backend, which works without the MinIO admin API.
+func NewS3Driver(config *S3Config) (*MinIODriver, error) {
+	minioClient, err := NewS3Client(config)
+	if err != nil {
+		return nil, err
+	}
+	return newDriver(S3DriverName, config, minioClient), nil
+}
 
+func newDriver(name string, config *S3Config, minioClient *MinIOClient) *MinIODriver {
 	k8sCfg, err := clientcmd.BuildConfigFromFlags("", "")
 	if err != nil {
 		klog.Fatalf("Error building kubeconfig: %s", err.Error())
@@ -41,44 +58,193 @@ func NewMinIODriver(config *S3Config) (*MinIODriver, error) {
 
 	return &MinIODriver{
 		S3Config:    *config,
+		name:        name,
 		minioClient: minioClient,
 		kubeClient:  kubeClient,
-	}, nil
+	}
 }
 
 func (driver *MinIODriver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
 	volumeParam := req.GetParameters()
 	bucketName := req.GetName()
+	prefix := ""
 	// pvc info
+	if _, err := GetMounter(volumeParam[ParamMounter]); err != nil {
+		return &csi.CreateVolumeResponse{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	reclaim, err := newReclaimPolicy(volumeParam)
+	if err != nil {
+		return &csi.CreateVolumeResponse{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	settings, err := newBucketSettings(volumeParam)
+	if err != nil {
+		return &csi.CreateVolumeResponse{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	provisionType := ProvisionType(volumeParam[ParamProvisionTypeTag])
+	switch provisionType {
+	case "":
+		provisionType = ProvisionTypeBucketOrCreate
+	case ProvisionTypeBucketOrCreate:
+	case ProvisionTypePrefix:
+		// every volume of the storageclass shares its bucket, the name of the volume is unique in it
+		if volumeParam[ParamBucketNameTag] == "" {
+			return &csi.CreateVolumeResponse{}, status.Errorf(codes.InvalidArgument, "%s is required by provision type %s", ParamBucketNameTag, ProvisionTypePrefix)
+		}
+		bucketName, prefix = volumeParam[ParamBucketNameTag], req.GetName()
+	default:
+		return &csi.CreateVolumeResponse{}, status.Errorf(codes.InvalidArgument, "unknown provision type %q, expect %s or %s", provisionType, ProvisionTypeBucketOrCreate, ProvisionTypePrefix)
+	}
+	if settings.enabled() && provisionType != ProvisionTypeBucketOrCreate {
+		return &csi.CreateVolumeResponse{}, status.Errorf(codes.InvalidArgument, "%s, %s and the bucket default %s are only supported by provision type %s",
+			ParamVersioning, ParamObjectLock, ParamEncryption, ProvisionTypeBucketOrCreate)
+	}
+	if err := driver.checkQuotaSupported(settings.Quota, provisionType); err != nil {
+		return &csi.CreateVolumeResponse{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if settings.Encryption.Type == EncryptionSSEC {
+		// only s3fs sends the customer key with its requests
+		if mounter := volumeParam[ParamMounter]; mounter != "" && mounter != MounterS3FS {
+			return &csi.CreateVolumeResponse{}, status.Errorf(codes.InvalidArgument, "%s %s is only supported by mounter %s", ParamEncryption, EncryptionSSEC, MounterS3FS)
+		}
+		// server side copies need the customer key
+		if req.GetVolumeContentSource() != nil || reclaim.Mode == ReclaimArchive {
+			return &csi.CreateVolumeResponse{}, status.Errorf(codes.InvalidArgument, "volumes encrypted with %s cannot be restored, cloned or archived", EncryptionSSEC)
+		}
+		settings.Encryption = settings.Encryption.forPVC(volumeParam[ParamPVCNameSpace])
+		if _, err := driver.customerKey(ctx, settings.Encryption, volumeParam[ParamPVCNameSpace]); err != nil {
+			return &csi.CreateVolumeResponse{}, err
+		}
+		volumeParam[ParamSSECKeySecretNamespace] = settings.Encryption.KeySecretNamespace
+	}
+	adopt := false
+	var pvc *corev1.PersistentVolumeClaim
 	pvcName := volumeParam[ParamPVCName]
 	pvcNamespace := volumeParam[ParamPVCNameSpace]
 	if pvcName == "" || pvcNamespace == "" {
 		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: pvcName(%s) or pvcNamespace(%s) is empty", pvcName, pvcNamespace)
 	} else {
-		pvc, err := driver.kubeClient.CoreV1().PersistentVolumeClaims(pvcNamespace).Get(ctx, pvcName, metav1.GetOptions{})
+		pvc, err = driver.kubeClient.CoreV1().PersistentVolumeClaims(pvcNamespace).Get(ctx, pvcName, metav1.GetOptions{})
 		if err != nil {
 			return &csi.CreateVolumeResponse{}, err
 		}
 		anno := pvc.GetAnnotations()
-		if name, exist := anno[AnnoBucketName]; exist {
+		if template := volumeParam[ParamBucketNameTemplate]; template != "" && provisionType == ProvisionTypeBucketOrCreate {
+			bucketName, err = renderBucketName(template, bucketNameVars{
+				PVCNamespace: pvcNamespace,
+				PVCName:      pvcName,
+				PVName:       req.GetName(),
+				ClusterID:    DefaultOwnerIdentity.Cluster,
+			})
+			if err != nil {
+				return &csi.CreateVolumeResponse{}, status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+		if name, exist := anno[AnnoBucketName]; exist && provisionType == ProvisionTypeBucketOrCreate {
+			// names chosen by users are checked, never rewritten
+			if err := common.ValidateBucketName(name); err != nil {
+				return &csi.CreateVolumeResponse{}, status.Error(codes.InvalidArgument, err.Error())
+			}
+			policy, err := loadBucketNamePolicy(ctx, driver.kubeClient)
+			if err != nil {
+				return &csi.CreateVolumeResponse{}, status.Error(codes.Internal, err.Error())
+			}
+			if policy != nil {
+				if err := policy.Check(pvcNamespace, name); err != nil {
+					eventRecorder(driver.kubeClient).Event(pvc, corev1.EventTypeWarning, EventReasonBucketNameDenied, err.Error())
+					return &csi.CreateVolumeResponse{}, status.Error(codes.PermissionDenied, err.Error())
+				}
+			}
 			bucketName = name
 		}
+		// adopting an existing bucket needs both the storageclass and the pvc to opt in
+		adopt = volumeParam[ParamAllowAdoption] == "true" && anno[AnnoAdoptBucket] == "true"
+	}
+	if err := common.ValidateBucketName(bucketName); err != nil {
+		return &csi.CreateVolumeResponse{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	lc, err := newVolumeLifecycle(volumeParam, pvc.GetAnnotations())
+	if err != nil {
+		return &csi.CreateVolumeResponse{}, status.Error(codes.InvalidArgument, err.Error())
 	}
 
 	capacity := req.GetCapacityRange().RequiredBytes
-	if err := driver.minioClient.CreateBucket(bucketName, capacity); err != nil {
-		return &csi.CreateVolumeResponse{}, status.Error(codes.Internal, err.Error())
+	var snapshot *snapshotRef
+	if id := req.GetVolumeContentSource().GetSnapshot().GetSnapshotId(); id != "" {
+		if snapshot, err = driver.lookupSnapshot(id); err != nil {
+			return &csi.CreateVolumeResponse{}, err
+		}
+		if capacity > 0 && capacity < snapshot.Manifest.SizeBytes {
+			return &csi.CreateVolumeResponse{}, status.Errorf(codes.OutOfRange, "snapshot %s of %d bytes does not fit in %d bytes", id, snapshot.Manifest.SizeBytes, capacity)
+		}
+	}
+	var clone *cloneRef
+	workers := defaultCloneWorkers
+	if id := req.GetVolumeContentSource().GetVolume().GetVolumeId(); id != "" {
+		if clone, err = driver.lookupClone(ctx, id, capacity); err != nil {
+			return &csi.CreateVolumeResponse{}, err
+		}
+		if workers, err = cloneWorkers(volumeParam); err != nil {
+			return &csi.CreateVolumeResponse{}, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	if provisionType == ProvisionTypePrefix {
+		// the shared bucket has no quota, the capacity of a prefix is only reported
+		if err := driver.minioClient.CreatePrefix(bucketName, prefix); err != nil {
+			return &csi.CreateVolumeResponse{}, status.Error(codes.Internal, err.Error())
+		}
+		volumeParam[ParamPrefixTag] = prefix
+	} else if err := driver.minioClient.CreateBucket(bucketName, capacity, newBucketOwner(req.GetName()), adopt, settings); err != nil {
+		return &csi.CreateVolumeResponse{}, ownershipStatus(err)
+	}
+	if snapshot != nil {
+		if err := driver.minioClient.RestoreSnapshot(snapshot.Bucket, snapshot.Name, snapshot.Manifest, bucketName, prefix); err != nil {
+			return &csi.CreateVolumeResponse{}, status.Error(codes.Internal, err.Error())
+		}
+	}
+	if clone != nil {
+		report := func(p cloneProgress) {
+			klog.Infof("s3: clone of volume %s to %s: %s", clone.VolumeID, path.Join(bucketName, prefix), p)
+			eventRecorder(driver.kubeClient).Eventf(pvc, corev1.EventTypeNormal, EventReasonCloneProgress, "cloning volume %s: %s", clone.VolumeID, p)
+		}
+		if _, err := driver.minioClient.CloneObjects(clone.Bucket, clone.Prefix, bucketName, prefix, workers, report); err != nil {
+			return &csi.CreateVolumeResponse{}, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	if !lc.empty() {
+		if err := driver.minioClient.SetVolumeLifecycle(bucketName, prefix, lc); err != nil {
+			return &csi.CreateVolumeResponse{}, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	// mount with a user that can only access this bucket, so a pod cannot reach other buckets through its key
+	if DefaultFeatureGate.Enabled(ScopedCredentials) && driver.minioClient.HasAdmin() {
+		accessKey := scopedAccessKey(req.GetName())
+		secretKey, err := newScopedSecretKey()
+		if err != nil {
+			return &csi.CreateVolumeResponse{}, status.Error(codes.Internal, err.Error())
+		}
+		secret, err := driver.ensureVolumeSecret(ctx, req.GetName(), accessKey, secretKey)
+		if err != nil {
+			return &csi.CreateVolumeResponse{}, status.Error(codes.Internal, err.Error())
+		}
+		if err := driver.minioClient.CreateScopedUser(accessKey, secretData(secret)[SecretSecretKey], bucketName, prefix); err != nil {
+			return &csi.CreateVolumeResponse{}, status.Error(codes.Internal, err.Error())
+		}
+		volumeParam[ParamAccessKeyTag] = accessKey
+		volumeParam[ParamVolumeSecretTag] = secret.Namespace + "/" + secret.Name
 	}
 
-	volumeParam[ParamProvisionTypeTag] = string(ProvisionTypeBucketOrCreate)
+	volumeParam[ParamProvisionTypeTag] = string(provisionType)
 	volumeParam[ParamBucketNameTag] = bucketName
-	volumeParam[common.ParamDriverName] = DriverName
+	volumeParam[common.ParamDriverName] = driver.name
 
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
 			VolumeId:      req.GetName(),
 			CapacityBytes: capacity,
 			VolumeContext: volumeParam,
+			ContentSource: req.GetVolumeContentSource(),
 		},
 	}, nil
 }
@@ -88,9 +254,85 @@ func (driver *MinIODriver) DeleteVolume(ctx context.Context, req *csi.DeleteVolu
 	if err != nil {
 		return &csi.DeleteVolumeResponse{}, status.Error(codes.Internal, err.Error())
 	}
+	if isStaticVolume(pv.Spec.CSI.VolumeAttributes) {
+		klog.Infof("s3: volume %s is a static volume, its bucket is kept", req.GetVolumeId())
+		return &csi.DeleteVolumeResponse{}, nil
+	}
 
-	bucketName := pv.Spec.CSI.VolumeAttributes[ParamBucketNameTag]
-	if err := driver.minioClient.DeleteBucket(bucketName); err != nil {
+	bucketName, prefix, err := volumeSource(req.GetVolumeId(), pv.Spec.CSI.VolumeAttributes)
+	if err != nil {
+		return &csi.DeleteVolumeResponse{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	policy, err := newReclaimPolicy(pv.Spec.CSI.VolumeAttributes)
+	if err != nil {
+		return &csi.DeleteVolumeResponse{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// the bucket of a prefix volume is shared, its prefix is named after the volume
+	if prefix == "" {
+		if err := driver.minioClient.VerifyBucketOwner(bucketName, newBucketOwner(req.GetVolumeId())); err != nil {
+			return &csi.DeleteVolumeResponse{}, ownershipStatus(err)
+		}
+	}
+	// objects under retention cannot be removed, the volume is kept whole until they can
+	if policy.Mode != ReclaimRetain {
+		if err := driver.minioClient.CheckRetention(ctx, bucketName, prefix); err != nil {
+			return &csi.DeleteVolumeResponse{}, retentionStatus(err)
+		}
+		// snapshots outlive their volume, version snapshots are nothing but the versions of its objects
+		snapshots, err := driver.minioClient.VersionSnapshots(driver.name, bucketName, prefix)
+		if err != nil {
+			return &csi.DeleteVolumeResponse{}, status.Error(codes.Internal, err.Error())
+		}
+		if len(snapshots) > 0 {
+			return &csi.DeleteVolumeResponse{}, status.Errorf(codes.FailedPrecondition, "volume %s keeps the object versions of %s snapshots %s, delete them first",
+				req.GetVolumeId(), SnapshotModeVersion, strings.Join(snapshots, ", "))
+		}
+	}
+	source := path.Join(bucketName, prefix)
+	switch policy.Mode {
+	case ReclaimRetain:
+		// a retained bucket no longer belongs to the driver, the bucket of a prefix volume still does
+		var drop []string
+		if prefix == "" {
+			drop = []string{MetaDataCapacity, MetaDataPrivisionType, TagOwnerVolume, TagOwnerCluster, TagOwnerDriver}
+		}
+		if err := driver.minioClient.RecordReclaim(bucketName, prefix, newReclaimRecord(ReclaimRetain, req.GetVolumeId(), source)); err != nil {
+			return &csi.DeleteVolumeResponse{}, status.Error(codes.Internal, err.Error())
+		}
+		if len(drop) > 0 {
+			if err := driver.minioClient.ReleaseBucket(bucketName, drop...); err != nil {
+				return &csi.DeleteVolumeResponse{}, status.Error(codes.Internal, err.Error())
+			}
+		}
+		if err := driver.revokeScopedUser(ctx, pv.Spec.CSI.VolumeAttributes); err != nil {
+			return &csi.DeleteVolumeResponse{}, status.Error(codes.Internal, err.Error())
+		}
+		klog.Infof("s3: volume %s deleted, %s is retained", req.GetVolumeId(), source)
+		return &csi.DeleteVolumeResponse{}, nil
+	case ReclaimArchive:
+		archivePrefix := policy.archivePrefix(req.GetVolumeId())
+		if err := driver.minioClient.ArchiveObjects(bucketName, prefix, policy.ArchiveBucket, archivePrefix, policy.ArchivePrefix, policy.ExpiryDays); err != nil {
+			return &csi.DeleteVolumeResponse{}, status.Error(codes.Internal, err.Error())
+		}
+		if err := driver.minioClient.RecordReclaim(policy.ArchiveBucket, archivePrefix, newReclaimRecord(ReclaimArchive, req.GetVolumeId(), source)); err != nil {
+			return &csi.DeleteVolumeResponse{}, status.Error(codes.Internal, err.Error())
+		}
+		klog.Infof("s3: volume %s archived to %s", req.GetVolumeId(), path.Join(policy.ArchiveBucket, archivePrefix))
+	}
+	if prefix != "" {
+		// the shared bucket outlives the prefix, the lifecycle rule of the prefix goes with it
+		if err := driver.minioClient.SetVolumeLifecycle(bucketName, prefix, volumeLifecycle{}); err != nil {
+			klog.Warningf("s3: fail to remove the lifecycle rule of volume %s: %s", req.GetVolumeId(), err.Error())
+		}
+		err = driver.minioClient.DeletePrefix(bucketName, prefix)
+	} else {
+		err = driver.minioClient.DeleteBucket(bucketName)
+	}
+	if err != nil {
+		return &csi.DeleteVolumeResponse{}, status.Error(codes.Internal, err.Error())
+	}
+	// the user is revoked once the data is reclaimed, a retried DeleteVolume still reaches the data
+	if err := driver.revokeScopedUser(ctx, pv.Spec.CSI.VolumeAttributes); err != nil {
 		return &csi.DeleteVolumeResponse{}, status.Error(codes.Internal, err.Error())
 	}
 
@@ -102,10 +344,24 @@ func (driver *MinIODriver) ControllerExpandVolume(ctx context.Context, req *csi.
 	if err != nil {
 		return &csi.ControllerExpandVolumeResponse{}, status.Error(codes.Internal, err.Error())
 	}
+	if isStaticVolume(pv.Spec.CSI.VolumeAttributes) {
+		return &csi.ControllerExpandVolumeResponse{}, status.Errorf(codes.FailedPrecondition, "volume %s is a static volume, its bucket is not managed by the driver", req.GetVolumeId())
+	}
 
 	bucketName := pv.Spec.CSI.VolumeAttributes[ParamBucketNameTag]
 	capacity := req.GetCapacityRange().RequiredBytes
-	if DefaultFeatureGate.Enabled(Quota) {
+	if ProvisionType(pv.Spec.CSI.VolumeAttributes[ParamProvisionTypeTag]) == ProvisionTypePrefix {
+		// the capacity of a prefix volume is the capacity of its PV, which the resizer updates
+		return &csi.ControllerExpandVolumeResponse{CapacityBytes: capacity, NodeExpansionRequired: false}, nil
+	}
+	if err := driver.minioClient.VerifyBucketOwner(bucketName, newBucketOwner(req.GetVolumeId())); err != nil {
+		return &csi.ControllerExpandVolumeResponse{}, ownershipStatus(err)
+	}
+	quota, err := newQuotaPolicy(pv.Spec.CSI.VolumeAttributes)
+	if err != nil {
+		return &csi.ControllerExpandVolumeResponse{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if quota.hard() && DefaultFeatureGate.Enabled(Quota) && driver.minioClient.HasAdmin() {
 		if err := driver.minioClient.SetBucketQuota(bucketName, capacity, madmin.HardQuota); err != nil {
 			return &csi.ControllerExpandVolumeResponse{}, status.Error(codes.Internal, err.Error())
 		}
@@ -123,6 +379,105 @@ func (driver *MinIODriver) ControllerExpandVolume(ctx context.Context, req *csi.
 	return &csi.ControllerExpandVolumeResponse{CapacityBytes: capacity, NodeExpansionRequired: false}, nil
 }
 
+func (driver *MinIODriver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	class, err := newSnapshotClass(req.GetParameters())
+	if err != nil {
+		return &csi.CreateSnapshotResponse{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := common.ValidateBucketName(class.Bucket); err != nil {
+		return &csi.CreateSnapshotResponse{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	pv, err := driver.kubeClient.CoreV1().PersistentVolumes().Get(ctx, req.GetSourceVolumeId(), metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return &csi.CreateSnapshotResponse{}, status.Errorf(codes.NotFound, "volume %s not found", req.GetSourceVolumeId())
+		}
+		return &csi.CreateSnapshotResponse{}, status.Error(codes.Internal, err.Error())
+	}
+	bucketName, prefix, err := volumeSource(req.GetSourceVolumeId(), pv.Spec.CSI.VolumeAttributes)
+	if err != nil {
+		return &csi.CreateSnapshotResponse{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if bucketName == class.Bucket {
+		return &csi.CreateSnapshotResponse{}, status.Errorf(codes.InvalidArgument, "%s %s is the bucket of volume %s", ParamSnapshotBucket, class.Bucket, req.GetSourceVolumeId())
+	}
+	if err := checkCopyable(req.GetSourceVolumeId(), pv.Spec.CSI.VolumeAttributes); err != nil {
+		return &csi.CreateSnapshotResponse{}, err
+	}
+
+	// a complete snapshot with the same name is a retry, or a name already taken by another volume
+	manifest, err := driver.minioClient.GetSnapshotManifest(class.Bucket, req.GetName())
+	if err != nil {
+		return &csi.CreateSnapshotResponse{}, status.Error(codes.Internal, err.Error())
+	}
+	if manifest != nil && manifest.SourceVolume != req.GetSourceVolumeId() {
+		return &csi.CreateSnapshotResponse{}, status.Errorf(codes.AlreadyExists, "snapshot %s already exists for volume %s", req.GetName(), manifest.SourceVolume)
+	}
+	if manifest == nil {
+		manifest, err = driver.minioClient.CreateSnapshot(class.Bucket, req.GetName(), class.Mode, req.GetSourceVolumeId(), bucketName, prefix)
+		if err != nil {
+			return &csi.CreateSnapshotResponse{}, snapshotStatus(err)
+		}
+		klog.Infof("s3: %s snapshot %s of volume %s created in bucket %s", class.Mode, req.GetName(), req.GetSourceVolumeId(), class.Bucket)
+	}
+
+	return &csi.CreateSnapshotResponse{Snapshot: driver.csiSnapshot(class.Bucket, req.GetName(), manifest)}, nil
+}
+
+func (driver *MinIODriver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	_, bucketName, name, err := parseSnapshotID(req.GetSnapshotId())
+	if err != nil {
+		// the driver never returned such an id, so there is no such snapshot
+		klog.Warningf("s3: ignoring deletion of snapshot %s: %s", req.GetSnapshotId(), err.Error())
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+	if err := driver.minioClient.DeleteSnapshot(bucketName, name); err != nil {
+		return &csi.DeleteSnapshotResponse{}, status.Error(codes.Internal, err.Error())
+	}
+	klog.Infof("s3: snapshot %s deleted", req.GetSnapshotId())
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+func (driver *MinIODriver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	var entries []*csi.ListSnapshotsResponse_Entry
+	if id := req.GetSnapshotId(); id != "" {
+		_, bucketName, name, err := parseSnapshotID(id)
+		if err != nil {
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		manifest, err := driver.minioClient.GetSnapshotManifest(bucketName, name)
+		if err != nil {
+			return &csi.ListSnapshotsResponse{}, status.Error(codes.Internal, err.Error())
+		}
+		if manifest != nil {
+			entries = append(entries, &csi.ListSnapshotsResponse_Entry{Snapshot: driver.csiSnapshot(bucketName, name, manifest)})
+		}
+	} else {
+		buckets, err := driver.minioClient.SnapshotBuckets()
+		if err != nil {
+			return &csi.ListSnapshotsResponse{}, status.Error(codes.Internal, err.Error())
+		}
+		for _, bucketName := range buckets {
+			snapshots, err := driver.minioClient.ListSnapshots(bucketName)
+			if err != nil {
+				return &csi.ListSnapshotsResponse{}, status.Error(codes.Internal, err.Error())
+			}
+			for _, name := range sortedSnapshotNames(snapshots) {
+				if source := req.GetSourceVolumeId(); source != "" && snapshots[name].SourceVolume != source {
+					continue
+				}
+				entries = append(entries, &csi.ListSnapshotsResponse_Entry{Snapshot: driver.csiSnapshot(bucketName, name, snapshots[name])})
+			}
+		}
+	}
+
+	page, next, err := pageSnapshots(entries, req.GetStartingToken(), req.GetMaxEntries())
+	if err != nil {
+		return &csi.ListSnapshotsResponse{}, err
+	}
+	return &csi.ListSnapshotsResponse{Entries: page, NextToken: next}, nil
+}
+
 func (driver *MinIODriver) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
 	return &csi.NodeExpandVolumeResponse{}, status.Error(codes.Unimplemented, "NodeExpandVolume is not implemented")
 }
@@ -136,12 +491,17 @@ func (driver *MinIODriver) NodeUnstageVolume(ctx context.Context, req *csi.NodeU
 }
 
 func (driver *MinIODriver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
-	pv, err := driver.kubeClient.CoreV1().PersistentVolumes().Get(ctx, req.GetVolumeId(), metav1.GetOptions{})
+	// the volume context carries everything needed to mount, static PVs never went through CreateVolume
+	attrs := req.GetVolumeContext()
+	bucketName, prefix, err := volumeSource(req.GetVolumeId(), attrs)
 	if err != nil {
-		return &csi.NodePublishVolumeResponse{}, status.Error(codes.Internal, err.Error())
+		return &csi.NodePublishVolumeResponse{}, status.Error(codes.InvalidArgument, err.Error())
 	}
-	bucketName := pv.Spec.CSI.VolumeAttributes[ParamBucketNameTag]
 	targetPath := req.GetTargetPath()
+	mounter, err := GetMounter(attrs[ParamMounter])
+	if err != nil {
+		return &csi.NodePublishVolumeResponse{}, status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	notMnt, err := checkMount(targetPath)
 	if err != nil {
@@ -151,15 +511,76 @@ func (driver *MinIODriver) NodePublishVolume(ctx context.Context, req *csi.NodeP
 		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
-	if err := S3FSMount(driver.Endpoint, bucketName, targetPath, driver.AK, driver.SK); err != nil {
+	// the node-publish secret of scoped volumes is the secret of the volume, unless the StorageClass passes another
+	ak, sk := driver.AK, driver.SK
+	if accessKey := attrs[ParamAccessKeyTag]; accessKey != "" && accessKey != driver.AK {
+		if ref := attrs[ParamVolumeSecretTag]; ref != "" {
+			if ak, sk, err = driver.volumeCredentials(ctx, ref); err != nil {
+				return &csi.NodePublishVolumeResponse{}, status.Error(codes.FailedPrecondition, err.Error())
+			}
+		} else {
+			// volumes created before the scoped credentials were stored derive them from the root secret key
+			ak, sk = accessKey, scopedSecretKey(driver.SK, accessKey)
+		}
+	}
+	mountReq := &common.MountRequest{VolumeID: req.GetVolumeId(), Target: targetPath, Backend: driver.name}
+	opts := &MountOptions{Endpoint: driver.Endpoint, Region: driver.Region, Bucket: bucketName, Prefix: prefix, AccessKey: ak, SecretKey: sk,
+		ReadOnly: isReadOnly(req)}
+	// the volume handle of static PVs is not the name of their PV, which then only adds the capacity and secret reference
+	pv, err := driver.kubeClient.CoreV1().PersistentVolumes().Get(ctx, req.GetVolumeId(), metav1.GetOptions{})
+	if err == nil && pv.Spec.CSI != nil && pv.Spec.CSI.VolumeHandle == req.GetVolumeId() {
+		if ref := pv.Spec.CSI.NodePublishSecretRef; ref != nil {
+			mountReq.SecretRef = ref.Namespace + "/" + ref.Name
+		}
+		if capacity, ok := pv.Spec.Capacity[corev1.ResourceStorage]; ok {
+			opts.Capacity = capacity.Value()
+		}
+	} else if err != nil && !errors.IsNotFound(err) {
+		return &csi.NodePublishVolumeResponse{}, status.Error(codes.Internal, err.Error())
+	}
+	encryption, err := newVolumeEncryption(attrs)
+	if err != nil {
+		return &csi.NodePublishVolumeResponse{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if encryption.Type == EncryptionSSEC {
+		if mounter.Name() != MounterS3FS {
+			return &csi.NodePublishVolumeResponse{}, status.Errorf(codes.InvalidArgument, "%s %s is only supported by mounter %s", ParamEncryption, EncryptionSSEC, MounterS3FS)
+		}
+		if opts.SSECustomerKey, err = driver.customerKey(ctx, encryption, attrs[ParamPVCNameSpace]); err != nil {
+			return &csi.NodePublishVolumeResponse{}, err
+		}
+	}
+	if err := MountBucket(mounter, opts, mountReq); err != nil {
 		return &csi.NodePublishVolumeResponse{}, err
 	}
 
-	klog.Infof("s3: bucket %s successfully mounted to %s", bucketName, targetPath)
+	klog.Infof("s3: bucket %s successfully mounted to %s with %s", path.Join(bucketName, prefix), targetPath, mounter.Name())
 	return &csi.NodePublishVolumeResponse{}, nil
 
 }
 
+// revokeScopedUser removes the scoped user of a volume and the secret keeping its credentials, if any.
+func (driver *MinIODriver) revokeScopedUser(ctx context.Context, attrs map[string]string) error {
+	if accessKey := attrs[ParamAccessKeyTag]; accessKey != "" {
+		if err := driver.minioClient.RemoveScopedUser(accessKey); err != nil {
+			return err
+		}
+	}
+	if ref := attrs[ParamVolumeSecretTag]; ref != "" {
+		return driver.removeVolumeSecret(ctx, ref)
+	}
+	return nil
+}
+
+// isReadOnly reports whether a volume is published readonly, or with an access mode that only reads.
+func isReadOnly(req *csi.NodePublishVolumeRequest) bool {
+	switch req.GetVolumeCapability().GetAccessMode().GetMode() {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY, csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		return true
+	}
+	return req.GetReadonly()
+}
+
 func (driver *MinIODriver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	volumeID := req.GetVolumeId()
 	targetPath := req.GetTargetPath()
@@ -172,15 +593,21 @@ func (driver *MinIODriver) NodeUnpublishVolume(ctx context.Context, req *csi.Nod
 		return &csi.NodeUnpublishVolumeResponse{}, status.Error(codes.InvalidArgument, "Target path missing in request")
 	}
 
-	mountPoint := req.GetTargetPath()
-	if !isS3fsMounted(mountPoint) {
-		klog.Infof("Directory is not mounted: %s", mountPoint)
+	info, err := findFuseMount(targetPath)
+	if err != nil {
+		return &csi.NodeUnpublishVolumeResponse{}, status.Error(codes.Internal, err.Error())
+	}
+	if info == nil {
+		klog.Infof("Directory is not mounted: %s", targetPath)
 		return &csi.NodeUnpublishVolumeResponse{}, nil
 	}
 
-	if err := S3FSUmount(targetPath); err != nil {
+	if err := common.FuseUmount(targetPath); err != nil {
 		return &csi.NodeUnpublishVolumeResponse{}, err
 	}
+	if err := common.RemoveMountCredentials(volumeID, targetPath); err != nil {
+		return &csi.NodeUnpublishVolumeResponse{}, status.Error(codes.Internal, err.Error())
+	}
 	klog.Infof("s3: mountpoint %s has been unmounted.", targetPath)
 
 	return &csi.NodeUnpublishVolumeResponse{}, nil
@@ -198,8 +625,17 @@ func (driver *MinIODriver) NodeGetVolumeStats(ctx context.Context, req *csi.Node
 	if err != nil {
 		return &csi.NodeGetVolumeStatsResponse{}, status.Error(codes.Internal, err.Error())
 	}
-	bucketName := pv.Spec.CSI.VolumeAttributes[ParamBucketNameTag]
-	available, capacity, usage, inodes, inodesFree, inodesUsed, err := driver.minioClient.FsInfo(bucketName)
+	bucketName, prefix, err := volumeSource(volumeID, pv.Spec.CSI.VolumeAttributes)
+	if err != nil {
+		return &csi.NodeGetVolumeStatsResponse{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	var available, capacity, usage, inodes, inodesFree, inodesUsed int64
+	if ProvisionType(pv.Spec.CSI.VolumeAttributes[ParamProvisionTypeTag]) == ProvisionTypePrefix {
+		pvCapacity := pv.Spec.Capacity[corev1.ResourceStorage]
+		available, capacity, usage, inodes, inodesFree, inodesUsed, err = driver.minioClient.PrefixFsInfo(bucketName, prefix, pvCapacity.Value())
+	} else {
+		available, capacity, usage, inodes, inodesFree, inodesUsed, err = driver.minioClient.FsInfo(bucketName)
+	}
 	if err != nil {
 		return &csi.NodeGetVolumeStatsResponse{}, status.Error(codes.Internal, err.Error())
 	}
@@ -230,6 +666,13 @@ func checkMount(targetPath string) (bool, error) {
 				return false, err
 			}
 			notMnt = true
+		} else if mount.IsCorruptedMnt(err) {
+			// the fuse process is gone, detach the dead mount so it can be mounted again
+			klog.Warningf("mountpoint %s is disconnected, remounting it: %s", targetPath, err.Error())
+			if err = common.FuseDetach(targetPath); err != nil {
+				return false, err
+			}
+			notMnt = true
 		} else {
 			return false, err
 		}
@@ -237,15 +680,3 @@ func checkMount(targetPath string) (bool, error) {
 
 	return notMnt, nil
 }
-
-func isS3fsMounted(mountPath string) bool {
-	checkMountCountCmd := fmt.Sprintf("%s mount | grep %s | grep %s | grep -v grep | wc -l", common.NsenterCmd, mountPath, S3FSType)
-	out, err := common.RunCommand(checkMountCountCmd)
-	if err != nil {
-		return false
-	}
-	if strings.TrimSpace(out) == "0" {
-		return false
-	}
-	return true
-}
@@ -0,0 +1,138 @@
+package s3minio
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	"github.com/minio/minio-go/v7/pkg/sse"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+)
+
+// EncryptionType is the server side encryption of the objects of a volume.
+type EncryptionType string
+
+const (
+	EncryptionNone EncryptionType = ""
+	// EncryptionSSES3 encrypts objects with keys managed by the object store, through the default encryption of the bucket
+	EncryptionSSES3 EncryptionType = "SSE-S3"
+	// EncryptionSSEKMS encrypts objects with a key of the KMS of the object store, through the default encryption of the bucket
+	EncryptionSSEKMS EncryptionType = "SSE-KMS"
+	// EncryptionSSEC encrypts objects with a key of the customer, sent with every request by the fuse client
+	EncryptionSSEC EncryptionType = "SSE-C"
+
+	// sseCustomerKeyField is the field of the secret holding the SSE-C key, 32 bytes, raw or base64 encoded
+	sseCustomerKeyField = "key"
+	sseCustomerKeySize  = 32
+	// sseCustomerKeyPVCNamespace in ParamSSECKeySecretNamespace reads the key from the namespace of each PVC
+	sseCustomerKeyPVCNamespace = "${pvc.namespace}"
+)
+
+// volumeEncryption is the encryption of the volumes of a StorageClass.
+type volumeEncryption struct {
+	Type EncryptionType
+	// KMSKeyID is the key of SSE-KMS, empty uses the default key of the KMS
+	KMSKeyID string
+	// KeySecretName and KeySecretNamespace reference the secret holding the key of SSE-C
+	KeySecretName      string
+	KeySecretNamespace string
+}
+
+// newVolumeEncryption parses the encryption parameters of a StorageClass.
+func newVolumeEncryption(params map[string]string) (volumeEncryption, error) {
+	enc := volumeEncryption{
+		Type:               EncryptionType(strings.ToUpper(params[ParamEncryption])),
+		KMSKeyID:           params[ParamKMSKeyID],
+		KeySecretName:      params[ParamSSECKeySecretName],
+		KeySecretNamespace: params[ParamSSECKeySecretNamespace],
+	}
+	switch enc.Type {
+	case EncryptionNone, EncryptionSSES3:
+	case EncryptionSSEKMS:
+		return volumeEncryption{Type: enc.Type, KMSKeyID: enc.KMSKeyID}, nil
+	case EncryptionSSEC:
+		if enc.KeySecretName == "" || enc.KeySecretNamespace == "" {
+			return volumeEncryption{}, fmt.Errorf("%s %s requires %s and %s", ParamEncryption, EncryptionSSEC, ParamSSECKeySecretName, ParamSSECKeySecretNamespace)
+		}
+		return volumeEncryption{Type: enc.Type, KeySecretName: enc.KeySecretName, KeySecretNamespace: enc.KeySecretNamespace}, nil
+	default:
+		return volumeEncryption{}, fmt.Errorf("invalid %s %q, expect %s, %s or %s", ParamEncryption, params[ParamEncryption], EncryptionSSES3, EncryptionSSEKMS, EncryptionSSEC)
+	}
+	if enc.KMSKeyID != "" {
+		return volumeEncryption{}, fmt.Errorf("%s requires %s %s", ParamKMSKeyID, ParamEncryption, EncryptionSSEKMS)
+	}
+	return volumeEncryption{Type: enc.Type}, nil
+}
+
+// bucketDefault returns the default encryption of the bucket of the volume, nil when the bucket has none.
+// SSE-C keys never reach the object store but with the requests of the mounts.
+func (e volumeEncryption) bucketDefault() *sse.Configuration {
+	switch e.Type {
+	case EncryptionSSES3:
+		return sse.NewConfigurationSSES3()
+	case EncryptionSSEKMS:
+		return sse.NewConfigurationSSEKMS(e.KMSKeyID)
+	}
+	return nil
+}
+
+// tags records the encryption in the tags of a bucket, so it can be audited. The key of SSE-C is only referenced.
+func (e volumeEncryption) tags() map[string]string {
+	if e.Type == EncryptionNone {
+		return nil
+	}
+	tags := map[string]string{TagEncryption: string(e.Type)}
+	if e.KMSKeyID != "" {
+		tags[TagEncryptionKMSKeyID] = e.KMSKeyID
+	}
+	if e.Type == EncryptionSSEC {
+		tags[TagEncryptionKeySecret] = e.KeySecretNamespace + "/" + e.KeySecretName
+	}
+	return tags
+}
+
+// checkCopyable refuses volumes whose objects cannot be copied server side, which snapshots and clones rely on.
+func checkCopyable(volumeID string, attrs map[string]string) error {
+	if EncryptionType(strings.ToUpper(attrs[ParamEncryption])) == EncryptionSSEC {
+		return status.Errorf(codes.InvalidArgument, "volume %s is encrypted with %s, its objects cannot be copied without the customer key", volumeID, EncryptionSSEC)
+	}
+	return nil
+}
+
+// forPVC resolves the namespace of the SSE-C key secret of a PVC in pvcNamespace.
+func (e volumeEncryption) forPVC(pvcNamespace string) volumeEncryption {
+	if e.Type == EncryptionSSEC && e.KeySecretNamespace == sseCustomerKeyPVCNamespace {
+		e.KeySecretNamespace = pvcNamespace
+	}
+	return e
+}
+
+// customerKey reads the SSE-C key of e from its secret and returns it base64 encoded, as fuse clients take it.
+// Only the secrets of pvcNamespace and of the driver namespace are read, a StorageClass cannot lend the key of
+// another namespace to its volumes.
+func (driver *MinIODriver) customerKey(ctx context.Context, e volumeEncryption, pvcNamespace string) (string, error) {
+	if e.KeySecretNamespace != pvcNamespace && e.KeySecretNamespace != common.DriverNamespace() {
+		return "", status.Errorf(codes.PermissionDenied, "SSE-C key secret %s/%s is neither in the namespace of the PVC nor of the driver",
+			e.KeySecretNamespace, e.KeySecretName)
+	}
+	secret, err := driver.kubeClient.CoreV1().Secrets(e.KeySecretNamespace).Get(ctx, e.KeySecretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", status.Errorf(codes.FailedPrecondition, "SSE-C key secret %s/%s not found", e.KeySecretNamespace, e.KeySecretName)
+	} else if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	key := secret.Data[sseCustomerKeyField]
+	if len(key) != sseCustomerKeySize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(key)))
+		if err != nil || len(decoded) != sseCustomerKeySize {
+			return "", status.Errorf(codes.InvalidArgument, "field %s of SSE-C key secret %s/%s is not a key of %d bytes, raw or base64 encoded",
+				sseCustomerKeyField, e.KeySecretNamespace, e.KeySecretName, sseCustomerKeySize)
+		}
+		key = decoded
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
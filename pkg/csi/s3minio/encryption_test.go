@@ -0,0 +1,94 @@
+package s3minio
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/minio/minio-go/v7/pkg/sse"
+	"github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"strings"
+	"testing"
+)
+
+func TestVolumeEncryption(t *testing.T) {
+	convey.Convey("test encryption parameters", t, func() {
+		enc, err := newVolumeEncryption(map[string]string{})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(enc.bucketDefault(), convey.ShouldBeNil)
+		convey.So(enc.tags(), convey.ShouldBeEmpty)
+
+		enc, err = newVolumeEncryption(map[string]string{ParamEncryption: "sse-kms", ParamKMSKeyID: "volume-key"})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(enc.bucketDefault(), convey.ShouldResemble, sse.NewConfigurationSSEKMS("volume-key"))
+		convey.So(enc.tags(), convey.ShouldResemble, map[string]string{TagEncryption: "SSE-KMS", TagEncryptionKMSKeyID: "volume-key"})
+
+		params := map[string]string{ParamEncryption: "SSE-C", ParamSSECKeySecretName: "volume-key", ParamSSECKeySecretNamespace: "default"}
+		enc, err = newVolumeEncryption(params)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(enc.bucketDefault(), convey.ShouldBeNil)
+		convey.So(enc.tags(), convey.ShouldResemble, map[string]string{TagEncryption: "SSE-C", TagEncryptionKeySecret: "default/volume-key"})
+		convey.So(status.Code(checkCopyable("pvc-1", params)), convey.ShouldEqual, codes.InvalidArgument)
+		convey.So(checkCopyable("pvc-1", map[string]string{}), convey.ShouldBeNil)
+
+		_, err = newVolumeEncryption(map[string]string{ParamEncryption: "SSE-C"})
+		convey.So(err, convey.ShouldNotBeNil)
+		_, err = newVolumeEncryption(map[string]string{ParamEncryption: "SSE-S3", ParamKMSKeyID: "volume-key"})
+		convey.So(err, convey.ShouldNotBeNil)
+		_, err = newVolumeEncryption(map[string]string{ParamEncryption: "AES"})
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
+
+func TestCustomerKey(t *testing.T) {
+	convey.Convey("test SSE-C keys", t, func() {
+		raw := []byte(strings.Repeat("k", sseCustomerKeySize))
+		driver := &MinIODriver{kubeClient: fake.NewSimpleClientset(
+			&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "raw"}, Data: map[string][]byte{"key": raw}},
+			&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "encoded"}, Data: map[string][]byte{"key": []byte(base64.StdEncoding.EncodeToString(raw) + "\n")}},
+			&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "short"}, Data: map[string][]byte{"key": []byte("secret")}},
+		)}
+		ctx := context.Background()
+		for _, name := range []string{"raw", "encoded"} {
+			key, err := driver.customerKey(ctx, volumeEncryption{Type: EncryptionSSEC, KeySecretName: name, KeySecretNamespace: "default"}, "default")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(key, convey.ShouldEqual, base64.StdEncoding.EncodeToString(raw))
+		}
+		_, err := driver.customerKey(ctx, volumeEncryption{Type: EncryptionSSEC, KeySecretName: "short", KeySecretNamespace: "default"}, "default")
+		convey.So(status.Code(err), convey.ShouldEqual, codes.InvalidArgument)
+		_, err = driver.customerKey(ctx, volumeEncryption{Type: EncryptionSSEC, KeySecretName: "missing", KeySecretNamespace: "default"}, "default")
+		convey.So(status.Code(err), convey.ShouldEqual, codes.FailedPrecondition)
+
+		// the keys of other namespaces are not lent to the volumes
+		_, err = driver.customerKey(ctx, volumeEncryption{Type: EncryptionSSEC, KeySecretName: "raw", KeySecretNamespace: "default"}, "team-a")
+		convey.So(status.Code(err), convey.ShouldEqual, codes.PermissionDenied)
+		enc := volumeEncryption{Type: EncryptionSSEC, KeySecretName: "raw", KeySecretNamespace: sseCustomerKeyPVCNamespace}
+		convey.So(enc.forPVC("team-a").KeySecretNamespace, convey.ShouldEqual, "team-a")
+		_, err = driver.customerKey(ctx, enc.forPVC("default"), "default")
+		convey.So(err, convey.ShouldBeNil)
+	})
+}
+
+func TestBucketEncryption(t *testing.T) {
+	convey.Convey("test bucket default encryption against a stand-in server", t, func() {
+		fake := newFakeS3()
+		defer fake.Close()
+
+		c, err := NewS3Client(fake.config())
+		convey.So(err, convey.ShouldBeNil)
+		settings, err := newBucketSettings(map[string]string{ParamEncryption: "SSE-S3"})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(settings.enabled(), convey.ShouldBeTrue)
+		convey.So(c.CreateBucket("encrypted", 1024, newBucketOwner("pv-encrypted"), false, settings), convey.ShouldBeNil)
+
+		config, err := c.mclient.GetBucketEncryption(context.Background(), "encrypted")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(config.Rules[0].Apply.SSEAlgorithm, convey.ShouldEqual, "AES256")
+		tags, err := c.bucketTags("encrypted")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(tags[TagEncryption], convey.ShouldEqual, "SSE-S3")
+	})
+}
@@ -0,0 +1,472 @@
+package s3minio
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeS3 is a stand-in S3 service speaking the subset of the path style S3 API used by the driver.
+// It has no MinIO admin API, so it behaves like a generic S3 compatible service.
+type fakeS3 struct {
+	sync.Mutex
+	server  *httptest.Server
+	buckets map[string]*fakeBucket
+	// requests are the methods and urls served, e.g. GET /bucket?prefix=
+	requests []string
+}
+
+type fakeBucket struct {
+	tags       map[string]string
+	objects    map[string]*fakeObject
+	versioning string
+	objectLock bool
+	// lifecycle and encryption are the configurations of the bucket as they were put
+	lifecycle  []byte
+	encryption []byte
+}
+
+type fakeObject struct {
+	data     []byte
+	modified time.Time
+	// retentionMode and retainUntil are the retention of the object, it is only enforced by CheckRetention
+	retentionMode string
+	retainUntil   string
+}
+
+func newFakeS3() *fakeS3 {
+	f := &fakeS3{buckets: map[string]*fakeBucket{}}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+func (f *fakeS3) Close() {
+	f.server.Close()
+}
+
+func (f *fakeS3) config() *S3Config {
+	return &S3Config{AK: "ak", SK: "sk", Region: "us-east-1", Endpoint: f.server.URL}
+}
+
+type fakeTagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	Tags    []struct {
+		Key   string `xml:"Key"`
+		Value string `xml:"Value"`
+	} `xml:"TagSet>Tag"`
+}
+
+type fakeListResult struct {
+	XMLName        xml.Name         `xml:"ListBucketResult"`
+	Name           string           `xml:"Name"`
+	Prefix         string           `xml:"Prefix"`
+	KeyCount       int              `xml:"KeyCount"`
+	MaxKeys        int              `xml:"MaxKeys"`
+	IsTruncated    bool             `xml:"IsTruncated"`
+	NextMarker     string           `xml:"NextMarker,omitempty"`
+	Contents       []fakeListObject `xml:"Contents"`
+	CommonPrefixes []fakeListPrefix `xml:"CommonPrefixes"`
+}
+
+type fakeListObject struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type fakeListVersionsResult struct {
+	XMLName     xml.Name          `xml:"ListVersionsResult"`
+	Name        string            `xml:"Name"`
+	Prefix      string            `xml:"Prefix"`
+	MaxKeys     int               `xml:"MaxKeys"`
+	IsTruncated bool              `xml:"IsTruncated"`
+	Versions    []fakeListVersion `xml:"Version"`
+}
+
+type fakeListVersion struct {
+	fakeListObject
+	VersionID string `xml:"VersionId"`
+	IsLatest  bool   `xml:"IsLatest"`
+}
+
+type fakeListPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type fakeDelete struct {
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+func (f *fakeS3) serve(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.RequestURI())
+
+	if strings.HasPrefix(r.URL.Path, "/minio/admin/") {
+		writeFakeError(w, http.StatusNotFound, "NotImplemented", "admin api is not available")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucketName, key, _ := strings.Cut(path, "/")
+	query := r.URL.Query()
+	bucket := f.buckets[bucketName]
+
+	if key == "" {
+		f.serveBucket(w, r, bucketName, bucket, query)
+		return
+	}
+	if bucket == nil {
+		writeFakeError(w, http.StatusNotFound, "NoSuchBucket", "bucket does not exist")
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			f.copyObject(w, bucket, key, source)
+			return
+		}
+		data, err := readFakeBody(r)
+		if err != nil {
+			writeFakeError(w, http.StatusBadRequest, "InvalidRequest", err.Error())
+			return
+		}
+		obj := &fakeObject{
+			data:          data,
+			modified:      time.Now().UTC(),
+			retentionMode: r.Header.Get("X-Amz-Object-Lock-Mode"),
+			retainUntil:   r.Header.Get("X-Amz-Object-Lock-Retain-Until-Date"),
+		}
+		bucket.objects[key] = obj
+		w.Header().Set("ETag", obj.etag())
+	case http.MethodGet, http.MethodHead:
+		obj, exist := bucket.objects[key]
+		if !exist {
+			writeFakeError(w, http.StatusNotFound, "NoSuchKey", "object does not exist")
+			return
+		}
+		if _, isRetention := query["retention"]; isRetention {
+			if obj.retentionMode == "" {
+				writeFakeError(w, http.StatusNotFound, "NoSuchObjectLockConfiguration", "no retention")
+				return
+			}
+			writeFakeXML(w, struct {
+				XMLName         xml.Name `xml:"Retention"`
+				Mode            string   `xml:"Mode"`
+				RetainUntilDate string   `xml:"RetainUntilDate"`
+			}{Mode: obj.retentionMode, RetainUntilDate: obj.retainUntil})
+			return
+		}
+		if _, isLegalHold := query["legal-hold"]; isLegalHold {
+			writeFakeError(w, http.StatusNotFound, "NoSuchObjectLockConfiguration", "no legal hold")
+			return
+		}
+		w.Header().Set("ETag", obj.etag())
+		w.Header().Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(obj.data)
+		}
+	case http.MethodDelete:
+		delete(bucket.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeFakeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+// copyObject serves a server side copy of source, the escaped path of the source object.
+func (f *fakeS3) copyObject(w http.ResponseWriter, bucket *fakeBucket, key, source string) {
+	source, _, _ = strings.Cut(source, "?")
+	source, err := url.PathUnescape(strings.TrimPrefix(source, "/"))
+	if err != nil {
+		writeFakeError(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+	srcBucketName, srcKey, _ := strings.Cut(source, "/")
+	srcBucket := f.buckets[srcBucketName]
+	if srcBucket == nil || srcBucket.objects[srcKey] == nil {
+		writeFakeError(w, http.StatusNotFound, "NoSuchKey", "source object does not exist")
+		return
+	}
+	obj := &fakeObject{data: srcBucket.objects[srcKey].data, modified: time.Now().UTC()}
+	bucket.objects[key] = obj
+	writeFakeXML(w, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		LastModified string   `xml:"LastModified"`
+		ETag         string   `xml:"ETag"`
+	}{LastModified: obj.modified.Format(time.RFC3339), ETag: obj.etag()})
+}
+
+func (f *fakeS3) serveBucket(w http.ResponseWriter, r *http.Request, name string, bucket *fakeBucket, query map[string][]string) {
+	_, isTagging := query["tagging"]
+	_, isLocation := query["location"]
+	_, isDelete := query["delete"]
+	_, isVersioning := query["versioning"]
+	_, isObjectLock := query["object-lock"]
+	_, isVersions := query["versions"]
+	_, isLifecycle := query["lifecycle"]
+	_, isEncryption := query["encryption"]
+
+	if r.Method == http.MethodPut && !isTagging && !isVersioning && !isObjectLock && !isLifecycle && !isEncryption {
+		if bucket != nil {
+			writeFakeError(w, http.StatusConflict, "BucketAlreadyOwnedByYou", "bucket exists")
+			return
+		}
+		f.buckets[name] = &fakeBucket{
+			tags:       map[string]string{},
+			objects:    map[string]*fakeObject{},
+			objectLock: r.Header.Get("X-Amz-Bucket-Object-Lock-Enabled") == "true",
+		}
+		if f.buckets[name].objectLock {
+			f.buckets[name].versioning = "Enabled"
+		}
+		return
+	}
+	if bucket == nil {
+		writeFakeError(w, http.StatusNotFound, "NoSuchBucket", "bucket does not exist")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodHead:
+	case isLocation:
+		writeFakeXML(w, struct {
+			XMLName xml.Name `xml:"LocationConstraint"`
+			Value   string   `xml:",chardata"`
+		}{Value: "us-east-1"})
+	case isTagging && r.Method == http.MethodPut:
+		var tagging fakeTagging
+		if err := xml.NewDecoder(r.Body).Decode(&tagging); err != nil {
+			writeFakeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+			return
+		}
+		bucket.tags = map[string]string{}
+		for _, tag := range tagging.Tags {
+			bucket.tags[tag.Key] = tag.Value
+		}
+	case isTagging && r.Method == http.MethodGet:
+		if len(bucket.tags) == 0 {
+			writeFakeError(w, http.StatusNotFound, "NoSuchTagSet", "no tags")
+			return
+		}
+		var tagging fakeTagging
+		for k, v := range bucket.tags {
+			tagging.Tags = append(tagging.Tags, struct {
+				Key   string `xml:"Key"`
+				Value string `xml:"Value"`
+			}{k, v})
+		}
+		writeFakeXML(w, tagging)
+	case isVersioning && r.Method == http.MethodPut:
+		var config struct {
+			Status string `xml:"Status"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&config); err != nil {
+			writeFakeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+			return
+		}
+		bucket.versioning = config.Status
+	case isVersioning && r.Method == http.MethodGet:
+		writeFakeXML(w, struct {
+			XMLName xml.Name `xml:"VersioningConfiguration"`
+			Status  string   `xml:"Status,omitempty"`
+		}{Status: bucket.versioning})
+	case isObjectLock && r.Method == http.MethodPut:
+		if !bucket.objectLock {
+			writeFakeError(w, http.StatusConflict, "InvalidBucketState", "object lock is not enabled")
+			return
+		}
+	case isObjectLock && r.Method == http.MethodGet:
+		if !bucket.objectLock {
+			writeFakeError(w, http.StatusNotFound, "ObjectLockConfigurationNotFoundError", "object lock is not enabled")
+			return
+		}
+		writeFakeXML(w, struct {
+			XMLName           xml.Name `xml:"ObjectLockConfiguration"`
+			ObjectLockEnabled string   `xml:"ObjectLockEnabled"`
+		}{ObjectLockEnabled: "Enabled"})
+	case isLifecycle && r.Method == http.MethodPut:
+		config, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeFakeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+			return
+		}
+		bucket.lifecycle = config
+	case isLifecycle && r.Method == http.MethodGet:
+		if bucket.lifecycle == nil {
+			writeFakeError(w, http.StatusNotFound, "NoSuchLifecycleConfiguration", "no lifecycle")
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(bucket.lifecycle)
+	case isLifecycle && r.Method == http.MethodDelete:
+		bucket.lifecycle = nil
+		w.WriteHeader(http.StatusNoContent)
+	case isEncryption && r.Method == http.MethodPut:
+		config, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeFakeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+			return
+		}
+		bucket.encryption = config
+	case isEncryption && r.Method == http.MethodGet:
+		if bucket.encryption == nil {
+			writeFakeError(w, http.StatusNotFound, "ServerSideEncryptionConfigurationNotFoundError", "no encryption")
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(bucket.encryption)
+	case isVersions && r.Method == http.MethodGet:
+		// every object has a single version
+		list := bucket.list(name, r.URL.Query().Get("prefix"), "")
+		result := fakeListVersionsResult{Name: name, Prefix: list.Prefix, MaxKeys: list.MaxKeys}
+		for _, object := range list.Contents {
+			result.Versions = append(result.Versions, fakeListVersion{fakeListObject: object, VersionID: "null", IsLatest: true})
+		}
+		writeFakeXML(w, result)
+	case isTagging && r.Method == http.MethodDelete:
+		bucket.tags = map[string]string{}
+		w.WriteHeader(http.StatusNoContent)
+	case isDelete && r.Method == http.MethodPost:
+		var req fakeDelete
+		if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeFakeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+			return
+		}
+		for _, obj := range req.Objects {
+			delete(bucket.objects, obj.Key)
+		}
+		writeFakeXML(w, struct {
+			XMLName xml.Name `xml:"DeleteResult"`
+		}{})
+	case r.Method == http.MethodGet:
+		result := bucket.list(name, r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter"))
+		result.page(r.URL.Query().Get("marker"), r.URL.Query().Get("max-keys"))
+		writeFakeXML(w, result)
+	case r.Method == http.MethodDelete:
+		if len(bucket.objects) != 0 {
+			writeFakeError(w, http.StatusConflict, "BucketNotEmpty", "bucket is not empty")
+			return
+		}
+		delete(f.buckets, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeFakeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+func (b *fakeBucket) list(name, prefix, delimiter string) fakeListResult {
+	result := fakeListResult{Name: name, Prefix: prefix, MaxKeys: 1000}
+	seenPrefixes := map[string]bool{}
+	keys := make([]string, 0, len(b.objects))
+	for key := range b.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				p := key[:len(prefix)+i+len(delimiter)]
+				if !seenPrefixes[p] {
+					seenPrefixes[p] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, fakeListPrefix{Prefix: p})
+				}
+				continue
+			}
+		}
+		obj := b.objects[key]
+		result.Contents = append(result.Contents, fakeListObject{
+			Key:          key,
+			LastModified: obj.modified.Format(time.RFC3339),
+			ETag:         obj.etag(),
+			Size:         int64(len(obj.data)),
+			StorageClass: "STANDARD",
+		})
+	}
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+	return result
+}
+
+// page keeps the objects of a v1 listing after marker, at most maxKeys of them.
+func (l *fakeListResult) page(marker, maxKeys string) {
+	contents := l.Contents[:0]
+	for _, object := range l.Contents {
+		if object.Key > marker {
+			contents = append(contents, object)
+		}
+	}
+	l.Contents = contents
+	if n, err := strconv.Atoi(maxKeys); err == nil && n > 0 && len(l.Contents) > n {
+		l.Contents, l.MaxKeys, l.IsTruncated = l.Contents[:n], n, true
+		l.NextMarker = l.Contents[n-1].Key
+	}
+	l.KeyCount = len(l.Contents) + len(l.CommonPrefixes)
+}
+
+func (o *fakeObject) etag() string {
+	sum := md5.Sum(o.data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// readFakeBody reads the object body, decoding the aws-chunked encoding minio-go uses over plain http.
+func readFakeBody(r *http.Request) ([]byte, error) {
+	if r.Header.Get("X-Amz-Decoded-Content-Length") == "" {
+		return io.ReadAll(r.Body)
+	}
+	var data bytes.Buffer
+	reader := bufio.NewReader(r.Body)
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(header), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad chunk header %q", header)
+		}
+		if size == 0 {
+			return data.Bytes(), nil
+		}
+		if _, err := io.CopyN(&data, reader, size); err != nil {
+			return nil, err
+		}
+		if _, err := reader.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func writeFakeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func writeFakeError(w http.ResponseWriter, code int, s3Code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(code)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: s3Code, Message: message})
+}
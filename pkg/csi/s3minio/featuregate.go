@@ -7,13 +7,19 @@ import (
 
 var (
 	Quota featuregate.Feature = "Quota"
+	// ScopedCredentials mounts every volume with a dedicated user that can only access its bucket
+	ScopedCredentials featuregate.Feature = "ScopedCredentials"
+	// MountCredentialEnv passes mount credentials to the fuse process as environment variables instead of a file
+	MountCredentialEnv featuregate.Feature = "MountCredentialEnv"
 
 	DefaultMutableFeatureGate featuregate.MutableFeatureGate = featuregate.NewFeatureGate()
 
 	DefaultFeatureGate featuregate.FeatureGate = DefaultMutableFeatureGate
 
 	defaultControllerFeatureGates = map[featuregate.Feature]featuregate.FeatureSpec{
-		Quota: {Default: true, PreRelease: featuregate.Alpha},
+		Quota:              {Default: true, PreRelease: featuregate.Alpha},
+		ScopedCredentials:  {Default: true, PreRelease: featuregate.Alpha},
+		MountCredentialEnv: {Default: false, PreRelease: featuregate.Alpha},
 	}
 )
 
@@ -0,0 +1,69 @@
+package s3minio
+
+func init() {
+	registerMounter(&goofysMounter{fuseMounter{fsType: "fuse.goofys"}})
+	registerMounter(&geesefsMounter{fuseMounter{fsType: "fuse.geesefs"}})
+}
+
+// goofysMounter mounts buckets with goofys, which is fast for sequential reads but not POSIX compliant.
+type goofysMounter struct {
+	fuseMounter
+}
+
+func (m *goofysMounter) Name() string {
+	return MounterGoofys
+}
+
+func (m *goofysMounter) Credentials(opts *MountOptions) (string, []string) {
+	return awsCredentials(opts)
+}
+
+func (m *goofysMounter) Command(opts *MountOptions, target, credentialFile string) (string, []string, []string) {
+	args := append(goofysArgs(opts), goofysBucket(opts), target)
+	var env []string
+	if credentialFile != "" {
+		env = []string{"AWS_SHARED_CREDENTIALS_FILE=" + credentialFile}
+	}
+	return MounterGoofys, args, env
+}
+
+// geesefsMounter mounts buckets with geesefs, a goofys fork with better POSIX support and caching.
+type geesefsMounter struct {
+	fuseMounter
+}
+
+func (m *geesefsMounter) Name() string {
+	return MounterGeesefs
+}
+
+func (m *geesefsMounter) Credentials(opts *MountOptions) (string, []string) {
+	return awsCredentials(opts)
+}
+
+func (m *geesefsMounter) Command(opts *MountOptions, target, credentialFile string) (string, []string, []string) {
+	args := goofysArgs(opts)
+	if credentialFile != "" {
+		args = append(args, "--shared-config", credentialFile)
+	}
+	return MounterGeesefs, append(args, goofysBucket(opts), target), nil
+}
+
+// goofysBucket returns the bucket argument of goofys and geesefs, which take the prefix after a colon.
+func goofysBucket(opts *MountOptions) string {
+	if opts.Prefix == "" {
+		return opts.Bucket
+	}
+	return opts.Bucket + ":" + opts.Prefix
+}
+
+// goofysArgs are the options goofys and geesefs have in common.
+func goofysArgs(opts *MountOptions) []string {
+	args := []string{"-f", "--endpoint", opts.Endpoint, "-o", "allow_other", "--file-mode=0666", "--dir-mode=0777"}
+	if opts.Region != "" {
+		args = append(args, "--region", opts.Region)
+	}
+	if opts.ReadOnly {
+		args = append(args, "-o", "ro")
+	}
+	return args
+}
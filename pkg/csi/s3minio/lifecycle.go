@@ -0,0 +1,189 @@
+package s3minio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	// EventReasonLifecycleInvalid is the reason of the PVC events of lifecycle annotations that cannot be applied
+	EventReasonLifecycleInvalid = "LifecycleInvalid"
+
+	lifecycleRulePrefix = "open-object-lifecycle"
+)
+
+// lifecycleParams are the StorageClass parameters, and PVC annotations, configuring the lifecycle of a volume.
+var lifecycleParams = []string{
+	ParamExpirationDays,
+	ParamNoncurrentExpirationDays,
+	ParamAbortUploadDays,
+	ParamTransitionDays,
+	ParamTransitionStorageClass,
+}
+
+// volumeLifecycle is the lifecycle of the objects of a volume, a zero field leaves its action out.
+type volumeLifecycle struct {
+	ExpirationDays           int
+	NoncurrentExpirationDays int
+	AbortUploadDays          int
+	TransitionDays           int
+	TransitionStorageClass   string
+}
+
+// newVolumeLifecycle parses the lifecycle parameters of a StorageClass, overridden by the annotations of a PVC.
+func newVolumeLifecycle(params, annotations map[string]string) (volumeLifecycle, error) {
+	values := map[string]string{}
+	for _, key := range lifecycleParams {
+		values[key] = params[key]
+		if value, ok := annotations[key]; ok {
+			values[key] = value
+		}
+	}
+	var lc volumeLifecycle
+	for key, days := range map[string]*int{
+		ParamExpirationDays:           &lc.ExpirationDays,
+		ParamNoncurrentExpirationDays: &lc.NoncurrentExpirationDays,
+		ParamAbortUploadDays:          &lc.AbortUploadDays,
+		ParamTransitionDays:           &lc.TransitionDays,
+	} {
+		if values[key] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(values[key])
+		if err != nil || n < 0 {
+			return volumeLifecycle{}, fmt.Errorf("invalid %s %q, expect a number of days, 0 disables it", key, values[key])
+		}
+		*days = n
+	}
+	lc.TransitionStorageClass = values[ParamTransitionStorageClass]
+	if (lc.TransitionDays > 0) != (lc.TransitionStorageClass != "") {
+		return volumeLifecycle{}, fmt.Errorf("%s and %s must be set together", ParamTransitionDays, ParamTransitionStorageClass)
+	}
+	if lc.TransitionDays > 0 && lc.ExpirationDays > 0 && lc.TransitionDays >= lc.ExpirationDays {
+		return volumeLifecycle{}, fmt.Errorf("%s must be less than %s", ParamTransitionDays, ParamExpirationDays)
+	}
+	return lc, nil
+}
+
+// empty reports whether the lifecycle has no action.
+func (lc volumeLifecycle) empty() bool {
+	return lc == volumeLifecycle{}
+}
+
+// lifecycleRuleID is the id of the rule of the volume under prefix, so the rules of the volumes of a shared bucket,
+// and the rules added by users, are told apart.
+func lifecycleRuleID(prefix string) string {
+	if prefix == "" {
+		return lifecycleRulePrefix
+	}
+	return lifecycleRulePrefix + "-" + strings.ReplaceAll(prefix, "/", "-")
+}
+
+// rule returns the lifecycle rule applying lc to the objects under prefix.
+func (lc volumeLifecycle) rule(prefix string) lifecycle.Rule {
+	rule := lifecycle.Rule{
+		ID:         lifecycleRuleID(prefix),
+		Status:     "Enabled",
+		RuleFilter: lifecycle.Filter{Prefix: objectPrefix(prefix)},
+	}
+	if lc.ExpirationDays > 0 {
+		rule.Expiration = lifecycle.Expiration{Days: lifecycle.ExpirationDays(lc.ExpirationDays)}
+	}
+	if lc.NoncurrentExpirationDays > 0 {
+		rule.NoncurrentVersionExpiration = lifecycle.NoncurrentVersionExpiration{NoncurrentDays: lifecycle.ExpirationDays(lc.NoncurrentExpirationDays)}
+	}
+	if lc.AbortUploadDays > 0 {
+		rule.AbortIncompleteMultipartUpload = lifecycle.AbortIncompleteMultipartUpload{DaysAfterInitiation: lifecycle.ExpirationDays(lc.AbortUploadDays)}
+	}
+	if lc.TransitionDays > 0 {
+		rule.Transition = lifecycle.Transition{Days: lifecycle.ExpirationDays(lc.TransitionDays), StorageClass: lc.TransitionStorageClass}
+	}
+	return rule
+}
+
+// lifecycleOf returns the lifecycle a rule written by rule applies.
+func lifecycleOf(rule lifecycle.Rule) volumeLifecycle {
+	return volumeLifecycle{
+		ExpirationDays:           int(rule.Expiration.Days),
+		NoncurrentExpirationDays: int(rule.NoncurrentVersionExpiration.NoncurrentDays),
+		AbortUploadDays:          int(rule.AbortIncompleteMultipartUpload.DaysAfterInitiation),
+		TransitionDays:           int(rule.Transition.Days),
+		TransitionStorageClass:   rule.Transition.StorageClass,
+	}
+}
+
+// SetVolumeLifecycle installs lc on the objects under prefix of bucketName, or removes the rule of the volume
+// when lc is empty. Other rules of the bucket are kept, and the bucket is left alone when its rule is up to date.
+func (driver *MinIOClient) SetVolumeLifecycle(bucketName, prefix string, lc volumeLifecycle) error {
+	ctx := context.Background()
+	config, err := driver.mclient.GetBucketLifecycle(ctx, bucketName)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code != errNoSuchLifecyle {
+			return fmt.Errorf("fail to get lifecycle of bucket %s: %s", bucketName, err.Error())
+		}
+		config = lifecycle.NewConfiguration()
+	}
+	ruleID := lifecycleRuleID(prefix)
+	current := volumeLifecycle{}
+	rules := config.Rules[:0]
+	for _, rule := range config.Rules {
+		if rule.ID == ruleID {
+			current = lifecycleOf(rule)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if current == lc {
+		return nil
+	}
+	config.Rules = rules
+	if !lc.empty() {
+		config.Rules = append(config.Rules, lc.rule(prefix))
+	}
+	// an empty configuration removes the lifecycle of the bucket
+	if err := driver.mclient.SetBucketLifecycle(ctx, bucketName, config); err != nil {
+		return fmt.Errorf("fail to set lifecycle of bucket %s: %s", bucketName, err.Error())
+	}
+	klog.Infof("lifecycle of %s set to %+v", path.Join(bucketName, prefix), lc)
+	return nil
+}
+
+// ReconcileVolume applies the lifecycle annotations of pvc to the bucket of its volume pv, and reports the usage of
+// soft quotas.
+func (driver *MinIODriver) ReconcileVolume(ctx context.Context, pv *corev1.PersistentVolume, pvc *corev1.PersistentVolumeClaim) error {
+	attrs := pv.Spec.CSI.VolumeAttributes
+	if isStaticVolume(attrs) {
+		return nil
+	}
+	bucketName, prefix, err := volumeSource(pv.Spec.CSI.VolumeHandle, attrs)
+	if err != nil {
+		return err
+	}
+	return errors.Join(
+		driver.reconcileLifecycle(pv, pvc, bucketName, prefix),
+		driver.reconcileSoftQuota(ctx, pv, pvc, bucketName, prefix),
+	)
+}
+
+// reconcileLifecycle applies the lifecycle annotations of pvc to the bucket of its volume pv.
+func (driver *MinIODriver) reconcileLifecycle(pv *corev1.PersistentVolume, pvc *corev1.PersistentVolumeClaim, bucketName, prefix string) error {
+	lc, err := newVolumeLifecycle(pv.Spec.CSI.VolumeAttributes, pvc.GetAnnotations())
+	if err != nil {
+		eventRecorder(driver.kubeClient).Event(pvc, corev1.EventTypeWarning, EventReasonLifecycleInvalid, err.Error())
+		return err
+	}
+	if prefix == "" {
+		if err := driver.minioClient.VerifyBucketOwner(bucketName, newBucketOwner(pv.Spec.CSI.VolumeHandle)); err != nil {
+			return err
+		}
+	}
+	return driver.minioClient.SetVolumeLifecycle(bucketName, prefix, lc)
+}
@@ -0,0 +1,83 @@
+package s3minio
+
+import (
+	"context"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	"github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestVolumeLifecycle(t *testing.T) {
+	convey.Convey("test lifecycle parameters", t, func() {
+		lc, err := newVolumeLifecycle(map[string]string{}, nil)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(lc.empty(), convey.ShouldBeTrue)
+
+		params := map[string]string{ParamExpirationDays: "30", ParamAbortUploadDays: "7"}
+		lc, err = newVolumeLifecycle(params, map[string]string{ParamExpirationDays: "90", ParamTransitionDays: "10", ParamTransitionStorageClass: "GLACIER"})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(lc, convey.ShouldResemble, volumeLifecycle{ExpirationDays: 90, AbortUploadDays: 7, TransitionDays: 10, TransitionStorageClass: "GLACIER"})
+		convey.So(lifecycleOf(lc.rule("pvc-1")), convey.ShouldResemble, lc)
+
+		// an annotation disables an action of the StorageClass
+		lc, err = newVolumeLifecycle(params, map[string]string{ParamExpirationDays: "0"})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(lc, convey.ShouldResemble, volumeLifecycle{AbortUploadDays: 7})
+
+		_, err = newVolumeLifecycle(map[string]string{ParamExpirationDays: "a month"}, nil)
+		convey.So(err, convey.ShouldNotBeNil)
+		_, err = newVolumeLifecycle(map[string]string{ParamTransitionDays: "10"}, nil)
+		convey.So(err, convey.ShouldNotBeNil)
+		_, err = newVolumeLifecycle(map[string]string{ParamExpirationDays: "10", ParamTransitionDays: "10", ParamTransitionStorageClass: "GLACIER"}, nil)
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
+
+func TestSetVolumeLifecycle(t *testing.T) {
+	convey.Convey("test lifecycle rules against a stand-in server", t, func() {
+		fake := newFakeS3()
+		defer fake.Close()
+		ctx := context.Background()
+
+		c, err := NewS3Client(fake.config())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c.mclient.MakeBucket(ctx, "shared", minio.MakeBucketOptions{}), convey.ShouldBeNil)
+		// a rule of the user is kept
+		config := lifecycle.NewConfiguration()
+		config.Rules = []lifecycle.Rule{{
+			ID:         "user-rule",
+			Status:     "Enabled",
+			RuleFilter: lifecycle.Filter{Prefix: "tmp/"},
+			Expiration: lifecycle.Expiration{Days: 1},
+		}}
+		convey.So(c.mclient.SetBucketLifecycle(ctx, "shared", config), convey.ShouldBeNil)
+
+		lc := volumeLifecycle{ExpirationDays: 30, NoncurrentExpirationDays: 7}
+		convey.So(c.SetVolumeLifecycle("shared", "pvc-1", lc), convey.ShouldBeNil)
+		convey.So(c.SetVolumeLifecycle("shared", "pvc-2", volumeLifecycle{AbortUploadDays: 1}), convey.ShouldBeNil)
+		config, err = c.mclient.GetBucketLifecycle(ctx, "shared")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(config.Rules, convey.ShouldHaveLength, 3)
+		convey.So(config.Rules[1].ID, convey.ShouldEqual, lifecycleRuleID("pvc-1"))
+		convey.So(config.Rules[1].RuleFilter.Prefix, convey.ShouldEqual, "pvc-1/")
+		convey.So(lifecycleOf(config.Rules[1]), convey.ShouldResemble, lc)
+
+		convey.Convey("an up to date rule leaves the bucket alone", func() {
+			convey.So(c.SetVolumeLifecycle("shared", "pvc-1", lc), convey.ShouldBeNil)
+			config, err := c.mclient.GetBucketLifecycle(ctx, "shared")
+			convey.So(err, convey.ShouldBeNil)
+			// a rewritten rule would follow the rule of pvc-2
+			convey.So(config.Rules[1].ID, convey.ShouldEqual, lifecycleRuleID("pvc-1"))
+		})
+
+		convey.Convey("an empty lifecycle removes the rule", func() {
+			convey.So(c.SetVolumeLifecycle("shared", "pvc-1", volumeLifecycle{}), convey.ShouldBeNil)
+			convey.So(c.SetVolumeLifecycle("shared", "pvc-2", volumeLifecycle{}), convey.ShouldBeNil)
+			config, err := c.mclient.GetBucketLifecycle(ctx, "shared")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(config.Rules, convey.ShouldHaveLength, 1)
+			convey.So(config.Rules[0].ID, convey.ShouldEqual, "user-rule")
+		})
+	})
+}
@@ -0,0 +1,149 @@
+package s3minio
+
+import (
+	"fmt"
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	"k8s.io/klog/v2"
+	"sort"
+	"strings"
+)
+
+// MountOptions is the bucket a mounter mounts, and the credentials to access it.
+type MountOptions struct {
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is the directory of the bucket to mount, without leading or trailing slashes, empty mounts the whole bucket
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	// Capacity of the volume in bytes, 0 when it is unknown
+	Capacity int64
+	// ReadOnly mounts refuse writes, for readonly publishes and reader-only access modes
+	ReadOnly bool
+	// SSECustomerKey is the base64 encoded SSE-C key of the volume, MountBucket writes it to SSECustomerKeyFile
+	SSECustomerKey     string
+	SSECustomerKeyFile string
+	// MetricsSocket is the host path of the socket the fuse client serves its metrics on, if it has any
+	MetricsSocket string
+}
+
+// Mounter mounts buckets on the host with a fuse client, selected by the mounter parameter of the StorageClass.
+type Mounter interface {
+	// Name is the value of the mounter parameter
+	Name() string
+	// Credentials returns the content of the credential file of a mount, and the environment
+	// passing the same credentials when MountCredentialEnv is enabled.
+	Credentials(opts *MountOptions) (file string, env []string)
+	// Command returns the command line of a fuse client that stays in the foreground.
+	// credentialFile is the host path of the credential file, empty when credentials are in the environment.
+	Command(opts *MountOptions, target, credentialFile string) (command string, args, env []string)
+	// Ready checks that target is mounted by this fuse client
+	Ready(target string) error
+	Unmount(target string) error
+}
+
+var mounters = map[string]Mounter{}
+
+func registerMounter(m Mounter) {
+	mounters[m.Name()] = m
+}
+
+// GetMounter returns the mounter selected by the mounter parameter, s3fs when it is empty.
+func GetMounter(name string) (Mounter, error) {
+	if name == "" {
+		name = MounterS3FS
+	}
+	m, ok := mounters[name]
+	if !ok {
+		var names []string
+		for n := range mounters {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown mounter %q, expect one of %s", name, strings.Join(names, ", "))
+	}
+	return m, nil
+}
+
+// MountBucket mounts the bucket of opts with m on the target of req, which also carries the volume, backend and secret reference.
+func MountBucket(m Mounter, opts *MountOptions, req *common.MountRequest) error {
+	removeLegacyPasswordOnce.Do(removeLegacyS3fsPassword)
+
+	content, env := m.Credentials(opts)
+	credentialFile := ""
+	if !DefaultFeatureGate.Enabled(MountCredentialEnv) {
+		path, err := common.WriteMountCredentials(req.VolumeID, req.Target, content)
+		if err != nil {
+			return err
+		}
+		credentialFile, env = path, nil
+	}
+	if opts.SSECustomerKey != "" {
+		path, err := common.WriteMountKey(req.VolumeID, req.Target, opts.SSECustomerKey+"\n")
+		if err != nil {
+			_ = common.RemoveMountCredentials(req.VolumeID, req.Target)
+			return err
+		}
+		opts.SSECustomerKeyFile = path
+	}
+	opts.MetricsSocket = common.MountMetricsSocket(req.VolumeID, req.Target)
+	command, args, cmdEnv := m.Command(opts, req.Target, credentialFile)
+	req.Command, req.Args, req.Env, req.Foreground = command, args, append(env, cmdEnv...), true
+
+	if err := common.FuseMount(req); err != nil {
+		_ = common.RemoveMountCredentials(req.VolumeID, req.Target)
+		return err
+	}
+	if err := m.Ready(req.Target); err != nil {
+		klog.Errorf("%s mount of %s is not ready: %s", m.Name(), req.Target, err.Error())
+		_ = m.Unmount(req.Target)
+		_ = common.RemoveMountCredentials(req.VolumeID, req.Target)
+		return err
+	}
+	return nil
+}
+
+// fuseMounter implements the readiness check and unmount shared by fuse clients.
+type fuseMounter struct {
+	fsType string
+}
+
+// Ready checks that the mount on target has the filesystem type of the fuse client.
+func (m fuseMounter) Ready(target string) error {
+	info, err := findFuseMount(target)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return fmt.Errorf("%s is not mounted", target)
+	}
+	if info.FSType != m.fsType {
+		return fmt.Errorf("%s is mounted as %s, expect %s", target, info.FSType, m.fsType)
+	}
+	return nil
+}
+
+func (m fuseMounter) Unmount(target string) error {
+	return common.FuseUmount(target)
+}
+
+// findFuseMount returns the fuse mount on target, nil when there is none.
+func findFuseMount(target string) (*common.MountInfo, error) {
+	mounts, err := common.ListFuseMounts()
+	if err != nil {
+		return nil, err
+	}
+	for i := range mounts {
+		if mounts[i].Target == target {
+			return &mounts[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// awsCredentials returns a shared credentials file of the AWS SDKs, and the matching environment.
+func awsCredentials(opts *MountOptions) (string, []string) {
+	file := fmt.Sprintf("[default]\naws_access_key_id = %s\naws_secret_access_key = %s\n", opts.AccessKey, opts.SecretKey)
+	return file, []string{"AWS_ACCESS_KEY_ID=" + opts.AccessKey, "AWS_SECRET_ACCESS_KEY=" + opts.SecretKey}
+}
@@ -0,0 +1,82 @@
+package s3minio
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+)
+
+func TestMounters(t *testing.T) {
+	convey.Convey("test mounters", t, func() {
+		m, err := GetMounter("")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(m.Name(), convey.ShouldEqual, MounterS3FS)
+		_, err = GetMounter("sshfs")
+		convey.So(err, convey.ShouldNotBeNil)
+
+		opts := &MountOptions{Endpoint: "http://10.0.0.1:9000", Region: "us-west-1", Bucket: "fuse-bucket", AccessKey: "test-access", SecretKey: "test-secret"}
+		for _, name := range []string{MounterS3FS, MounterGoofys, MounterGeesefs, MounterRclone, MounterMountpointS3, MounterBuiltin} {
+			m, err := GetMounter(name)
+			convey.So(err, convey.ShouldBeNil)
+
+			file, env := m.Credentials(opts)
+			convey.So(file, convey.ShouldContainSubstring, "test-secret")
+			convey.So(strings.Join(env, " "), convey.ShouldContainSubstring, "test-secret")
+
+			// credentials only reach the fuse client through the file or the environment
+			command, args, cmdEnv := m.Command(opts, "/mnt/target", "/run/open-object/credentials/abc")
+			cmdLine := strings.Join(append([]string{command}, args...), " ")
+			convey.So(cmdLine, convey.ShouldContainSubstring, "/mnt/target")
+			convey.So(cmdLine, convey.ShouldContainSubstring, "fuse-bucket")
+			convey.So(cmdLine+strings.Join(cmdEnv, " "), convey.ShouldContainSubstring, "/run/open-object/credentials/abc")
+			convey.So(cmdLine, convey.ShouldNotContainSubstring, "test-secret")
+			convey.So(cmdLine, convey.ShouldNotContainSubstring, "imagenet")
+
+			// static volumes may mount a prefix of the bucket
+			_, args, _ = m.Command(&MountOptions{Endpoint: opts.Endpoint, Bucket: opts.Bucket, Prefix: "imagenet/train"}, "/mnt/target", "")
+			convey.So(strings.Join(args, " "), convey.ShouldContainSubstring, "imagenet/train")
+		}
+
+		// readonly mounts refuse writes
+		readOnlyFlags := map[string][]string{
+			MounterS3FS:         {"-oro"},
+			MounterGoofys:       {"-o", "ro"},
+			MounterGeesefs:      {"-o", "ro"},
+			MounterRclone:       {"--read-only"},
+			MounterMountpointS3: {"--read-only"},
+			MounterBuiltin:      {"--read-only"},
+		}
+		for name, flags := range readOnlyFlags {
+			m, err := GetMounter(name)
+			convey.So(err, convey.ShouldBeNil)
+			_, args, _ := m.Command(opts, "/mnt/target", "")
+			convey.So(strings.Join(args, " "), convey.ShouldNotContainSubstring, strings.Join(flags, " "))
+			_, args, _ = m.Command(&MountOptions{Endpoint: opts.Endpoint, Bucket: opts.Bucket, ReadOnly: true}, "/mnt/target", "")
+			convey.So(strings.Join(args, " "), convey.ShouldContainSubstring, strings.Join(flags, " "))
+			convey.So(args, convey.ShouldNotContain, "--allow-delete")
+		}
+
+		// the builtin fuse client serves its metrics on the socket of the mount
+		m, _ = GetMounter(MounterBuiltin)
+		_, args, _ := m.Command(&MountOptions{Endpoint: opts.Endpoint, Bucket: opts.Bucket, MetricsSocket: "/run/open-object/metrics/abc.sock"}, "/mnt/target", "")
+		convey.So(strings.Join(args, " "), convey.ShouldContainSubstring, "--metrics-addr unix:/run/open-object/metrics/abc.sock")
+
+		// publishes with reader-only access modes are readonly
+		publish := func(mode csi.VolumeCapability_AccessMode_Mode, readonly bool) *csi.NodePublishVolumeRequest {
+			return &csi.NodePublishVolumeRequest{
+				VolumeCapability: &csi.VolumeCapability{AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode}},
+				Readonly:         readonly,
+			}
+		}
+		convey.So(isReadOnly(publish(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, false)), convey.ShouldBeFalse)
+		convey.So(isReadOnly(publish(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, true)), convey.ShouldBeTrue)
+		convey.So(isReadOnly(publish(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, false)), convey.ShouldBeTrue)
+		convey.So(isReadOnly(publish(csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY, false)), convey.ShouldBeTrue)
+
+		// s3fs reads SSE-C keys from a file
+		m, _ = GetMounter(MounterS3FS)
+		_, args, _ = m.Command(&MountOptions{Endpoint: opts.Endpoint, Bucket: opts.Bucket, SSECustomerKeyFile: "/run/open-object/credentials/abc.key"}, "/mnt/target", "")
+		convey.So(args, convey.ShouldContain, "-ouse_sse=custom:/run/open-object/credentials/abc.key")
+	})
+}
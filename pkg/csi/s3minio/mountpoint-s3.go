@@ -0,0 +1,47 @@
+package s3minio
+
+func init() {
+	registerMounter(&mountpointS3Mounter{fuseMounter{fsType: "fuse"}})
+}
+
+// mountpointS3Mounter mounts buckets with mountpoint-s3, which has a low memory footprint
+// but only supports sequential writes of new files.
+type mountpointS3Mounter struct {
+	fuseMounter
+}
+
+func (m *mountpointS3Mounter) Name() string {
+	return MounterMountpointS3
+}
+
+func (m *mountpointS3Mounter) Credentials(opts *MountOptions) (string, []string) {
+	return awsCredentials(opts)
+}
+
+func (m *mountpointS3Mounter) Command(opts *MountOptions, target, credentialFile string) (string, []string, []string) {
+	args := []string{
+		"--foreground",
+		"--endpoint-url", opts.Endpoint,
+		"--force-path-style",
+		"--allow-other",
+		"--file-mode", "0666",
+		"--dir-mode", "0777",
+	}
+	if opts.ReadOnly {
+		args = append(args, "--read-only")
+	} else {
+		// mount-s3 refuses deletes and overwrites unless asked, and both with --read-only
+		args = append(args, "--allow-delete", "--allow-overwrite")
+	}
+	if opts.Region != "" {
+		args = append(args, "--region", opts.Region)
+	}
+	if opts.Prefix != "" {
+		args = append(args, "--prefix", opts.Prefix+"/")
+	}
+	var env []string
+	if credentialFile != "" {
+		env = []string{"AWS_SHARED_CREDENTIALS_FILE=" + credentialFile}
+	}
+	return MountpointS3Cmd, append(args, opts.Bucket, target), env
+}
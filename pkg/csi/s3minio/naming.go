@@ -0,0 +1,62 @@
+package s3minio
+
+import (
+	"fmt"
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	"os"
+	"sort"
+	"strings"
+)
+
+// bucketNameVars are the variables of bucket name templates.
+type bucketNameVars struct {
+	PVCNamespace string
+	PVCName      string
+	PVName       string
+	ClusterID    string
+}
+
+// renderBucketName expands a bucket-name-template such as ${cluster.id}-${pvc.namespace}-${pvc.name},
+// and sanitizes the result into a valid bucket name. Unknown variables are an error, and so are templates whose
+// buckets could collide: they must name each volume, with ${pv.name} or ${pvc.namespace} and ${pvc.name}, and each
+// cluster sharing the object store, with ${pv.name} or a ${cluster.id} set to other than the default.
+func renderBucketName(template string, vars bucketNameVars) (string, error) {
+	values := map[string]string{
+		"pvc.namespace": vars.PVCNamespace,
+		"pvc.name":      vars.PVCName,
+		"pv.name":       vars.PVName,
+		"cluster.id":    vars.ClusterID,
+	}
+	var unknown []string
+	used := map[string]bool{}
+	rendered := os.Expand(template, func(name string) string {
+		value, ok := values[name]
+		if !ok {
+			unknown = append(unknown, "${"+name+"}")
+		}
+		used[name] = true
+		return value
+	})
+	if len(unknown) > 0 {
+		known := make([]string, 0, len(values))
+		for name := range values {
+			known = append(known, "${"+name+"}")
+		}
+		sort.Strings(known)
+		return "", fmt.Errorf("unknown variables %s in %s, expect %s", strings.Join(unknown, ", "), ParamBucketNameTemplate, strings.Join(known, ", "))
+	}
+	// pv names are unique across clusters
+	if !used["pv.name"] {
+		if !used["pvc.namespace"] || !used["pvc.name"] {
+			return "", fmt.Errorf("%s %q must use ${pv.name}, or ${pvc.namespace} and ${pvc.name}, so volumes never name the same bucket", ParamBucketNameTemplate, template)
+		}
+		if !used["cluster.id"] || vars.ClusterID == "" || vars.ClusterID == DefaultClusterID {
+			return "", fmt.Errorf("%s %q must use ${pv.name}, or ${cluster.id} with a --cluster-id other than %q, so clusters sharing the object store never name the same bucket",
+				ParamBucketNameTemplate, template, DefaultClusterID)
+		}
+	}
+	if strings.Trim(rendered, "-._ ") == "" {
+		return "", fmt.Errorf("%s %q renders an empty bucket name", ParamBucketNameTemplate, template)
+	}
+	return common.SanitizeBucketName(rendered), nil
+}
@@ -0,0 +1,47 @@
+package s3minio
+
+import (
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	"github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestRenderBucketName(t *testing.T) {
+	convey.Convey("test bucket name templates", t, func() {
+		vars := bucketNameVars{PVCNamespace: "team-a", PVCName: "data-web-0", PVName: "pvc-3bcc7b3d", ClusterID: "prod"}
+		name, err := renderBucketName("${cluster.id}-${pvc.namespace}-${pvc.name}", vars)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(name, convey.ShouldEqual, "prod-team-a-data-web-0")
+
+		// sanitized names stay valid and unique
+		vars.PVCName = "Data_Web.0"
+		name, err = renderBucketName("${pvc.namespace}-${pvc.name}-${pv.name}", vars)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(common.ValidateBucketName(name), convey.ShouldBeNil)
+		convey.So(name, convey.ShouldStartWith, "team-a-data-web-0-pvc-3bcc7b3d-")
+
+		_, err = renderBucketName("${pvc.namespace}-${pvc.uid}", vars)
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(err.Error(), convey.ShouldContainSubstring, "${pvc.uid}")
+		_, err = renderBucketName("${cluster.id}", bucketNameVars{})
+		convey.So(err, convey.ShouldNotBeNil)
+
+		// names of pvcs are the same in every cluster sharing the object store
+		_, err = renderBucketName("${pvc.namespace}-${pvc.name}", vars)
+		convey.So(err, convey.ShouldNotBeNil)
+		// and in every namespace of a cluster
+		_, err = renderBucketName("${cluster.id}-${pvc.name}", vars)
+		convey.So(err, convey.ShouldNotBeNil)
+		_, err = renderBucketName("${cluster.id}-${pvc.namespace}", vars)
+		convey.So(err, convey.ShouldNotBeNil)
+
+		// clusters left with the default or no id share it
+		for _, clusterID := range []string{DefaultClusterID, ""} {
+			vars.ClusterID = clusterID
+			_, err = renderBucketName("${cluster.id}-${pvc.namespace}-${pvc.name}", vars)
+			convey.So(err, convey.ShouldNotBeNil)
+			_, err = renderBucketName("${pvc.name}-${pv.name}", vars)
+			convey.So(err, convey.ShouldBeNil)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package s3minio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	"github.com/minio/minio-go/v7"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// OwnerIdentity identifies the driver deployment stamping the buckets it creates, set from the flags of the csi command.
+type OwnerIdentity struct {
+	Cluster string
+	Driver  string
+}
+
+// DefaultClusterID is the cluster id of deployments that did not set one, which clusters may share.
+const DefaultClusterID = "default"
+
+// DefaultOwnerIdentity is the identity of this driver deployment.
+var DefaultOwnerIdentity = OwnerIdentity{Cluster: DefaultClusterID, Driver: common.DefaultDriverName}
+
+// bucketOwner is the volume a bucket belongs to, recorded in the tags of the bucket.
+type bucketOwner struct {
+	OwnerIdentity
+	Volume string
+}
+
+func newBucketOwner(volumeID string) bucketOwner {
+	return bucketOwner{OwnerIdentity: DefaultOwnerIdentity, Volume: volumeID}
+}
+
+func (o bucketOwner) tags() map[string]string {
+	return map[string]string{
+		TagOwnerVolume:  o.Volume,
+		TagOwnerCluster: o.Cluster,
+		TagOwnerDriver:  o.Driver,
+	}
+}
+
+func (o bucketOwner) String() string {
+	return fmt.Sprintf("volume %s of driver %s in cluster %s", o.Volume, o.Driver, o.Cluster)
+}
+
+// ownerOf returns the owner recorded in bucket tags, false when the bucket has none.
+func ownerOf(bucketMap map[string]string) (bucketOwner, bool) {
+	owner := bucketOwner{
+		OwnerIdentity: OwnerIdentity{Cluster: bucketMap[TagOwnerCluster], Driver: bucketMap[TagOwnerDriver]},
+		Volume:        bucketMap[TagOwnerVolume],
+	}
+	return owner, owner.Volume != ""
+}
+
+// ownershipError is returned when a bucket does not belong to the volume operating on it.
+type ownershipError struct {
+	bucket string
+	reason string
+}
+
+func (e *ownershipError) Error() string {
+	return fmt.Sprintf("bucket %s %s", e.bucket, e.reason)
+}
+
+// checkOwner returns an ownershipError unless the tags of bucketName name owner.
+func checkOwner(bucketName string, bucketMap map[string]string, owner bucketOwner) error {
+	current, owned := ownerOf(bucketMap)
+	if !owned {
+		return &ownershipError{bucket: bucketName, reason: "is not owned by the driver"}
+	}
+	if current != owner {
+		return &ownershipError{bucket: bucketName, reason: "is owned by " + current.String()}
+	}
+	return nil
+}
+
+// VerifyBucketOwner refuses buckets that were not created, or explicitly adopted, for owner.
+// A bucket that no longer exists has nothing left to protect.
+func (driver *MinIOClient) VerifyBucketOwner(bucketName string, owner bucketOwner) error {
+	exists, err := driver.mclient.BucketExists(context.Background(), bucketName)
+	if err != nil || !exists {
+		return err
+	}
+	bucketMap, err := driver.bucketTags(bucketName)
+	if err != nil {
+		return err
+	}
+	return checkOwner(bucketName, bucketMap, owner)
+}
+
+// bucketTags returns the tags of bucketName, empty when it has none.
+func (driver *MinIOClient) bucketTags(bucketName string) (map[string]string, error) {
+	bucketTags, err := driver.mclient.GetBucketTagging(context.Background(), bucketName)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code != errNoSuchTagSet {
+			return nil, fmt.Errorf("fail to get minio bucket tags: %s", err.Error())
+		}
+		return map[string]string{}, nil
+	}
+	return bucketTags.ToMap(), nil
+}
+
+// ownershipStatus maps ownership errors to FailedPrecondition, and other errors to Internal.
+func ownershipStatus(err error) error {
+	var ownErr *ownershipError
+	if errors.As(err, &ownErr) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,51 @@
+package s3minio
+
+import (
+	"context"
+	"github.com/minio/minio-go/v7"
+	"github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestBucketOwnership(t *testing.T) {
+	convey.Convey("test bucket ownership against a stand-in server", t, func() {
+		fake := newFakeS3()
+		defer fake.Close()
+		ctx := context.Background()
+
+		c, err := NewS3Client(fake.config())
+		convey.So(err, convey.ShouldBeNil)
+
+		owner := newBucketOwner("pv-1")
+		convey.So(c.CreateBucket("owned", 1024, owner, false, bucketSettings{}), convey.ShouldBeNil)
+		tags, err := c.GetBucketMetadata("owned")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(tags[TagOwnerVolume], convey.ShouldEqual, "pv-1")
+		convey.So(tags[TagOwnerCluster], convey.ShouldEqual, DefaultOwnerIdentity.Cluster)
+
+		// a retried CreateVolume finds its own bucket
+		convey.So(c.CreateBucket("owned", 1024, owner, false, bucketSettings{}), convey.ShouldBeNil)
+		convey.So(c.VerifyBucketOwner("owned", owner), convey.ShouldBeNil)
+
+		// another volume can neither take nor modify it, even with adoption
+		err = c.CreateBucket("owned", 1024, newBucketOwner("pv-2"), true, bucketSettings{})
+		convey.So(err, convey.ShouldHaveSameTypeAs, &ownershipError{})
+		convey.So(c.VerifyBucketOwner("owned", newBucketOwner("pv-2")), convey.ShouldHaveSameTypeAs, &ownershipError{})
+		other := bucketOwner{OwnerIdentity: OwnerIdentity{Cluster: "other", Driver: owner.Driver}, Volume: "pv-1"}
+		convey.So(c.VerifyBucketOwner("owned", other), convey.ShouldHaveSameTypeAs, &ownershipError{})
+
+		// foreign buckets are only adopted on request, and keep their tags
+		convey.So(c.mclient.MakeBucket(ctx, "foreign", minio.MakeBucketOptions{}), convey.ShouldBeNil)
+		convey.So(c.SetBucketMetadata("foreign", map[string]string{"team": "data"}), convey.ShouldBeNil)
+		convey.So(c.VerifyBucketOwner("foreign", owner), convey.ShouldHaveSameTypeAs, &ownershipError{})
+		convey.So(c.CreateBucket("foreign", 1024, owner, false, bucketSettings{}), convey.ShouldHaveSameTypeAs, &ownershipError{})
+		convey.So(c.CreateBucket("foreign", 1024, owner, true, bucketSettings{}), convey.ShouldBeNil)
+		tags, err = c.GetBucketMetadata("foreign")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(tags["team"], convey.ShouldEqual, "data")
+		convey.So(tags[TagOwnerVolume], convey.ShouldEqual, "pv-1")
+
+		// nothing is left to protect once the bucket is gone
+		convey.So(c.VerifyBucketOwner("missing", owner), convey.ShouldBeNil)
+	})
+}
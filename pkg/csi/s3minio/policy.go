@@ -0,0 +1,121 @@
+package s3minio
+
+import (
+	"context"
+	"fmt"
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"path"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"sync"
+)
+
+const (
+	// BucketPolicyConfigMap is the ConfigMap, in the namespace of the driver, holding the bucket name policy
+	BucketPolicyConfigMap = "open-object-bucket-policy"
+	// BucketPolicyKey is the key of the policy in the data of the ConfigMap
+	BucketPolicyKey = "policy.yaml"
+
+	// EventReasonBucketNameDenied is the reason of the PVC events of rejected bucket names
+	EventReasonBucketNameDenied = "BucketNameDenied"
+)
+
+// BucketNamePolicy decides which namespaces may name their buckets with AnnoBucketName.
+type BucketNamePolicy struct {
+	// Default applies to the namespaces no rule matches
+	Default BucketNameRule `json:"default"`
+	// Namespaces are matched in order, the first rule whose namespace matches applies
+	Namespaces []BucketNameRule `json:"namespaces,omitempty"`
+}
+
+// BucketNameRule are the bucket names allowed in the namespaces matching Namespace.
+type BucketNameRule struct {
+	// Namespace is a glob, e.g. team-a or ci-*
+	Namespace        string `json:"namespace,omitempty"`
+	AllowCustomNames bool   `json:"allowCustomNames"`
+	// Patterns are globs of allowed bucket names, e.g. team-a-*
+	Patterns []string `json:"patterns,omitempty"`
+	// Prefixes are prefixes of allowed bucket names
+	Prefixes []string `json:"prefixes,omitempty"`
+}
+
+// parseBucketNamePolicy parses the policy in the ConfigMap, and rejects patterns that are not valid globs.
+func parseBucketNamePolicy(content string) (*BucketNamePolicy, error) {
+	policy := &BucketNamePolicy{}
+	if err := yaml.UnmarshalStrict([]byte(content), policy); err != nil {
+		return nil, fmt.Errorf("fail to parse bucket name policy: %s", err.Error())
+	}
+	for _, rule := range append([]BucketNameRule{policy.Default}, policy.Namespaces...) {
+		for _, pattern := range append([]string{rule.Namespace}, rule.Patterns...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q in bucket name policy: %s", pattern, err.Error())
+			}
+		}
+	}
+	return policy, nil
+}
+
+// Check returns nil when PVCs of namespace may use bucketName, and otherwise the rule that was hit.
+func (p *BucketNamePolicy) Check(namespace, bucketName string) error {
+	rule, which := p.Default, "the default rule"
+	for _, r := range p.Namespaces {
+		if ok, _ := path.Match(r.Namespace, namespace); ok {
+			rule, which = r, fmt.Sprintf("the rule of namespaces %q", r.Namespace)
+			break
+		}
+	}
+	if !rule.AllowCustomNames {
+		return fmt.Errorf("custom bucket names are not allowed in namespace %s by %s of %s", namespace, which, BucketPolicyConfigMap)
+	}
+	if len(rule.Patterns) == 0 && len(rule.Prefixes) == 0 {
+		return nil
+	}
+	for _, pattern := range rule.Patterns {
+		if ok, _ := path.Match(pattern, bucketName); ok {
+			return nil
+		}
+	}
+	for _, prefix := range rule.Prefixes {
+		if strings.HasPrefix(bucketName, prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("bucket name %s is not allowed in namespace %s by %s of %s, expect patterns %v or prefixes %v",
+		bucketName, namespace, which, BucketPolicyConfigMap, rule.Patterns, rule.Prefixes)
+}
+
+// loadBucketNamePolicy reads the policy from its ConfigMap, nil when there is none and every name is allowed.
+func loadBucketNamePolicy(ctx context.Context, kubeClient kubernetes.Interface) (*BucketNamePolicy, error) {
+	cm, err := kubeClient.CoreV1().ConfigMaps(common.DriverNamespace()).Get(ctx, BucketPolicyConfigMap, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fail to get bucket name policy: %s", err.Error())
+	}
+	return parseBucketNamePolicy(cm.Data[BucketPolicyKey])
+}
+
+var (
+	recorderOnce sync.Once
+	recorder     record.EventRecorder
+)
+
+// eventRecorder returns the recorder of the events the driver emits on PVCs, started with the first client.
+func eventRecorder(kubeClient kubernetes.Interface) record.EventRecorder {
+	recorderOnce.Do(func() {
+		broadcaster := record.NewBroadcaster()
+		broadcaster.StartLogging(klog.Infof)
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+		recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: DefaultOwnerIdentity.Driver})
+	})
+	return recorder
+}
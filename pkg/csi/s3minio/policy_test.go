@@ -0,0 +1,60 @@
+package s3minio
+
+import (
+	"context"
+	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	"github.com/smartystreets/goconvey/convey"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+const testBucketPolicy = `
+default:
+  allowCustomNames: false
+namespaces:
+  - namespace: team-a
+    allowCustomNames: true
+    patterns: ["team-a-*"]
+    prefixes: ["shared-datasets-"]
+  - namespace: ci-*
+    allowCustomNames: true
+`
+
+func TestBucketNamePolicy(t *testing.T) {
+	convey.Convey("test bucket name policy", t, func() {
+		policy, err := parseBucketNamePolicy(testBucketPolicy)
+		convey.So(err, convey.ShouldBeNil)
+
+		convey.So(policy.Check("team-a", "team-a-logs"), convey.ShouldBeNil)
+		convey.So(policy.Check("team-a", "shared-datasets-imagenet"), convey.ShouldBeNil)
+		convey.So(policy.Check("team-a", "team-b-logs"), convey.ShouldNotBeNil)
+		convey.So(policy.Check("ci-42", "anything"), convey.ShouldBeNil)
+
+		err = policy.Check("team-b", "team-a-logs")
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(err.Error(), convey.ShouldContainSubstring, "default rule")
+
+		_, err = parseBucketNamePolicy("namespaces:\n  - namespace: team-[a\n    allowCustomNames: true\n")
+		convey.So(err, convey.ShouldNotBeNil)
+		_, err = parseBucketNamePolicy("default:\n  allowCustomName: true\n")
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+
+	convey.Convey("test loading the bucket name policy", t, func() {
+		client := fake.NewSimpleClientset()
+		policy, err := loadBucketNamePolicy(context.Background(), client)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(policy, convey.ShouldBeNil)
+
+		_, err = client.CoreV1().ConfigMaps(common.DriverNamespace()).Create(context.Background(), &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: BucketPolicyConfigMap, Namespace: common.DriverNamespace()},
+			Data:       map[string]string{BucketPolicyKey: testBucketPolicy},
+		}, metav1.CreateOptions{})
+		convey.So(err, convey.ShouldBeNil)
+		policy, err = loadBucketNamePolicy(context.Background(), client)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(policy.Namespaces, convey.ShouldHaveLength, 2)
+	})
+}
@@ -0,0 +1,147 @@
+package s3minio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// EventReasonQuotaThreshold is the reason of the PVC events of volumes whose usage reached a soft quota threshold
+	EventReasonQuotaThreshold = "QuotaThresholdReached"
+	// EventReasonQuotaRecovered is the reason of the PVC events of volumes whose usage went back below the thresholds
+	EventReasonQuotaRecovered = "QuotaRecovered"
+)
+
+// defaultQuotaThresholds are the percentages of the capacity soft quotas report usage at.
+var defaultQuotaThresholds = []int{80, 100}
+
+// quotaPolicy is how the capacity of the volumes of a StorageClass is enforced.
+type quotaPolicy struct {
+	// Type is hard, enforced by the object store, or soft, only reported. Empty enforces a hard quota where the
+	// backend can, as older versions did.
+	Type string
+	// Thresholds are the ascending percentages of the capacity a soft quota emits events at
+	Thresholds []int
+}
+
+// newQuotaPolicy parses the quota parameters of a StorageClass.
+func newQuotaPolicy(params map[string]string) (quotaPolicy, error) {
+	policy := quotaPolicy{Type: strings.ToLower(params[ParamQuotaType])}
+	switch policy.Type {
+	case "", QuotaTypeHard:
+	case QuotaTypeSoft:
+		policy.Thresholds = defaultQuotaThresholds
+	case QuotaTypeFIFO:
+		// MinIO dropped FIFO quotas, which deleted the oldest objects to make room for new ones
+		return quotaPolicy{}, fmt.Errorf("%s %s is not supported by the object store, expect %s or %s", ParamQuotaType, QuotaTypeFIFO, QuotaTypeHard, QuotaTypeSoft)
+	default:
+		return quotaPolicy{}, fmt.Errorf("unknown %s %q, expect %s or %s", ParamQuotaType, params[ParamQuotaType], QuotaTypeHard, QuotaTypeSoft)
+	}
+	value := params[ParamQuotaThresholds]
+	if value == "" {
+		return policy, nil
+	}
+	if policy.Type != QuotaTypeSoft {
+		return quotaPolicy{}, fmt.Errorf("%s requires %s %s", ParamQuotaThresholds, ParamQuotaType, QuotaTypeSoft)
+	}
+	policy.Thresholds = nil
+	for _, field := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n <= 0 {
+			return quotaPolicy{}, fmt.Errorf("invalid %s %q, expect positive percentages of the capacity, e.g. 80,100", ParamQuotaThresholds, value)
+		}
+		policy.Thresholds = append(policy.Thresholds, n)
+	}
+	sort.Ints(policy.Thresholds)
+	return policy, nil
+}
+
+// hard reports whether the object store enforces the capacity of the bucket.
+func (q quotaPolicy) hard() bool {
+	return q.Type == "" || q.Type == QuotaTypeHard
+}
+
+// checkQuotaSupported refuses a hard quota the backend cannot enforce on volumes of provisionType.
+// The default quota type keeps degrading silently, as it did before quota types were honored.
+func (driver *MinIODriver) checkQuotaSupported(q quotaPolicy, provisionType ProvisionType) error {
+	if q.Type != QuotaTypeHard {
+		return nil
+	}
+	switch {
+	case provisionType == ProvisionTypePrefix:
+		return fmt.Errorf("%s %s is not supported by provision type %s, the shared bucket has no quota per prefix", ParamQuotaType, QuotaTypeHard, ProvisionTypePrefix)
+	case !driver.minioClient.HasAdmin():
+		return fmt.Errorf("%s %s is not supported by storage driver %s, which has no MinIO admin api", ParamQuotaType, QuotaTypeHard, driver.name)
+	case !DefaultFeatureGate.Enabled(Quota):
+		return fmt.Errorf("%s %s is not supported while the %s feature gate is disabled", ParamQuotaType, QuotaTypeHard, Quota)
+	}
+	return nil
+}
+
+// thresholdReached returns the highest threshold usage reached, 0 when it is below all of them.
+func (q quotaPolicy) thresholdReached(usage, capacity int64) int {
+	reached := 0
+	for _, threshold := range q.Thresholds {
+		if usage*100 >= capacity*int64(threshold) {
+			reached = threshold
+		}
+	}
+	return reached
+}
+
+// reconcileSoftQuota emits an event on pvc when the usage of its volume reaches a higher threshold of its soft quota,
+// or goes back below all of them. The last threshold reached is recorded on the PVC, so every threshold is reported
+// once, whichever plugin leads.
+func (driver *MinIODriver) reconcileSoftQuota(ctx context.Context, pv *corev1.PersistentVolume, pvc *corev1.PersistentVolumeClaim, bucketName, prefix string) error {
+	q, err := newQuotaPolicy(pv.Spec.CSI.VolumeAttributes)
+	if err != nil || q.Type != QuotaTypeSoft {
+		return err
+	}
+	capacity, ok := pv.Spec.Capacity[corev1.ResourceStorage]
+	if !ok || capacity.Value() <= 0 {
+		return nil
+	}
+	// the reconciler runs less often than the usage cache goes stale
+	accounted, err := driver.minioClient.bucketUsage(ctx, bucketName, prefix)
+	if err != nil {
+		return err
+	}
+	usage := accounted.Bytes
+	reached := q.thresholdReached(usage, capacity.Value())
+	previous, _ := strconv.Atoi(pvc.GetAnnotations()[AnnoQuotaThreshold])
+	if reached == previous {
+		return nil
+	}
+	used := resource.NewQuantity(usage, resource.BinarySI)
+	switch {
+	case reached > previous:
+		eventRecorder(driver.kubeClient).Eventf(pvc, corev1.EventTypeWarning, EventReasonQuotaThreshold,
+			"volume %s uses %s, %d%% of its capacity %s, reaching the soft quota threshold of %d%%", pv.Name, used, usage*100/capacity.Value(), capacity.String(), reached)
+	case reached == 0:
+		eventRecorder(driver.kubeClient).Eventf(pvc, corev1.EventTypeNormal, EventReasonQuotaRecovered,
+			"volume %s uses %s, below the soft quota thresholds of its capacity %s", pv.Name, used, capacity.String())
+	}
+	klog.Infof("s3: soft quota threshold of volume %s changes from %d%% to %d%%", pv.Name, previous, reached)
+
+	value := interface{}(nil)
+	if reached > 0 {
+		value = strconv.Itoa(reached)
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]interface{}{AnnoQuotaThreshold: value}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = driver.kubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Patch(ctx, pvc.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
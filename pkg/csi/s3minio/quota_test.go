@@ -0,0 +1,97 @@
+package s3minio
+
+import (
+	"bytes"
+	"context"
+	"github.com/minio/minio-go/v7"
+	"github.com/smartystreets/goconvey/convey"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+func TestQuotaPolicy(t *testing.T) {
+	convey.Convey("test quota parameters", t, func() {
+		q, err := newQuotaPolicy(map[string]string{})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(q.hard(), convey.ShouldBeTrue)
+
+		q, err = newQuotaPolicy(map[string]string{ParamQuotaType: "soft"})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(q.hard(), convey.ShouldBeFalse)
+		convey.So(q.Thresholds, convey.ShouldResemble, defaultQuotaThresholds)
+
+		q, err = newQuotaPolicy(map[string]string{ParamQuotaType: "soft", ParamQuotaThresholds: "100, 75,90"})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(q.Thresholds, convey.ShouldResemble, []int{75, 90, 100})
+		convey.So(q.thresholdReached(70, 100), convey.ShouldEqual, 0)
+		convey.So(q.thresholdReached(95, 100), convey.ShouldEqual, 90)
+		convey.So(q.thresholdReached(120, 100), convey.ShouldEqual, 100)
+
+		_, err = newQuotaPolicy(map[string]string{ParamQuotaType: QuotaTypeFIFO})
+		convey.So(err, convey.ShouldNotBeNil)
+		_, err = newQuotaPolicy(map[string]string{ParamQuotaType: "hard", ParamQuotaThresholds: "80"})
+		convey.So(err, convey.ShouldNotBeNil)
+		_, err = newQuotaPolicy(map[string]string{ParamQuotaType: "soft", ParamQuotaThresholds: "80%"})
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
+
+func TestSoftQuota(t *testing.T) {
+	convey.Convey("test soft quotas against a stand-in server", t, func() {
+		fake3 := newFakeS3()
+		defer fake3.Close()
+		ctx := context.Background()
+
+		c, err := NewS3Client(fake3.config())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c.mclient.MakeBucket(ctx, "soft", minio.MakeBucketOptions{}), convey.ShouldBeNil)
+		pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "data"}}
+		driver := &MinIODriver{name: S3DriverName, minioClient: c, kubeClient: fake.NewSimpleClientset(pvc)}
+
+		// a generic S3 service cannot enforce hard quotas
+		hard, _ := newQuotaPolicy(map[string]string{ParamQuotaType: QuotaTypeHard})
+		convey.So(driver.checkQuotaSupported(hard, ProvisionTypeBucketOrCreate), convey.ShouldNotBeNil)
+		convey.So(driver.checkQuotaSupported(quotaPolicy{}, ProvisionTypeBucketOrCreate), convey.ShouldBeNil)
+
+		pv := &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-soft"},
+			Spec: corev1.PersistentVolumeSpec{
+				Capacity: corev1.ResourceList{corev1.ResourceStorage: *resource.NewQuantity(100, resource.BinarySI)},
+				PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+					VolumeHandle:     "pv-soft",
+					VolumeAttributes: map[string]string{ParamQuotaType: QuotaTypeSoft},
+				}},
+			},
+		}
+		threshold := func() string {
+			pvc, err := driver.kubeClient.CoreV1().PersistentVolumeClaims("default").Get(ctx, "data", metav1.GetOptions{})
+			convey.So(err, convey.ShouldBeNil)
+			return pvc.Annotations[AnnoQuotaThreshold]
+		}
+		put := func(key string, size int) {
+			data := bytes.Repeat([]byte("x"), size)
+			_, err := c.mclient.PutObject(ctx, "soft", key, bytes.NewReader(data), int64(size), minio.PutObjectOptions{})
+			convey.So(err, convey.ShouldBeNil)
+		}
+
+		put("a", 50)
+		convey.So(driver.reconcileSoftQuota(ctx, pv, pvc, "soft", ""), convey.ShouldBeNil)
+		convey.So(threshold(), convey.ShouldEqual, "")
+
+		// writes are never blocked, the thresholds are reported
+		put("b", 60)
+		convey.So(driver.reconcileSoftQuota(ctx, pv, pvc, "soft", ""), convey.ShouldBeNil)
+		convey.So(threshold(), convey.ShouldEqual, "100")
+
+		convey.Convey("the threshold is cleared when usage goes down", func() {
+			pvc, err := driver.kubeClient.CoreV1().PersistentVolumeClaims("default").Get(ctx, "data", metav1.GetOptions{})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(c.mclient.RemoveObject(ctx, "soft", "b", minio.RemoveObjectOptions{}), convey.ShouldBeNil)
+			convey.So(driver.reconcileSoftQuota(ctx, pv, pvc, "soft", ""), convey.ShouldBeNil)
+			convey.So(threshold(), convey.ShouldEqual, "")
+		})
+	})
+}
@@ -0,0 +1,56 @@
+package s3minio
+
+import (
+	"fmt"
+	"path"
+)
+
+func init() {
+	registerMounter(&rcloneMounter{fuseMounter{fsType: "fuse.rclone"}})
+}
+
+// rcloneRemote is the name of the remote defined in the credential file of rclone.
+const rcloneRemote = "s3"
+
+// rcloneMounter mounts buckets with rclone, whose vfs cache supports random writes.
+type rcloneMounter struct {
+	fuseMounter
+}
+
+func (m *rcloneMounter) Name() string {
+	return MounterRclone
+}
+
+func (m *rcloneMounter) Credentials(opts *MountOptions) (string, []string) {
+	file := fmt.Sprintf("[%s]\ntype = s3\nprovider = Other\naccess_key_id = %s\nsecret_access_key = %s\n", rcloneRemote, opts.AccessKey, opts.SecretKey)
+	return file, []string{
+		"RCLONE_CONFIG_S3_TYPE=s3",
+		"RCLONE_CONFIG_S3_PROVIDER=Other",
+		"RCLONE_CONFIG_S3_ACCESS_KEY_ID=" + opts.AccessKey,
+		"RCLONE_CONFIG_S3_SECRET_ACCESS_KEY=" + opts.SecretKey,
+	}
+}
+
+func (m *rcloneMounter) Command(opts *MountOptions, target, credentialFile string) (string, []string, []string) {
+	// rclone mount stays in the foreground unless --daemon is set
+	args := []string{
+		"mount",
+		fmt.Sprintf("%s:%s", rcloneRemote, path.Join(opts.Bucket, opts.Prefix)),
+		target,
+		"--s3-endpoint", opts.Endpoint,
+		"--allow-other",
+		"--vfs-cache-mode", "writes",
+		"--dir-perms", "0777",
+		"--file-perms", "0666",
+	}
+	if opts.Region != "" {
+		args = append(args, "--s3-region", opts.Region)
+	}
+	if credentialFile != "" {
+		args = append(args, "--config", credentialFile)
+	}
+	if opts.ReadOnly {
+		args = append(args, "--read-only")
+	}
+	return MounterRclone, args, nil
+}
@@ -0,0 +1,198 @@
+package s3minio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	"k8s.io/klog/v2"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReclaimMode is what DeleteVolume does with the data of a volume.
+type ReclaimMode string
+
+const (
+	// ReclaimDelete removes the data of the volume for good
+	ReclaimDelete ReclaimMode = "delete"
+	// ReclaimRetain leaves the data in place, and releases the bucket from the driver
+	ReclaimRetain ReclaimMode = "retain"
+	// ReclaimArchive moves the data to an archive bucket, where a lifecycle rule expires it
+	ReclaimArchive ReclaimMode = "archive"
+
+	defaultArchivePrefix     = "archive"
+	defaultArchiveExpiryDays = 30
+
+	// reclaimRecordName is the object recording the reclaim of a volume, next to the data it kept
+	reclaimRecordName = ".open-object-reclaim.json"
+	// archiveRulePrefix starts the ids of the lifecycle rules expiring archives, one per archive prefix
+	archiveRulePrefix = "open-object-archive-"
+
+	errNoSuchTagSet   = "NoSuchTagSet"
+	errNoSuchLifecyle = "NoSuchLifecycleConfiguration"
+)
+
+// reclaimPolicy is the reclaim mode of a volume, and the archive of the archive mode.
+type reclaimPolicy struct {
+	Mode          ReclaimMode
+	ArchiveBucket string
+	ArchivePrefix string
+	ExpiryDays    int
+}
+
+// newReclaimPolicy parses the reclaim parameters of a StorageClass, which volumes keep in their attributes.
+func newReclaimPolicy(attrs map[string]string) (*reclaimPolicy, error) {
+	policy := &reclaimPolicy{
+		Mode:          ReclaimMode(strings.ToLower(attrs[ParamReclaimMode])),
+		ArchiveBucket: attrs[ParamArchiveBucket],
+		ArchivePrefix: strings.Trim(attrs[ParamArchivePrefix], "/"),
+		ExpiryDays:    defaultArchiveExpiryDays,
+	}
+	switch policy.Mode {
+	case "":
+		policy.Mode = ReclaimDelete
+	case ReclaimDelete, ReclaimRetain:
+	case ReclaimArchive:
+		if policy.ArchiveBucket == "" {
+			return nil, fmt.Errorf("%s is required by reclaim mode %s", ParamArchiveBucket, ReclaimArchive)
+		}
+		if policy.ArchivePrefix == "" {
+			policy.ArchivePrefix = defaultArchivePrefix
+		}
+		if days := attrs[ParamArchiveExpiryDays]; days != "" {
+			n, err := strconv.Atoi(days)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s %q, expect a number of days, 0 keeps archives forever", ParamArchiveExpiryDays, days)
+			}
+			policy.ExpiryDays = n
+		}
+	default:
+		return nil, fmt.Errorf("unknown %s %q, expect %s, %s or %s", ParamReclaimMode, policy.Mode, ReclaimDelete, ReclaimRetain, ReclaimArchive)
+	}
+	return policy, nil
+}
+
+// archivePrefix returns where the objects of a volume are archived. It only depends on the volume,
+// so a retried DeleteVolume overwrites the objects it already copied.
+func (p *reclaimPolicy) archivePrefix(volumeID string) string {
+	return path.Join(p.ArchivePrefix, volumeID)
+}
+
+// reclaimRecord records the reclaim of a volume, in the object reclaimRecordName next to the data it kept.
+type reclaimRecord struct {
+	Mode        ReclaimMode `json:"mode"`
+	VolumeID    string      `json:"volumeId"`
+	Source      string      `json:"source"`
+	ReclaimedAt time.Time   `json:"reclaimedAt"`
+}
+
+func newReclaimRecord(mode ReclaimMode, volumeID, source string) *reclaimRecord {
+	return &reclaimRecord{Mode: mode, VolumeID: volumeID, Source: source, ReclaimedAt: time.Now().UTC().Truncate(time.Second)}
+}
+
+// RecordReclaim writes record under prefix of bucketName, where the data of the volume is kept. Every volume
+// reclaimed into a shared bucket keeps its own record, archived ones expire with their data.
+func (driver *MinIOClient) RecordReclaim(bucketName, prefix string, record *reclaimRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	key := path.Join(prefix, reclaimRecordName)
+	if _, err := driver.mclient.PutObject(context.Background(), bucketName, key, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/json"}); err != nil {
+		return fmt.Errorf("failed to write reclaim record %s/%s: %s", bucketName, key, err.Error())
+	}
+	return nil
+}
+
+// GetReclaimRecord returns the record of the volume reclaimed under prefix of bucketName, nil when there is none.
+func (driver *MinIOClient) GetReclaimRecord(bucketName, prefix string) (*reclaimRecord, error) {
+	key := path.Join(prefix, reclaimRecordName)
+	object, err := driver.mclient.GetObject(context.Background(), bucketName, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reclaim record %s/%s: %s", bucketName, key, err.Error())
+	}
+	defer object.Close()
+	record := &reclaimRecord{}
+	if err := json.NewDecoder(object).Decode(record); err != nil {
+		switch minio.ToErrorResponse(err).Code {
+		case errNoSuchKey, errNoSuchBucket:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read reclaim record %s/%s: %s", bucketName, key, err.Error())
+	}
+	return record, nil
+}
+
+// ReleaseBucket removes the tags of keys from bucketName, e.g. the owner of a retained bucket.
+func (driver *MinIOClient) ReleaseBucket(bucketName string, keys ...string) error {
+	bucketMap, err := driver.bucketTags(bucketName)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		delete(bucketMap, key)
+	}
+	return driver.SetBucketMetadata(bucketName, bucketMap)
+}
+
+// ArchiveObjects copies the objects under srcPrefix to dstPrefix in dstBucket, where the objects under archivePrefix,
+// a parent of dstPrefix, expire after expiryDays. The archive bucket is created when it does not exist. The source is
+// left for the caller to remove.
+func (driver *MinIOClient) ArchiveObjects(srcBucket, srcPrefix, dstBucket, dstPrefix, archivePrefix string, expiryDays int) error {
+	if err := driver.ensureBucket(dstBucket); err != nil {
+		return err
+	}
+	if expiryDays > 0 {
+		if err := driver.setArchiveExpiry(dstBucket, archivePrefix, expiryDays); err != nil {
+			return err
+		}
+	}
+
+	count, _, err := driver.copyObjects(srcBucket, srcPrefix, dstBucket, dstPrefix)
+	if err != nil {
+		return err
+	}
+	klog.Infof("archived %d objects of %s to %s", count, path.Join(srcBucket, srcPrefix), path.Join(dstBucket, dstPrefix))
+	return nil
+}
+
+// setArchiveExpiry sets the lifecycle rule expiring the objects under the archive prefix, keeping the other rules of
+// the bucket. The StorageClasses sharing an archive prefix share its rule, the last archive sets its expiry. The rules
+// per volume of earlier versions are replaced by the rule of their prefix.
+func (driver *MinIOClient) setArchiveExpiry(bucketName, prefix string, expiryDays int) error {
+	ctx := context.Background()
+	config, err := driver.mclient.GetBucketLifecycle(ctx, bucketName)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code != errNoSuchLifecyle {
+			return fmt.Errorf("fail to get lifecycle of bucket %s: %s", bucketName, err.Error())
+		}
+		config = lifecycle.NewConfiguration()
+	}
+	ruleID := archiveRulePrefix + strings.ReplaceAll(prefix, "/", "-")
+	rules := config.Rules[:0]
+	for _, rule := range config.Rules {
+		switch {
+		case rule.ID == ruleID && rule.RuleFilter.Prefix == objectPrefix(prefix) && int(rule.Expiration.Days) == expiryDays:
+			return nil
+		case rule.ID == ruleID, strings.HasPrefix(rule.ID, ruleID+"-") && strings.HasPrefix(rule.RuleFilter.Prefix, objectPrefix(prefix)):
+		default:
+			rules = append(rules, rule)
+		}
+	}
+	config.Rules = append(rules, lifecycle.Rule{
+		ID:         ruleID,
+		Status:     "Enabled",
+		RuleFilter: lifecycle.Filter{Prefix: objectPrefix(prefix)},
+		Expiration: lifecycle.Expiration{Days: lifecycle.ExpirationDays(expiryDays)},
+	})
+	if err := driver.mclient.SetBucketLifecycle(ctx, bucketName, config); err != nil {
+		return fmt.Errorf("fail to set lifecycle of bucket %s: %s", bucketName, err.Error())
+	}
+	return nil
+}
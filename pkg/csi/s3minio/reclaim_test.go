@@ -0,0 +1,88 @@
+package s3minio
+
+import (
+	"bytes"
+	"context"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	"github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestReclaimPolicy(t *testing.T) {
+	convey.Convey("test reclaim policy", t, func() {
+		policy, err := newReclaimPolicy(map[string]string{})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(policy.Mode, convey.ShouldEqual, ReclaimDelete)
+
+		policy, err = newReclaimPolicy(map[string]string{ParamReclaimMode: "Retain"})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(policy.Mode, convey.ShouldEqual, ReclaimRetain)
+
+		_, err = newReclaimPolicy(map[string]string{ParamReclaimMode: "recycle"})
+		convey.So(err, convey.ShouldNotBeNil)
+		_, err = newReclaimPolicy(map[string]string{ParamReclaimMode: "archive"})
+		convey.So(err, convey.ShouldNotBeNil)
+		_, err = newReclaimPolicy(map[string]string{ParamReclaimMode: "archive", ParamArchiveBucket: "archive", ParamArchiveExpiryDays: "a week"})
+		convey.So(err, convey.ShouldNotBeNil)
+
+		policy, err = newReclaimPolicy(map[string]string{ParamReclaimMode: "archive", ParamArchiveBucket: "archive"})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(policy.ExpiryDays, convey.ShouldEqual, defaultArchiveExpiryDays)
+		convey.So(policy.archivePrefix("pvc-1"), convey.ShouldEqual, "archive/pvc-1")
+
+		policy, err = newReclaimPolicy(map[string]string{ParamReclaimMode: "archive", ParamArchiveBucket: "archive", ParamArchivePrefix: "/deleted/", ParamArchiveExpiryDays: "7"})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(policy.ExpiryDays, convey.ShouldEqual, 7)
+		convey.So(policy.archivePrefix("pvc-1"), convey.ShouldEqual, "deleted/pvc-1")
+	})
+}
+
+func TestArchiveReclaim(t *testing.T) {
+	convey.Convey("test archives sharing a bucket against a stand-in server", t, func() {
+		fake3 := newFakeS3()
+		defer fake3.Close()
+		ctx := context.Background()
+
+		c, err := NewS3Client(fake3.config())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c.mclient.MakeBucket(ctx, "shared", minio.MakeBucketOptions{}), convey.ShouldBeNil)
+		// a rule per volume of earlier versions
+		legacy := lifecycle.NewConfiguration()
+		legacy.Rules = []lifecycle.Rule{{ID: archiveRulePrefix + "archive-pvc-0", Status: "Enabled",
+			RuleFilter: lifecycle.Filter{Prefix: "archive/pvc-0/"}, Expiration: lifecycle.Expiration{Days: 30}}}
+		convey.So(c.ensureBucket("archive"), convey.ShouldBeNil)
+		convey.So(c.mclient.SetBucketLifecycle(ctx, "archive", legacy), convey.ShouldBeNil)
+
+		policy, err := newReclaimPolicy(map[string]string{ParamReclaimMode: "archive", ParamArchiveBucket: "archive"})
+		convey.So(err, convey.ShouldBeNil)
+		for _, volumeID := range []string{"pvc-1", "pvc-2"} {
+			data := []byte("0123456789")
+			_, err := c.mclient.PutObject(ctx, "shared", volumeID+"/a.txt", bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+			convey.So(err, convey.ShouldBeNil)
+			archivePrefix := policy.archivePrefix(volumeID)
+			convey.So(c.ArchiveObjects("shared", volumeID, policy.ArchiveBucket, archivePrefix, policy.ArchivePrefix, policy.ExpiryDays), convey.ShouldBeNil)
+			convey.So(c.RecordReclaim(policy.ArchiveBucket, archivePrefix, newReclaimRecord(ReclaimArchive, volumeID, "shared/"+volumeID)), convey.ShouldBeNil)
+		}
+
+		// every volume keeps its record
+		for _, volumeID := range []string{"pvc-1", "pvc-2"} {
+			record, err := c.GetReclaimRecord(policy.ArchiveBucket, policy.archivePrefix(volumeID))
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(record, convey.ShouldNotBeNil)
+			convey.So(record.VolumeID, convey.ShouldEqual, volumeID)
+			convey.So(record.Source, convey.ShouldEqual, "shared/"+volumeID)
+		}
+		record, err := c.GetReclaimRecord(policy.ArchiveBucket, policy.archivePrefix("pvc-3"))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(record, convey.ShouldBeNil)
+
+		// a single rule expires the archive prefix
+		config, err := c.mclient.GetBucketLifecycle(ctx, policy.ArchiveBucket)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(config.Rules, convey.ShouldHaveLength, 1)
+		convey.So(config.Rules[0].ID, convey.ShouldEqual, archiveRulePrefix+"archive")
+		convey.So(config.Rules[0].RuleFilter.Prefix, convey.ShouldEqual, "archive/")
+		convey.So(int(config.Rules[0].Expiration.Days), convey.ShouldEqual, defaultArchiveExpiryDays)
+	})
+}
@@ -0,0 +1,182 @@
+package s3minio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	errObjectLockNotFound = "ObjectLockConfigurationNotFoundError"
+	errNoSuchObjectLock   = "NoSuchObjectLockConfiguration"
+)
+
+// bucketSettings are the quota, versioning, object lock and encryption settings of the buckets of a StorageClass.
+type bucketSettings struct {
+	Versioning bool
+	// ObjectLock can only be enabled when a bucket is created, and implies versioning
+	ObjectLock bool
+	// RetentionMode and RetentionDays are the default retention of new objects, when set
+	RetentionMode minio.RetentionMode
+	RetentionDays uint
+	Encryption    volumeEncryption
+	Quota         quotaPolicy
+}
+
+// newBucketSettings parses the quota, versioning, object lock and encryption parameters of a StorageClass.
+func newBucketSettings(params map[string]string) (bucketSettings, error) {
+	encryption, err := newVolumeEncryption(params)
+	if err != nil {
+		return bucketSettings{}, err
+	}
+	quota, err := newQuotaPolicy(params)
+	if err != nil {
+		return bucketSettings{}, err
+	}
+	settings := bucketSettings{
+		Versioning: params[ParamVersioning] == "true",
+		ObjectLock: params[ParamObjectLock] == "true",
+		Encryption: encryption,
+		Quota:      quota,
+	}
+	if settings.ObjectLock {
+		settings.Versioning = true
+	}
+	mode, days := strings.ToUpper(params[ParamRetentionMode]), params[ParamRetentionDays]
+	if mode == "" && days == "" {
+		return settings, nil
+	}
+	if !settings.ObjectLock {
+		return bucketSettings{}, fmt.Errorf("%s and %s require %s", ParamRetentionMode, ParamRetentionDays, ParamObjectLock)
+	}
+	settings.RetentionMode = minio.RetentionMode(mode)
+	if !settings.RetentionMode.IsValid() {
+		return bucketSettings{}, fmt.Errorf("invalid %s %q, expect %s or %s", ParamRetentionMode, params[ParamRetentionMode], minio.Governance, minio.Compliance)
+	}
+	n, err := strconv.ParseUint(days, 10, 32)
+	if err != nil || n == 0 {
+		return bucketSettings{}, fmt.Errorf("invalid %s %q, expect a positive number of days", ParamRetentionDays, days)
+	}
+	settings.RetentionDays = uint(n)
+	return settings, nil
+}
+
+// enabled reports whether the settings change anything from a plain bucket, the quota aside.
+func (s bucketSettings) enabled() bool {
+	return s.Versioning || s.ObjectLock || s.Encryption.bucketDefault() != nil
+}
+
+// applyBucketSettings enables versioning, sets the default retention and the default encryption of bucketName.
+// All of them are idempotent.
+func (driver *MinIOClient) applyBucketSettings(bucketName string, settings bucketSettings) error {
+	ctx := context.Background()
+	if settings.Versioning {
+		if err := driver.mclient.EnableVersioning(ctx, bucketName); err != nil {
+			return fmt.Errorf("fail to enable versioning of bucket %s: %s", bucketName, err.Error())
+		}
+	}
+	if settings.RetentionMode != "" {
+		unit := minio.Days
+		if err := driver.mclient.SetObjectLockConfig(ctx, bucketName, &settings.RetentionMode, &settings.RetentionDays, &unit); err != nil {
+			return fmt.Errorf("fail to set default retention of bucket %s: %s", bucketName, err.Error())
+		}
+	}
+	if config := settings.Encryption.bucketDefault(); config != nil {
+		if err := driver.mclient.SetBucketEncryption(ctx, bucketName, config); err != nil {
+			return fmt.Errorf("fail to set default encryption of bucket %s: %s", bucketName, err.Error())
+		}
+	}
+	return nil
+}
+
+// objectLockEnabled reports whether bucketName was created with object lock.
+func (driver *MinIOClient) objectLockEnabled(bucketName string) (bool, error) {
+	objectLock, _, _, _, err := driver.mclient.GetObjectLockConfig(context.Background(), bucketName)
+	if err != nil {
+		switch minio.ToErrorResponse(err).Code {
+		case errObjectLockNotFound, errNoSuchBucket:
+			return false, nil
+		}
+		return false, fmt.Errorf("fail to get object lock of bucket %s: %s", bucketName, err.Error())
+	}
+	return objectLock == "Enabled", nil
+}
+
+// versioned reports whether bucketName has, or had, versioning enabled, so its objects may have several versions.
+func (driver *MinIOClient) versioned(bucketName string) (bool, error) {
+	versioning, err := driver.mclient.GetBucketVersioning(context.Background(), bucketName)
+	if err != nil {
+		return false, fmt.Errorf("fail to get versioning of bucket %s: %s", bucketName, err.Error())
+	}
+	return versioning.Enabled() || versioning.Suspended(), nil
+}
+
+// retentionError is returned when objects of a volume cannot be deleted yet.
+type retentionError struct {
+	bucket      string
+	key         string
+	retainUntil time.Time
+}
+
+func (e *retentionError) Error() string {
+	msg := fmt.Sprintf("object %s of bucket %s is under retention or legal hold", e.key, e.bucket)
+	if !e.retainUntil.IsZero() {
+		msg += fmt.Sprintf(", retained until %s", e.retainUntil.Format(time.RFC3339))
+	}
+	return msg
+}
+
+// CheckRetention returns a retentionError for the first object version under prefix of bucketName locked by a retention
+// or a legal hold, so a volume is not deleted halfway. Buckets without object lock are never locked and not listed,
+// the versions of the others are checked until ctx is done.
+func (driver *MinIOClient) CheckRetention(ctx context.Context, bucketName, prefix string) error {
+	enabled, err := driver.objectLockEnabled(bucketName)
+	if err != nil || !enabled {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	now := time.Now()
+	for object := range driver.mclient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Prefix:       objectPrefix(prefix),
+		Recursive:    true,
+		WithVersions: true,
+	}) {
+		if object.Err != nil {
+			return object.Err
+		}
+		if object.IsDeleteMarker {
+			continue
+		}
+		mode, retainUntil, err := driver.mclient.GetObjectRetention(ctx, bucketName, object.Key, object.VersionID)
+		if err != nil && minio.ToErrorResponse(err).Code != errNoSuchObjectLock {
+			return fmt.Errorf("fail to get retention of object %s: %s", object.Key, err.Error())
+		}
+		if err == nil && mode != nil && retainUntil != nil && retainUntil.After(now) {
+			return &retentionError{bucket: bucketName, key: object.Key, retainUntil: *retainUntil}
+		}
+		hold, err := driver.mclient.GetObjectLegalHold(ctx, bucketName, object.Key, minio.GetObjectLegalHoldOptions{VersionID: object.VersionID})
+		if err != nil && minio.ToErrorResponse(err).Code != errNoSuchObjectLock {
+			return fmt.Errorf("fail to get legal hold of object %s: %s", object.Key, err.Error())
+		}
+		if err == nil && hold != nil && *hold == minio.LegalHoldEnabled {
+			return &retentionError{bucket: bucketName, key: object.Key}
+		}
+	}
+	return ctx.Err()
+}
+
+// retentionStatus maps retention errors to FailedPrecondition, and other errors to Internal.
+func retentionStatus(err error) error {
+	var retErr *retentionError
+	if errors.As(err, &retErr) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,85 @@
+package s3minio
+
+import (
+	"bytes"
+	"context"
+	"github.com/minio/minio-go/v7"
+	"github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestBucketSettings(t *testing.T) {
+	convey.Convey("test bucket settings", t, func() {
+		settings, err := newBucketSettings(map[string]string{})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(settings.enabled(), convey.ShouldBeFalse)
+
+		settings, err = newBucketSettings(map[string]string{ParamObjectLock: "true", ParamRetentionMode: "compliance", ParamRetentionDays: "365"})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(settings.Versioning, convey.ShouldBeTrue)
+		convey.So(settings.RetentionMode, convey.ShouldEqual, minio.Compliance)
+		convey.So(settings.RetentionDays, convey.ShouldEqual, 365)
+
+		_, err = newBucketSettings(map[string]string{ParamRetentionMode: "GOVERNANCE", ParamRetentionDays: "1"})
+		convey.So(err, convey.ShouldNotBeNil)
+		_, err = newBucketSettings(map[string]string{ParamObjectLock: "true", ParamRetentionMode: "forever", ParamRetentionDays: "1"})
+		convey.So(err, convey.ShouldNotBeNil)
+		_, err = newBucketSettings(map[string]string{ParamObjectLock: "true", ParamRetentionMode: "GOVERNANCE"})
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
+
+func TestRetention(t *testing.T) {
+	convey.Convey("test object lock buckets against a stand-in server", t, func() {
+		fake := newFakeS3()
+		defer fake.Close()
+		ctx := context.Background()
+
+		c, err := NewS3Client(fake.config())
+		convey.So(err, convey.ShouldBeNil)
+		settings := bucketSettings{Versioning: true, ObjectLock: true, RetentionMode: minio.Governance, RetentionDays: 7}
+		convey.So(c.CreateBucket("audit-logs", 1024, newBucketOwner("pv-audit"), false, settings), convey.ShouldBeNil)
+		locked, err := c.objectLockEnabled("audit-logs")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(locked, convey.ShouldBeTrue)
+		versioned, err := c.versioned("audit-logs")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(versioned, convey.ShouldBeTrue)
+
+		// object lock cannot be added to an existing bucket
+		convey.So(c.CreateBucket("plain", 1024, newBucketOwner("pv-plain"), false, bucketSettings{}), convey.ShouldBeNil)
+		convey.So(c.CreateBucket("plain", 1024, newBucketOwner("pv-plain"), false, settings), convey.ShouldNotBeNil)
+
+		data := []byte("0123456789")
+		for _, key := range []string{"a.log", "b.log"} {
+			_, err = c.mclient.PutObject(ctx, "plain", key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+			convey.So(err, convey.ShouldBeNil)
+		}
+		fake.requests = nil
+		convey.So(c.CheckRetention(ctx, "plain", ""), convey.ShouldBeNil)
+		convey.So(fake.requests, convey.ShouldResemble, []string{"GET /plain/?object-lock="})
+
+		_, err = c.mclient.PutObject(ctx, "audit-logs", "free.log", bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c.CheckRetention(ctx, "audit-logs", ""), convey.ShouldBeNil)
+
+		convey.Convey("retained objects fail the deletion", func() {
+			_, err = c.mclient.PutObject(ctx, "audit-logs", "audit.log", bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+				Mode:            minio.Governance,
+				RetainUntilDate: time.Now().Add(time.Hour),
+			})
+			convey.So(err, convey.ShouldBeNil)
+			err := c.CheckRetention(ctx, "audit-logs", "")
+			convey.So(err, convey.ShouldHaveSameTypeAs, &retentionError{})
+			convey.So(err.(*retentionError).key, convey.ShouldEqual, "audit.log")
+		})
+
+		convey.Convey("every version is removed with the bucket", func() {
+			convey.So(c.DeleteBucket("audit-logs"), convey.ShouldBeNil)
+			exists, err := c.mclient.BucketExists(ctx, "audit-logs")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(exists, convey.ShouldBeFalse)
+		})
+	})
+}
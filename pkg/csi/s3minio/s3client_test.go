@@ -0,0 +1,57 @@
+package s3minio
+
+import (
+	"bytes"
+	"context"
+	"github.com/minio/madmin-go/v3"
+	"github.com/minio/minio-go/v7"
+	"github.com/smartystreets/goconvey/convey"
+	"strconv"
+	"testing"
+)
+
+func TestS3Client(t *testing.T) {
+	convey.Convey("test generic s3 client against a stand-in server", t, func() {
+		fake := newFakeS3()
+		defer fake.Close()
+		ctx := context.Background()
+
+		c, err := NewS3Client(fake.config())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c.HasAdmin(), convey.ShouldBeFalse)
+
+		bucketName := "fuse-generic"
+		convey.So(c.CreateBucket(bucketName, 1024, newBucketOwner("pv-generic"), false, bucketSettings{}), convey.ShouldBeNil)
+		exists, err := c.mclient.BucketExists(ctx, bucketName)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(exists, convey.ShouldBeTrue)
+
+		convey.Convey("quota degrades to capacity tags", func() {
+			convey.So(c.SetBucketQuota(bucketName, 2048, madmin.HardQuota), convey.ShouldNotBeNil)
+			metadata, err := c.GetBucketMetadata(bucketName)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(metadata[MetaDataCapacity], convey.ShouldEqual, strconv.Itoa(1024))
+		})
+
+		convey.Convey("usage is reported from listing", func() {
+			for _, key := range []string{"a.txt", "dir/b.txt"} {
+				data := []byte("0123456789")
+				_, err := c.mclient.PutObject(ctx, bucketName, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+				convey.So(err, convey.ShouldBeNil)
+			}
+			available, capacity, usage, _, _, inodesUsed, err := c.FsInfo(bucketName)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(capacity, convey.ShouldEqual, 1024)
+			convey.So(usage, convey.ShouldEqual, 20)
+			convey.So(available, convey.ShouldEqual, 1004)
+			convey.So(inodesUsed, convey.ShouldEqual, 2)
+
+			convey.Convey("delete removes objects and bucket", func() {
+				convey.So(c.DeleteBucket(bucketName), convey.ShouldBeNil)
+				exists, err := c.mclient.BucketExists(ctx, bucketName)
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(exists, convey.ShouldBeFalse)
+			})
+		})
+	})
+}
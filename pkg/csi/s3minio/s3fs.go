@@ -3,61 +3,63 @@ package s3minio
 import (
 	"fmt"
 	"github.com/guodoliu/csi-driver-s3/pkg/common"
+	"k8s.io/klog/v2"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
-func S3FSMount(url, bucket, mountpoint, AK, SK string) error {
-	pwFileContent := AK + ":" + SK
-	if err := writeS3fsPassword(pwFileContent); err != nil {
-		return err
-	}
-	// s3fs acs-kok:/ /mnt/s3fs/ -ourl=http://10.254.230.59:9000 -opasswd_file=/root/.passwd-s3fs -ouse_path_request_style -oallow_other -omp_umask=000
-	args := []string{
-		fmt.Sprintf("%s:/", bucket),
-		mountpoint,
-		fmt.Sprintf("-ourl=%s", url),
-		fmt.Sprintf("-opasswd_file=%s", filepath.Join(common.ConfigDir, S3FSPassWordFileName)),
-		"-ouse_path_request_style",
-		"-oallow_other",
-		"-omp_umask=0000",
-	}
+var removeLegacyPasswordOnce sync.Once
 
-	return common.FuseMount(mountpoint, S3FSCmd, args)
+func init() {
+	registerMounter(&s3fsMounter{fuseMounter{fsType: S3FSType}})
 }
 
-func S3FSUmount(mountpoint string) error {
-	return common.FuseUmount(mountpoint)
+// s3fsMounter mounts buckets with s3fs, which keeps a POSIX-ish view of the bucket.
+type s3fsMounter struct {
+	fuseMounter
 }
 
-func writeS3fsPassword(password string) error {
-	if err := makeDir(filepath.Join(common.HostDir, common.ConfigDir)); err != nil {
-		return err
-	}
+func (m *s3fsMounter) Name() string {
+	return MounterS3FS
+}
 
-	pwFilePath := filepath.Join(common.HostDir, common.ConfigDir, S3FSPassWordFileName)
-	pwFile, err := os.Create(pwFilePath)
-	if err != nil {
-		return err
+func (m *s3fsMounter) Credentials(opts *MountOptions) (string, []string) {
+	return opts.AccessKey + ":" + opts.SecretKey, []string{"AWSACCESSKEYID=" + opts.AccessKey, "AWSSECRETACCESSKEY=" + opts.SecretKey}
+}
+
+func (m *s3fsMounter) Command(opts *MountOptions, target, credentialFile string) (string, []string, []string) {
+	// s3fs acs-kok:/ /mnt/s3fs/ -f -ourl=http://10.254.230.59:9000 -opasswd_file=/run/open-object/credentials/xxx -ouse_path_request_style -oallow_other -omp_umask=000
+	args := []string{
+		fmt.Sprintf("%s:/%s", opts.Bucket, opts.Prefix),
+		target,
+		"-f",
+		fmt.Sprintf("-ourl=%s", opts.Endpoint),
+		"-ouse_path_request_style",
+		"-oallow_other",
+		"-omp_umask=0000",
+	}
+	if opts.Region != "" {
+		// s3fs signs requests for us-east-1 unless told otherwise
+		args = append(args, fmt.Sprintf("-oendpoint=%s", opts.Region))
 	}
-	err = os.Chmod(pwFilePath, 0600)
-	if err != nil {
-		return err
+	if credentialFile != "" {
+		args = append(args, fmt.Sprintf("-opasswd_file=%s", credentialFile))
 	}
-	defer pwFile.Close()
-	_, err = pwFile.WriteString(password)
-	if err != nil {
-		return err
+	if opts.ReadOnly {
+		args = append(args, "-oro")
 	}
-	return nil
+	if opts.SSECustomerKeyFile != "" {
+		args = append(args, fmt.Sprintf("-ouse_sse=custom:%s", opts.SSECustomerKeyFile))
+	}
+	return S3FSCmd, args, nil
 }
 
-func makeDir(path string) error {
-	err := os.MkdirAll(path, os.FileMode(0755))
-	if err != nil {
-		if !os.IsExist(err) {
-			return err
-		}
+// removeLegacyS3fsPassword removes the password file shared by all mounts of older versions.
+// s3fs reads it only at startup, so existing mounts are not affected.
+func removeLegacyS3fsPassword() {
+	pwFilePath := filepath.Join(common.HostDir, common.ConfigDir, S3FSPassWordFileName)
+	if err := os.Remove(pwFilePath); err != nil && !os.IsNotExist(err) {
+		klog.Warningf("fail to remove legacy s3fs password file %s: %s", pwFilePath, err.Error())
 	}
-	return nil
 }
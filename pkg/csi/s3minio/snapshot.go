@@ -0,0 +1,445 @@
+package s3minio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/minio/minio-go/v7"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/klog/v2"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SnapshotMode is how a snapshot freezes the data of a volume, chosen by the VolumeSnapshotClass.
+type SnapshotMode string
+
+const (
+	// SnapshotModeCopy copies the objects of the volume into the snapshot bucket with server side copies
+	SnapshotModeCopy SnapshotMode = "copy"
+	// SnapshotModeVersion records the current versions of the objects of a versioned bucket, without copying them.
+	// The snapshot is only as durable as those versions, a lifecycle expiring noncurrent versions also expires it.
+	// The volume is not deleted while such snapshots reference it.
+	SnapshotModeVersion SnapshotMode = "version"
+
+	// DefaultSnapshotBucket keeps the snapshots of VolumeSnapshotClasses without a snapshot bucket
+	DefaultSnapshotBucket = "open-object-snapshots"
+
+	snapshotManifestName = "manifest.json"
+	snapshotDataPrefix   = "data"
+	// snapshotBucketsPrefix keeps an empty object in DefaultSnapshotBucket for each other snapshot bucket,
+	// so snapshots are listed from every bucket of the VolumeSnapshotClasses
+	snapshotBucketsPrefix = ".snapshot-buckets"
+
+	errNoSuchKey    = "NoSuchKey"
+	errNoSuchBucket = "NoSuchBucket"
+)
+
+// snapshotManifest describes a snapshot. It is written after the data of the snapshot,
+// so a snapshot without a manifest is incomplete and is taken again.
+type snapshotManifest struct {
+	Mode         SnapshotMode `json:"mode"`
+	SourceVolume string       `json:"sourceVolume"`
+	SourceBucket string       `json:"sourceBucket"`
+	SourcePrefix string       `json:"sourcePrefix,omitempty"`
+	CreatedAt    time.Time    `json:"createdAt"`
+	SizeBytes    int64        `json:"sizeBytes"`
+	// Objects are the versions frozen by a snapshot in the version mode
+	Objects []snapshotObject `json:"objects,omitempty"`
+}
+
+type snapshotObject struct {
+	Key       string `json:"key"`
+	VersionID string `json:"versionId"`
+	Size      int64  `json:"size"`
+}
+
+// snapshotClass is the snapshot mode and the snapshot bucket of a VolumeSnapshotClass.
+type snapshotClass struct {
+	Mode   SnapshotMode
+	Bucket string
+}
+
+// newSnapshotClass parses the parameters of a VolumeSnapshotClass.
+func newSnapshotClass(params map[string]string) (*snapshotClass, error) {
+	class := &snapshotClass{
+		Mode:   SnapshotMode(strings.ToLower(params[ParamSnapshotMode])),
+		Bucket: params[ParamSnapshotBucket],
+	}
+	switch class.Mode {
+	case "":
+		class.Mode = SnapshotModeCopy
+	case SnapshotModeCopy, SnapshotModeVersion:
+	default:
+		return nil, fmt.Errorf("unknown %s %q, expect %s or %s", ParamSnapshotMode, class.Mode, SnapshotModeCopy, SnapshotModeVersion)
+	}
+	if class.Bucket == "" {
+		class.Bucket = DefaultSnapshotBucket
+	}
+	return class, nil
+}
+
+// snapshotID identifies a snapshot by the driverName of its backend, the bucket keeping it and its name,
+// since DeleteSnapshot gets nothing but the id.
+func snapshotID(driverName, bucketName, name string) string {
+	return strings.Join([]string{driverName, bucketName, name}, "/")
+}
+
+// parseSnapshotID splits an id returned by snapshotID.
+func parseSnapshotID(id string) (driverName, bucketName, name string, err error) {
+	parts := strings.Split(id, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", "", fmt.Errorf("malformed snapshot id %q, expect <driver>/<bucket>/<name>", id)
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// versioningError is returned when a version snapshot is taken of a bucket without versioning.
+type versioningError struct {
+	bucket string
+}
+
+func (e *versioningError) Error() string {
+	return fmt.Sprintf("bucket %s is not versioned, it cannot have %s snapshots", e.bucket, SnapshotModeVersion)
+}
+
+// CreateSnapshot freezes the objects under srcPrefix of srcBucket, the source of volumeID, as the snapshot name
+// of snapshotBucket. A retry after a failure copies the objects again, over the ones it already copied.
+func (driver *MinIOClient) CreateSnapshot(snapshotBucket, name string, mode SnapshotMode, volumeID, srcBucket, srcPrefix string) (*snapshotManifest, error) {
+	if err := driver.ensureBucket(snapshotBucket); err != nil {
+		return nil, err
+	}
+	if err := driver.recordSnapshotBucket(snapshotBucket); err != nil {
+		return nil, err
+	}
+	manifest := &snapshotManifest{
+		Mode:         mode,
+		SourceVolume: volumeID,
+		SourceBucket: srcBucket,
+		SourcePrefix: srcPrefix,
+	}
+	switch mode {
+	case SnapshotModeCopy:
+		count, size, err := driver.copyObjects(srcBucket, srcPrefix, snapshotBucket, path.Join(name, snapshotDataPrefix))
+		if err != nil {
+			return nil, err
+		}
+		manifest.SizeBytes = size
+		klog.Infof("snapshot %s copied %d objects of %s", name, count, path.Join(srcBucket, srcPrefix))
+	case SnapshotModeVersion:
+		objects, err := driver.currentVersions(srcBucket, srcPrefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range objects {
+			manifest.SizeBytes += object.Size
+		}
+		manifest.Objects = objects
+		// the source bucket records where its versions are referenced, before the snapshot is complete
+		if err := driver.recordVersionSnapshotBucket(srcBucket, snapshotBucket); err != nil {
+			return nil, err
+		}
+		klog.Infof("snapshot %s recorded %d object versions of %s", name, len(objects), path.Join(srcBucket, srcPrefix))
+	default:
+		return nil, fmt.Errorf("unknown snapshot mode %s", mode)
+	}
+	manifest.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	if err := driver.putSnapshotManifest(snapshotBucket, name, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// currentVersions lists the current versions of the objects under prefix of a versioned bucket.
+func (driver *MinIOClient) currentVersions(bucketName, prefix string) ([]snapshotObject, error) {
+	ctx := context.Background()
+	versioning, err := driver.mclient.GetBucketVersioning(ctx, bucketName)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get versioning of bucket %s: %s", bucketName, err.Error())
+	}
+	if !versioning.Enabled() {
+		return nil, &versioningError{bucket: bucketName}
+	}
+	var objects []snapshotObject
+	for object := range driver.mclient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Prefix:       objectPrefix(prefix),
+		Recursive:    true,
+		WithVersions: true,
+	}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		if !object.IsLatest || object.IsDeleteMarker {
+			continue
+		}
+		objects = append(objects, snapshotObject{Key: object.Key, VersionID: object.VersionID, Size: object.Size})
+	}
+	return objects, nil
+}
+
+// recordVersionSnapshotBucket adds snapshotBucket to the snapshot buckets recorded on the tags of srcBucket.
+func (driver *MinIOClient) recordVersionSnapshotBucket(srcBucket, snapshotBucket string) error {
+	bucketMap, err := driver.bucketTags(srcBucket)
+	if err != nil {
+		return err
+	}
+	buckets := splitTagList(bucketMap[TagVersionSnapshotBuckets])
+	for _, b := range buckets {
+		if b == snapshotBucket {
+			return nil
+		}
+	}
+	bucketMap[TagVersionSnapshotBuckets] = strings.Join(append(buckets, snapshotBucket), ",")
+	return driver.SetBucketMetadata(srcBucket, bucketMap)
+}
+
+// VersionSnapshots returns the ids of the version snapshots frozen in the objects under prefix of bucketName,
+// which are lost with the versions of the objects.
+func (driver *MinIOClient) VersionSnapshots(driverName, bucketName, prefix string) ([]string, error) {
+	bucketMap, err := driver.bucketTags(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, snapshotBucket := range splitTagList(bucketMap[TagVersionSnapshotBuckets]) {
+		snapshots, err := driver.ListSnapshots(snapshotBucket)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range sortedSnapshotNames(snapshots) {
+			manifest := snapshots[name]
+			if manifest.Mode == SnapshotModeVersion && manifest.SourceBucket == bucketName && manifest.SourcePrefix == prefix {
+				ids = append(ids, snapshotID(driverName, snapshotBucket, name))
+			}
+		}
+	}
+	return ids, nil
+}
+
+func splitTagList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// putSnapshotManifest completes the snapshot name of snapshotBucket.
+func (driver *MinIOClient) putSnapshotManifest(snapshotBucket, name string, manifest *snapshotManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	key := path.Join(name, snapshotManifestName)
+	if _, err := driver.mclient.PutObject(context.Background(), snapshotBucket, key, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/json"}); err != nil {
+		return fmt.Errorf("failed to write snapshot manifest %s/%s: %s", snapshotBucket, key, err.Error())
+	}
+	return nil
+}
+
+// GetSnapshotManifest returns the manifest of the snapshot name of snapshotBucket, nil when there is no such snapshot.
+func (driver *MinIOClient) GetSnapshotManifest(snapshotBucket, name string) (*snapshotManifest, error) {
+	key := path.Join(name, snapshotManifestName)
+	object, err := driver.mclient.GetObject(context.Background(), snapshotBucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot manifest %s/%s: %s", snapshotBucket, key, err.Error())
+	}
+	defer object.Close()
+	manifest := &snapshotManifest{}
+	if err := json.NewDecoder(object).Decode(manifest); err != nil {
+		switch minio.ToErrorResponse(err).Code {
+		case errNoSuchKey, errNoSuchBucket:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read snapshot manifest %s/%s: %s", snapshotBucket, key, err.Error())
+	}
+	return manifest, nil
+}
+
+// recordSnapshotBucket records snapshotBucket in DefaultSnapshotBucket, an object per bucket needs no read before the write.
+func (driver *MinIOClient) recordSnapshotBucket(snapshotBucket string) error {
+	if snapshotBucket == DefaultSnapshotBucket {
+		return nil
+	}
+	if err := driver.ensureBucket(DefaultSnapshotBucket); err != nil {
+		return err
+	}
+	key := path.Join(snapshotBucketsPrefix, snapshotBucket)
+	if _, err := driver.mclient.PutObject(context.Background(), DefaultSnapshotBucket, key, bytes.NewReader(nil), 0, minio.PutObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to record snapshot bucket %s: %s", snapshotBucket, err.Error())
+	}
+	return nil
+}
+
+// SnapshotBuckets returns DefaultSnapshotBucket and the snapshot buckets recorded in it.
+func (driver *MinIOClient) SnapshotBuckets() ([]string, error) {
+	buckets := []string{DefaultSnapshotBucket}
+	for object := range driver.mclient.ListObjects(context.Background(), DefaultSnapshotBucket, minio.ListObjectsOptions{
+		Prefix: snapshotBucketsPrefix + "/",
+		UseV1:  true,
+	}) {
+		if object.Err != nil {
+			if minio.ToErrorResponse(object.Err).Code == errNoSuchBucket {
+				return buckets, nil
+			}
+			return nil, object.Err
+		}
+		buckets = append(buckets, path.Base(object.Key))
+	}
+	return buckets, nil
+}
+
+// ListSnapshots returns the manifests of the complete snapshots of snapshotBucket by snapshot name.
+func (driver *MinIOClient) ListSnapshots(snapshotBucket string) (map[string]*snapshotManifest, error) {
+	snapshots := map[string]*snapshotManifest{}
+	for object := range driver.mclient.ListObjects(context.Background(), snapshotBucket, minio.ListObjectsOptions{UseV1: true}) {
+		if object.Err != nil {
+			if minio.ToErrorResponse(object.Err).Code == errNoSuchBucket {
+				return snapshots, nil
+			}
+			return nil, object.Err
+		}
+		name := strings.TrimSuffix(object.Key, "/")
+		if name == object.Key || name == snapshotBucketsPrefix {
+			continue
+		}
+		manifest, err := driver.GetSnapshotManifest(snapshotBucket, name)
+		if err != nil {
+			return nil, err
+		}
+		if manifest != nil {
+			snapshots[name] = manifest
+		}
+	}
+	return snapshots, nil
+}
+
+// DeleteSnapshot removes the snapshot name of snapshotBucket. The manifest goes first, so a snapshot
+// partly removed is never restored. The versions of a version snapshot are left to the source bucket.
+func (driver *MinIOClient) DeleteSnapshot(snapshotBucket, name string) error {
+	exists, err := driver.mclient.BucketExists(context.Background(), snapshotBucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	key := path.Join(name, snapshotManifestName)
+	if err := driver.mclient.RemoveObject(context.Background(), snapshotBucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to remove snapshot manifest %s/%s: %s", snapshotBucket, key, err.Error())
+	}
+	return driver.removeObjects(snapshotBucket, name)
+}
+
+// RestoreSnapshot copies the objects of the snapshot name of snapshotBucket to dstPrefix in dstBucket.
+func (driver *MinIOClient) RestoreSnapshot(snapshotBucket, name string, manifest *snapshotManifest, dstBucket, dstPrefix string) error {
+	switch manifest.Mode {
+	case SnapshotModeCopy:
+		count, _, err := driver.copyObjects(snapshotBucket, path.Join(name, snapshotDataPrefix), dstBucket, dstPrefix)
+		if err != nil {
+			return err
+		}
+		klog.Infof("restored %d objects of snapshot %s to %s", count, name, path.Join(dstBucket, dstPrefix))
+	case SnapshotModeVersion:
+		for _, object := range manifest.Objects {
+			key, ok := relocateKey(object.Key, manifest.SourcePrefix, dstPrefix)
+			if !ok {
+				continue
+			}
+			src := minio.CopySrcOptions{Bucket: manifest.SourceBucket, Object: object.Key, VersionID: object.VersionID}
+			if err := driver.copyObject(src, object.Size, dstBucket, key); err != nil {
+				return err
+			}
+		}
+		klog.Infof("restored %d object versions of snapshot %s to %s", len(manifest.Objects), name, path.Join(dstBucket, dstPrefix))
+	default:
+		return fmt.Errorf("unknown snapshot mode %s", manifest.Mode)
+	}
+	return nil
+}
+
+// snapshotStatus maps versioning errors to FailedPrecondition, and other errors to Internal.
+func snapshotStatus(err error) error {
+	var verErr *versioningError
+	if errors.As(err, &verErr) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// snapshotRef is a complete snapshot of the backend, found by its id.
+type snapshotRef struct {
+	Bucket   string
+	Name     string
+	Manifest *snapshotManifest
+}
+
+// lookupSnapshot finds the snapshot a volume is restored from, NotFound when it does not exist.
+func (driver *MinIODriver) lookupSnapshot(id string) (*snapshotRef, error) {
+	driverName, bucketName, name, err := parseSnapshotID(id)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if driverName != driver.name {
+		return nil, status.Errorf(codes.InvalidArgument, "snapshot %s belongs to storage driver %s, not %s", id, driverName, driver.name)
+	}
+	manifest, err := driver.minioClient.GetSnapshotManifest(bucketName, name)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if manifest == nil {
+		return nil, status.Errorf(codes.NotFound, "snapshot %s not found", id)
+	}
+	return &snapshotRef{Bucket: bucketName, Name: name, Manifest: manifest}, nil
+}
+
+// csiSnapshot describes a snapshot to the external-snapshotter. Snapshots are taken synchronously,
+// so they are always ready to use.
+func (driver *MinIODriver) csiSnapshot(snapshotBucket, name string, manifest *snapshotManifest) *csi.Snapshot {
+	return &csi.Snapshot{
+		SnapshotId:     snapshotID(driver.name, snapshotBucket, name),
+		SourceVolumeId: manifest.SourceVolume,
+		SizeBytes:      manifest.SizeBytes,
+		CreationTime:   timestamppb.New(manifest.CreatedAt),
+		ReadyToUse:     true,
+	}
+}
+
+// sortedSnapshotNames returns the names of snapshots in a stable order, for paging through them.
+func sortedSnapshotNames(snapshots map[string]*snapshotManifest) []string {
+	names := make([]string, 0, len(snapshots))
+	for name := range snapshots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pageSnapshots returns the page of entries starting at the offset in startingToken, and the token of the next page.
+func pageSnapshots(entries []*csi.ListSnapshotsResponse_Entry, startingToken string, maxEntries int32) ([]*csi.ListSnapshotsResponse_Entry, string, error) {
+	start := 0
+	if startingToken != "" {
+		n, err := strconv.Atoi(startingToken)
+		if err != nil || n < 0 || n > len(entries) {
+			return nil, "", status.Errorf(codes.Aborted, "invalid starting token %q", startingToken)
+		}
+		start = n
+	}
+	end := len(entries)
+	if maxEntries > 0 && start+int(maxEntries) < end {
+		end = start + int(maxEntries)
+	}
+	next := ""
+	if end < len(entries) {
+		next = strconv.Itoa(end)
+	}
+	return entries[start:end], next, nil
+}
@@ -0,0 +1,195 @@
+package s3minio
+
+import (
+	"bytes"
+	"context"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/minio/minio-go/v7"
+	"github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+func TestSnapshotClass(t *testing.T) {
+	convey.Convey("test snapshot class and id", t, func() {
+		class, err := newSnapshotClass(map[string]string{})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(class.Mode, convey.ShouldEqual, SnapshotModeCopy)
+		convey.So(class.Bucket, convey.ShouldEqual, DefaultSnapshotBucket)
+
+		class, err = newSnapshotClass(map[string]string{ParamSnapshotMode: "Version", ParamSnapshotBucket: "backups"})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(class.Mode, convey.ShouldEqual, SnapshotModeVersion)
+		convey.So(class.Bucket, convey.ShouldEqual, "backups")
+
+		_, err = newSnapshotClass(map[string]string{ParamSnapshotMode: "clone"})
+		convey.So(err, convey.ShouldNotBeNil)
+
+		driverName, bucketName, name, err := parseSnapshotID(snapshotID(DriverName, "backups", "snapshot-1"))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So([]string{driverName, bucketName, name}, convey.ShouldResemble, []string{DriverName, "backups", "snapshot-1"})
+		_, _, _, err = parseSnapshotID("backups/snapshot-1")
+		convey.So(err, convey.ShouldNotBeNil)
+
+		key, ok := relocateKey("pvc-1/dir/a.txt", "pvc-1", "")
+		convey.So(ok, convey.ShouldBeTrue)
+		convey.So(key, convey.ShouldEqual, "dir/a.txt")
+		key, _ = relocateKey("dir/", "", "snapshot-1/data")
+		convey.So(key, convey.ShouldEqual, "snapshot-1/data/dir/")
+		// the directory marker of a prefix is dropped at the root of a bucket
+		_, ok = relocateKey("pvc-1/", "pvc-1", "")
+		convey.So(ok, convey.ShouldBeFalse)
+
+		entries := make([]*csi.ListSnapshotsResponse_Entry, 5)
+		page, next, err := pageSnapshots(entries, "", 2)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(page, convey.ShouldHaveLength, 2)
+		convey.So(next, convey.ShouldEqual, "2")
+		page, next, err = pageSnapshots(entries, "4", 2)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(page, convey.ShouldHaveLength, 1)
+		convey.So(next, convey.ShouldEqual, "")
+		_, _, err = pageSnapshots(entries, "6", 0)
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
+
+func TestCopySnapshot(t *testing.T) {
+	convey.Convey("test copy snapshots against a stand-in server", t, func() {
+		fake := newFakeS3()
+		defer fake.Close()
+		ctx := context.Background()
+
+		c, err := NewS3Client(fake.config())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c.CreatePrefix("shared", "pvc-1"), convey.ShouldBeNil)
+		for _, key := range []string{"pvc-1/a.txt", "pvc-1/dir/b.txt", "pvc-2/c.txt"} {
+			data := []byte("0123456789")
+			_, err := c.mclient.PutObject(ctx, "shared", key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+			convey.So(err, convey.ShouldBeNil)
+		}
+
+		manifest, err := c.CreateSnapshot("snapshots", "snapshot-1", SnapshotModeCopy, "pvc-1", "shared", "pvc-1")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(manifest.SizeBytes, convey.ShouldEqual, 20)
+
+		stored, err := c.GetSnapshotManifest("snapshots", "snapshot-1")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(stored.SourceVolume, convey.ShouldEqual, "pvc-1")
+		convey.So(stored.SizeBytes, convey.ShouldEqual, 20)
+		missing, err := c.GetSnapshotManifest("snapshots", "snapshot-2")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(missing, convey.ShouldBeNil)
+
+		snapshots, err := c.ListSnapshots("snapshots")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(sortedSnapshotNames(snapshots), convey.ShouldResemble, []string{"snapshot-1"})
+
+		convey.Convey("restore into a bucket", func() {
+			convey.So(c.mclient.MakeBucket(ctx, "restored", minio.MakeBucketOptions{}), convey.ShouldBeNil)
+			convey.So(c.RestoreSnapshot("snapshots", "snapshot-1", stored, "restored", ""), convey.ShouldBeNil)
+			objects, err := c.ListBucketObjects("restored")
+			convey.So(err, convey.ShouldBeNil)
+			var keys []string
+			for _, object := range objects {
+				keys = append(keys, object.Key)
+			}
+			convey.So(keys, convey.ShouldResemble, []string{"a.txt", "dir/b.txt"})
+		})
+
+		convey.Convey("delete removes the snapshot", func() {
+			convey.So(c.DeleteSnapshot("snapshots", "snapshot-1"), convey.ShouldBeNil)
+			stored, err := c.GetSnapshotManifest("snapshots", "snapshot-1")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(stored, convey.ShouldBeNil)
+			objects, err := c.ListBucketObjects("snapshots")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(objects, convey.ShouldBeEmpty)
+			// the source is left alone
+			objects, err = c.listObjects("shared", "pvc-1")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(objects, convey.ShouldHaveLength, 3)
+		})
+	})
+}
+
+func TestVersionSnapshotOutlivesSource(t *testing.T) {
+	convey.Convey("test version snapshots against a stand-in server", t, func() {
+		fake3 := newFakeS3()
+		defer fake3.Close()
+		ctx := context.Background()
+
+		c, err := NewS3Client(fake3.config())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c.CreatePrefix("shared", "pvc-1"), convey.ShouldBeNil)
+		convey.So(c.mclient.EnableVersioning(ctx, "shared"), convey.ShouldBeNil)
+		for _, key := range []string{"pvc-1/a.txt", "pvc-2/b.txt"} {
+			data := []byte("0123456789")
+			_, err := c.mclient.PutObject(ctx, "shared", key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+			convey.So(err, convey.ShouldBeNil)
+		}
+		pv := &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+					VolumeHandle: "pvc-1",
+					VolumeAttributes: map[string]string{
+						ParamProvisionTypeTag: string(ProvisionTypePrefix),
+						ParamBucketNameTag:    "shared",
+						ParamPrefixTag:        "pvc-1",
+					},
+				}},
+			},
+		}
+		driver := &MinIODriver{name: S3DriverName, minioClient: c, kubeClient: fake.NewSimpleClientset(pv)}
+
+		resp, err := driver.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{
+			Name:           "snapshot-1",
+			SourceVolumeId: "pvc-1",
+			Parameters:     map[string]string{ParamSnapshotMode: string(SnapshotModeVersion), ParamSnapshotBucket: "snapshots"},
+		})
+		convey.So(err, convey.ShouldBeNil)
+
+		// snapshots of every snapshot bucket are listed
+		list, err := driver.ListSnapshots(ctx, &csi.ListSnapshotsRequest{SourceVolumeId: "pvc-1"})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(list.GetEntries(), convey.ShouldHaveLength, 1)
+		convey.So(list.GetEntries()[0].GetSnapshot().GetSnapshotId(), convey.ShouldEqual, resp.GetSnapshot().GetSnapshotId())
+		defaults, err := c.ListSnapshots(DefaultSnapshotBucket)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(defaults, convey.ShouldBeEmpty)
+
+		// the source is kept while the snapshot references its versions
+		_, err = driver.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "pvc-1"})
+		convey.So(status.Code(err), convey.ShouldEqual, codes.FailedPrecondition)
+		convey.So(err.Error(), convey.ShouldContainSubstring, resp.GetSnapshot().GetSnapshotId())
+
+		ref, err := driver.lookupSnapshot(resp.GetSnapshot().GetSnapshotId())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c.mclient.MakeBucket(ctx, "restored", minio.MakeBucketOptions{}), convey.ShouldBeNil)
+		convey.So(c.RestoreSnapshot(ref.Bucket, ref.Name, ref.Manifest, "restored", ""), convey.ShouldBeNil)
+		objects, err := c.ListBucketObjects("restored")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(objects, convey.ShouldHaveLength, 1)
+		convey.So(objects[0].Key, convey.ShouldEqual, "a.txt")
+
+		// volumes sharing the bucket are not held by the snapshot
+		snapshots, err := c.VersionSnapshots(S3DriverName, "shared", "pvc-2")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(snapshots, convey.ShouldBeEmpty)
+
+		convey.Convey("the source is deleted with the snapshot gone", func() {
+			_, err := driver.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: resp.GetSnapshot().GetSnapshotId()})
+			convey.So(err, convey.ShouldBeNil)
+			_, err = driver.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "pvc-1"})
+			convey.So(err, convey.ShouldBeNil)
+			objects, err := c.listObjects("shared", "pvc-1")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(objects, convey.ShouldBeEmpty)
+		})
+	})
+}
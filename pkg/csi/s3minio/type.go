@@ -10,30 +10,107 @@ type S3Config struct {
 type ProvisionType string
 
 const (
-	DriverName string = "s3minio"
+	DriverName   string = "s3minio"
+	S3DriverName string = "s3"
 
 	NamePrefix                                = "object.csi.gordon.com/"
 	ProvisionTypeBucketOrCreate ProvisionType = "BucketOrCreate"
-	ParamProvisionTypeTag                     = NamePrefix + "provision-type"
-	ParamBucketNameTag                        = NamePrefix + "bucket-name"
-	ParamQuotaType                            = NamePrefix + "quota-type"
-	ParamPVName                               = "csi.storage.k8s.io/pv/name"
-	ParamPVCName                              = "csi.storage.k8s.io/pvc/name"
-	ParamPVCNameSpace                         = "csi.storage.k8s.io/pvc/namespace"
-	QuotaTypeHard                             = "hard"
-	QuotaTypeFIFO                             = "fifo"
-	MetaDataCapacity                          = NamePrefix + "capacity-bytes"
-	MetaDataPrivisionType                     = NamePrefix + "provision-type"
-	AnnoBucketName                            = NamePrefix + "bucket-name"
+	// ProvisionTypeStatic is a pre-existing bucket named by a static PV, the driver never deletes it
+	ProvisionTypeStatic ProvisionType = "Static"
+	// ProvisionTypePrefix allocates a prefix per volume in a shared bucket, named by the bucket-name parameter
+	ProvisionTypePrefix   ProvisionType = "Prefix"
+	ParamPrefixTag                      = NamePrefix + "prefix"
+	ParamProvisionTypeTag               = NamePrefix + "provision-type"
+	ParamBucketNameTag                  = NamePrefix + "bucket-name"
+	ParamQuotaType                      = NamePrefix + "quota-type"
+	ParamAccessKeyTag                   = NamePrefix + "access-key"
+	// ParamVolumeSecretTag references the secret keeping the scoped credentials of a volume, as namespace/name
+	ParamVolumeSecretTag = NamePrefix + "volume-secret"
+	// AnnoSecretVolume records the volume a secret keeps the credentials of
+	AnnoSecretVolume  = NamePrefix + "volume"
+	ParamPVName       = "csi.storage.k8s.io/pv/name"
+	ParamPVCName      = "csi.storage.k8s.io/pvc/name"
+	ParamPVCNameSpace = "csi.storage.k8s.io/pvc/namespace"
+	QuotaTypeHard     = "hard"
+	QuotaTypeFIFO     = "fifo"
+	// QuotaTypeSoft never blocks writes, the usage of the volumes is reported by PVC events at ParamQuotaThresholds
+	QuotaTypeSoft = "soft"
+	// ParamQuotaThresholds are the percentages of the capacity soft quotas report usage at, e.g. 80,100
+	ParamQuotaThresholds = NamePrefix + "quota-thresholds"
+	// AnnoQuotaThreshold records on a PVC the last soft quota threshold its volume reached
+	AnnoQuotaThreshold    = NamePrefix + "quota-threshold"
+	MetaDataCapacity      = NamePrefix + "capacity-bytes"
+	MetaDataPrivisionType = NamePrefix + "provision-type"
+	AnnoBucketName        = NamePrefix + "bucket-name"
+
+	// ParamReclaimMode selects what DeleteVolume does with the data, delete, retain or archive
+	ParamReclaimMode = NamePrefix + "reclaim-mode"
+	// ParamArchiveBucket, ParamArchivePrefix and ParamArchiveExpiryDays configure where the archive mode moves data
+	ParamArchiveBucket     = NamePrefix + "archive-bucket"
+	ParamArchivePrefix     = NamePrefix + "archive-prefix"
+	ParamArchiveExpiryDays = NamePrefix + "archive-expiry-days"
+	// tags recording the volume a bucket created by the driver belongs to
+	TagOwnerVolume  = NamePrefix + "owner-volume"
+	TagOwnerCluster = NamePrefix + "owner-cluster"
+	TagOwnerDriver  = NamePrefix + "owner-driver"
+	// ParamAllowAdoption lets the PVCs of a StorageClass adopt existing buckets with AnnoAdoptBucket
+	ParamAllowAdoption = NamePrefix + "allow-adoption"
+	AnnoAdoptBucket    = NamePrefix + "adopt-bucket"
+	// ParamBucketNameTemplate names the buckets of a StorageClass, e.g. ${cluster.id}-${pvc.namespace}-${pvc.name}
+	ParamBucketNameTemplate = NamePrefix + "bucket-name-template"
+	// ParamSnapshotMode and ParamSnapshotBucket are VolumeSnapshotClass parameters, see SnapshotMode
+	ParamSnapshotMode   = NamePrefix + "snapshot-mode"
+	ParamSnapshotBucket = NamePrefix + "snapshot-bucket"
+	// TagVersionSnapshotBuckets lists the snapshot buckets with version snapshots of a bucket, comma separated
+	TagVersionSnapshotBuckets = NamePrefix + "version-snapshot-buckets"
+	// ParamVersioning and ParamObjectLock create the buckets of a StorageClass versioned, or with object lock
+	ParamVersioning = NamePrefix + "versioning"
+	ParamObjectLock = NamePrefix + "object-lock"
+	// ParamRetentionMode, GOVERNANCE or COMPLIANCE, and ParamRetentionDays are the default retention of object lock buckets
+	ParamRetentionMode = NamePrefix + "retention-mode"
+	ParamRetentionDays = NamePrefix + "retention-days"
+	// lifecycle of the objects of the volumes of a StorageClass, PVC annotations with the same keys override them
+	ParamExpirationDays           = NamePrefix + "expiration-days"
+	ParamNoncurrentExpirationDays = NamePrefix + "noncurrent-expiration-days"
+	ParamAbortUploadDays          = NamePrefix + "abort-incomplete-upload-days"
+	ParamTransitionDays           = NamePrefix + "transition-days"
+	ParamTransitionStorageClass   = NamePrefix + "transition-storage-class"
+	// ParamEncryption, see EncryptionType, encrypts the objects of the volumes of a StorageClass. ParamKMSKeyID is the
+	// key of SSE-KMS, ParamSSECKeySecretName and ParamSSECKeySecretNamespace reference the secret holding the key of SSE-C,
+	// in the namespace of the driver or of the PVC, which ${pvc.namespace} stands for
+	ParamEncryption             = NamePrefix + "encryption"
+	ParamKMSKeyID               = NamePrefix + "kms-key-id"
+	ParamSSECKeySecretName      = NamePrefix + "sse-c-key-secret-name"
+	ParamSSECKeySecretNamespace = NamePrefix + "sse-c-key-secret-namespace"
+	// tags recording the encryption of a bucket
+	TagEncryption          = NamePrefix + "encryption"
+	TagEncryptionKMSKeyID  = NamePrefix + "encryption-kms-key-id"
+	TagEncryptionKeySecret = NamePrefix + "encryption-key-secret"
+	// ParamCloneWorkers is the number of objects a clone copies at a time
+	ParamCloneWorkers = NamePrefix + "clone-workers"
 
 	SecretMinIOHost string = "host"
 	SecretRegion    string = "region"
 	SecretAK        string = "rootUser"
 	SecretSK        string = "rootPassword"
+	SecretAccessKey string = "accesskey"
+	SecretSecretKey string = "secretkey"
 
 	S3FSCmd              = "s3fs"
 	S3FSPassWordFileName = ".passwd-s3fs"
 	S3FSType             = "fuse.s3fs"
+	MountpointS3Cmd      = "mount-s3"
+
+	// ParamMounter selects the fuse client mounting the volume
+	ParamMounter        = "mounter"
+	MounterS3FS         = "s3fs"
+	MounterGoofys       = "goofys"
+	MounterGeesefs      = "geesefs"
+	MounterRclone       = "rclone"
+	MounterMountpointS3 = "mountpoint-s3"
+	MounterBuiltin      = "builtin"
 
 	maxObjectNum = 10000
+	// maxSingleCopySize is the largest object a single server side copy accepts
+	maxSingleCopySize = 5 << 30
 )
@@ -0,0 +1,162 @@
+package s3minio
+
+import (
+	"context"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"path"
+	"sync"
+	"time"
+)
+
+// DefaultUsageStaleness is how long the usage of a volume is served from the cache, set from the flags of the csi
+// command. 0 disables the cache, every request then accounts the usage again.
+var DefaultUsageStaleness = time.Minute
+
+const (
+	// usageIdleTimeout drops the volumes whose usage was not asked for, e.g. unpublished from the node
+	usageIdleTimeout = 10 * time.Minute
+	// usageListInterval and usageListBurst limit the pages listed by the process, the fallback of object stores
+	// without usage accounting and of prefix volumes
+	usageListInterval = 200 * time.Millisecond
+	usageListBurst    = 4
+)
+
+// usageListPageSize is the number of objects of a page of the usage listings, the most S3 lists in a request.
+var usageListPageSize = 1000
+
+// volumeUsage is the size and the number of the objects of a volume.
+type volumeUsage struct {
+	Bytes   int64
+	Objects int64
+}
+
+// bucketUsage accounts the usage of the objects under prefix of bucketName. Whole buckets take it from the data
+// usage the MinIO scanner keeps, anything else is listed, a page at most every usageListInterval in the process.
+func (driver *MinIOClient) bucketUsage(ctx context.Context, bucketName, prefix string) (volumeUsage, error) {
+	if prefix == "" && driver.HasAdmin() {
+		info, err := driver.madmin.DataUsageInfo(ctx)
+		if err != nil {
+			klog.V(4).Infof("fail to get data usage of %s, listing bucket %s: %s", driver.mclient.EndpointURL().Host, bucketName, err.Error())
+		} else if bucket, ok := info.BucketsUsage[bucketName]; ok && !info.LastUpdate.IsZero() {
+			return volumeUsage{Bytes: int64(bucket.Size), Objects: int64(bucket.ObjectsCount)}, nil
+		}
+		// the scanner has not seen the bucket yet
+	}
+	var usage volumeUsage
+	core := minio.Core{Client: driver.mclient}
+	marker := ""
+	for {
+		if err := usageListLimiter.Wait(ctx); err != nil {
+			return volumeUsage{}, err
+		}
+		page, err := core.ListObjects(bucketName, objectPrefix(prefix), marker, "", usageListPageSize)
+		if err != nil {
+			return volumeUsage{}, fmt.Errorf("fail to list %s: %s", path.Join(bucketName, prefix), err.Error())
+		}
+		for _, object := range page.Contents {
+			usage.Bytes += object.Size
+			usage.Objects++
+		}
+		if !page.IsTruncated || len(page.Contents) == 0 {
+			return usage, nil
+		}
+		// v1 listings only return the next marker with a delimiter
+		if marker = page.NextMarker; marker == "" {
+			marker = page.Contents[len(page.Contents)-1].Key
+		}
+	}
+}
+
+// waiter is the rate limiter of the usage listings, replaced in tests.
+type waiter interface {
+	Wait(ctx context.Context) error
+}
+
+var (
+	usageListLimiter waiter = rate.NewLimiter(rate.Every(usageListInterval), usageListBurst)
+	volumeUsageCache        = &usageCache{entries: map[string]*usageEntry{}}
+)
+
+// usageCache keeps the usage of the volumes asked for, which a background refresher accounts again before it is
+// stale, so kubelet polls of the volume stats are answered from memory. The cache is shared by the drivers of the
+// requests, each entry keeps the client of the last driver asking for it.
+type usageCache struct {
+	sync.Mutex
+	entries map[string]*usageEntry
+	start   sync.Once
+}
+
+type usageEntry struct {
+	client         *MinIOClient
+	bucket, prefix string
+	usage          volumeUsage
+	accounted      time.Time
+	accessed       time.Time
+}
+
+// get returns the usage of the objects under prefix of bucketName, accounted within DefaultUsageStaleness.
+func (c *usageCache) get(ctx context.Context, client *MinIOClient, bucketName, prefix string) (volumeUsage, error) {
+	staleness := DefaultUsageStaleness
+	if staleness <= 0 {
+		return client.bucketUsage(ctx, bucketName, prefix)
+	}
+	c.start.Do(func() {
+		go wait.Forever(c.refresh, staleness/2)
+	})
+
+	key := client.mclient.EndpointURL().String() + "/" + path.Join(bucketName, prefix)
+	now := time.Now()
+	c.Lock()
+	e, ok := c.entries[key]
+	if ok {
+		e.client, e.accessed = client, now
+		if now.Sub(e.accounted) < staleness {
+			usage := e.usage
+			c.Unlock()
+			return usage, nil
+		}
+	}
+	c.Unlock()
+
+	usage, err := client.bucketUsage(ctx, bucketName, prefix)
+	if err != nil {
+		return volumeUsage{}, err
+	}
+	c.Lock()
+	c.entries[key] = &usageEntry{client: client, bucket: bucketName, prefix: prefix, usage: usage, accounted: time.Now(), accessed: now}
+	c.Unlock()
+	return usage, nil
+}
+
+// refresh accounts again the usage of the volumes that will be stale before the next pass, and drops the idle ones.
+func (c *usageCache) refresh() {
+	now := time.Now()
+	stale := map[string]usageEntry{}
+	c.Lock()
+	for key, e := range c.entries {
+		switch {
+		case now.Sub(e.accessed) > usageIdleTimeout:
+			delete(c.entries, key)
+		case now.Sub(e.accounted) >= DefaultUsageStaleness/2:
+			stale[key] = *e
+		}
+	}
+	c.Unlock()
+
+	for key, e := range stale {
+		usage, err := e.client.bucketUsage(context.Background(), e.bucket, e.prefix)
+		if err != nil {
+			klog.Warningf("fail to refresh usage of %s: %s", path.Join(e.bucket, e.prefix), err.Error())
+			continue
+		}
+		c.Lock()
+		if current, ok := c.entries[key]; ok {
+			current.usage, current.accounted = usage, time.Now()
+		}
+		c.Unlock()
+	}
+}
@@ -0,0 +1,150 @@
+package s3minio
+
+import (
+	"bytes"
+	"context"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/minio/minio-go/v7"
+	"github.com/smartystreets/goconvey/convey"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+	"time"
+)
+
+func TestUsageCache(t *testing.T) {
+	convey.Convey("test the usage cache against a stand-in server", t, func() {
+		fake := newFakeS3()
+		defer fake.Close()
+		ctx := context.Background()
+
+		c, err := NewS3Client(fake.config())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c.mclient.MakeBucket(ctx, "usage", minio.MakeBucketOptions{}), convey.ShouldBeNil)
+		put := func(key string) {
+			data := []byte("0123456789")
+			_, err := c.mclient.PutObject(ctx, "usage", key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+			convey.So(err, convey.ShouldBeNil)
+		}
+		put("pvc-1/a.txt")
+		put("b.txt")
+
+		cache := &usageCache{entries: map[string]*usageEntry{}}
+		// the refresher is run by hand
+		cache.start.Do(func() {})
+		usage, err := cache.get(ctx, c, "usage", "pvc-1")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(usage, convey.ShouldResemble, volumeUsage{Bytes: 10, Objects: 1})
+		usage, err = cache.get(ctx, c, "usage", "")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(usage, convey.ShouldResemble, volumeUsage{Bytes: 20, Objects: 2})
+
+		put("pvc-1/c.txt")
+		usage, err = cache.get(ctx, c, "usage", "pvc-1")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(usage.Objects, convey.ShouldEqual, 1)
+
+		convey.Convey("the refresher accounts usage again before it is stale", func() {
+			for _, e := range cache.entries {
+				e.accounted = e.accounted.Add(-DefaultUsageStaleness / 2)
+			}
+			cache.refresh()
+			usage, err := cache.get(ctx, c, "usage", "pvc-1")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(usage, convey.ShouldResemble, volumeUsage{Bytes: 20, Objects: 2})
+		})
+
+		convey.Convey("idle volumes are dropped", func() {
+			for _, e := range cache.entries {
+				e.accessed = e.accessed.Add(-usageIdleTimeout - time.Second)
+			}
+			cache.refresh()
+			convey.So(cache.entries, convey.ShouldBeEmpty)
+		})
+	})
+}
+
+// countingWaiter counts the waits of the usage listings.
+type countingWaiter struct {
+	waits int
+}
+
+func (w *countingWaiter) Wait(ctx context.Context) error {
+	w.waits++
+	return nil
+}
+
+func TestUsageListLimit(t *testing.T) {
+	convey.Convey("test every page of a usage listing waits for the limiter", t, func() {
+		fake3 := newFakeS3()
+		defer fake3.Close()
+		ctx := context.Background()
+		limiter, pageSize := usageListLimiter, usageListPageSize
+		defer func() { usageListLimiter, usageListPageSize = limiter, pageSize }()
+		counter := &countingWaiter{}
+		usageListLimiter, usageListPageSize = counter, 2
+
+		c, err := NewS3Client(fake3.config())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c.mclient.MakeBucket(ctx, "paged", minio.MakeBucketOptions{}), convey.ShouldBeNil)
+		for _, key := range []string{"pvc-1/a", "pvc-1/b", "pvc-1/c", "pvc-1/d", "pvc-1/e", "pvc-2/f"} {
+			data := []byte("0123456789")
+			_, err := c.mclient.PutObject(ctx, "paged", key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+			convey.So(err, convey.ShouldBeNil)
+		}
+
+		usage, err := c.bucketUsage(ctx, "paged", "pvc-1")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(usage, convey.ShouldResemble, volumeUsage{Bytes: 50, Objects: 5})
+		convey.So(counter.waits, convey.ShouldEqual, 3)
+
+		counter.waits = 0
+		usage, err = c.bucketUsage(ctx, "paged", "")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(usage.Objects, convey.ShouldEqual, 6)
+		convey.So(counter.waits, convey.ShouldEqual, 3)
+	})
+}
+
+func TestPrefixVolumeStats(t *testing.T) {
+	convey.Convey("test the stats of a prefix volume against a stand-in server", t, func() {
+		fake3 := newFakeS3()
+		defer fake3.Close()
+		ctx := context.Background()
+		staleness := DefaultUsageStaleness
+		DefaultUsageStaleness = 0
+		defer func() { DefaultUsageStaleness = staleness }()
+
+		c, err := NewS3Client(fake3.config())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c.mclient.MakeBucket(ctx, "shared", minio.MakeBucketOptions{}), convey.ShouldBeNil)
+		for _, key := range []string{"pvc-1/a.txt", "pvc-1/b.txt", "pvc-2/c.txt"} {
+			data := []byte("0123456789")
+			_, err := c.mclient.PutObject(ctx, "shared", key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+			convey.So(err, convey.ShouldBeNil)
+		}
+		pv := &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"},
+			Spec: corev1.PersistentVolumeSpec{
+				Capacity: corev1.ResourceList{corev1.ResourceStorage: *resource.NewQuantity(100, resource.BinarySI)},
+				PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+					VolumeHandle: "pvc-1",
+					VolumeAttributes: map[string]string{
+						ParamProvisionTypeTag: string(ProvisionTypePrefix),
+						ParamBucketNameTag:    "shared",
+						ParamPrefixTag:        "pvc-1",
+					},
+				}},
+			},
+		}
+		driver := &MinIODriver{name: S3DriverName, minioClient: c, kubeClient: fake.NewSimpleClientset(pv)}
+
+		resp, err := driver.NodeGetVolumeStats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: "pvc-1", VolumePath: "/mnt/pvc-1"})
+		convey.So(err, convey.ShouldBeNil)
+		// the capacity is the one of the pv, the usage only the objects under the prefix
+		convey.So(resp.GetUsage()[0], convey.ShouldResemble, &csi.VolumeUsage{Available: 80, Total: 100, Used: 20, Unit: csi.VolumeUsage_BYTES})
+		convey.So(resp.GetUsage()[1].GetUsed(), convey.ShouldEqual, 2)
+	})
+}
@@ -0,0 +1,37 @@
+package s3minio
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// isStaticVolume reports whether the attributes belong to a static PV, whose bucket exists outside of the driver.
+// Only volumes created by CreateVolume carry the BucketOrCreate or Prefix provision type.
+func isStaticVolume(attrs map[string]string) bool {
+	switch ProvisionType(attrs[ParamProvisionTypeTag]) {
+	case ProvisionTypeBucketOrCreate, ProvisionTypePrefix:
+		return false
+	}
+	return true
+}
+
+// volumeSource returns the bucket and the prefix in it a volume mounts. The bucket of static PVs
+// defaults to their volumeHandle, the prefix is empty when the whole bucket is mounted.
+func volumeSource(volumeID string, attrs map[string]string) (bucket, prefix string, err error) {
+	bucket = attrs[ParamBucketNameTag]
+	if bucket == "" {
+		if !isStaticVolume(attrs) {
+			return "", "", fmt.Errorf("%s not found in volume attributes", ParamBucketNameTag)
+		}
+		bucket = volumeID
+	}
+	prefix = strings.Trim(attrs[ParamPrefixTag], "/")
+	if prefix == "" && ProvisionType(attrs[ParamProvisionTypeTag]) == ProvisionTypePrefix {
+		return "", "", fmt.Errorf("%s not found in volume attributes", ParamPrefixTag)
+	}
+	if prefix != "" && (path.Clean(prefix) != prefix || prefix == ".." || strings.HasPrefix(prefix, "../")) {
+		return "", "", fmt.Errorf("invalid %s %q, expect a relative path in the bucket", ParamPrefixTag, attrs[ParamPrefixTag])
+	}
+	return bucket, prefix, nil
+}
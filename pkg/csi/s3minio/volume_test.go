@@ -0,0 +1,38 @@
+package s3minio
+
+import (
+	"github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestVolumeSource(t *testing.T) {
+	convey.Convey("test volume source", t, func() {
+		bucket, prefix, err := volumeSource("pv-1", map[string]string{ParamProvisionTypeTag: string(ProvisionTypeBucketOrCreate), ParamBucketNameTag: "bucket-1"})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(bucket, convey.ShouldEqual, "bucket-1")
+		convey.So(prefix, convey.ShouldEqual, "")
+
+		// static volumes default to the volume handle
+		bucket, prefix, err = volumeSource("datasets", map[string]string{ParamPrefixTag: "/imagenet/train/"})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(bucket, convey.ShouldEqual, "datasets")
+		convey.So(prefix, convey.ShouldEqual, "imagenet/train")
+		convey.So(isStaticVolume(map[string]string{ParamBucketNameTag: "datasets"}), convey.ShouldBeTrue)
+
+		_, _, err = volumeSource("datasets", map[string]string{ParamPrefixTag: "a/../../b"})
+		convey.So(err, convey.ShouldNotBeNil)
+		_, _, err = volumeSource("pv-1", map[string]string{ParamProvisionTypeTag: string(ProvisionTypeBucketOrCreate)})
+		convey.So(err, convey.ShouldNotBeNil)
+
+		// prefix volumes are provisioned, and mount their own prefix of the shared bucket
+		attrs := map[string]string{ParamProvisionTypeTag: string(ProvisionTypePrefix), ParamBucketNameTag: "ci-volumes", ParamPrefixTag: "pvc-1"}
+		convey.So(isStaticVolume(attrs), convey.ShouldBeFalse)
+		bucket, prefix, err = volumeSource("pvc-1", attrs)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(bucket, convey.ShouldEqual, "ci-volumes")
+		convey.So(prefix, convey.ShouldEqual, "pvc-1")
+		delete(attrs, ParamPrefixTag)
+		_, _, err = volumeSource("pvc-1", attrs)
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
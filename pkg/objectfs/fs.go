@@ -0,0 +1,196 @@
+package objectfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/minio/minio-go/v7"
+	"k8s.io/klog/v2"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// FSName is the name of the filesystem, mounts show up as fuse.open-object in mountinfo
+	FSName = "open-object"
+
+	blockSize = 4096
+	// unlimitedCapacity is reported by statfs when the volume has no capacity
+	unlimitedCapacity = 1 << 50
+	// partSize is the size of the parts of multipart uploads
+	partSize = 16 << 20
+)
+
+// Options configures a mount of a bucket.
+type Options struct {
+	Bucket string
+	// Prefix restricts the filesystem to the objects under it, it ends with a slash when it is not empty
+	Prefix string
+	// Capacity is reported by statfs, 0 reports an unlimited filesystem
+	Capacity int64
+	// UsageInterval is the period at which the usage of the bucket is refreshed for statfs
+	UsageInterval time.Duration
+	// CacheDir holds the local copies of the files being written
+	CacheDir string
+	FileMode uint32
+	DirMode  uint32
+	UID      uint32
+	GID      uint32
+	// AttrTimeout is how long the kernel caches attributes and entries
+	AttrTimeout time.Duration
+	// ReadOnly mounts the filesystem ro, the kernel then refuses writes
+	ReadOnly bool
+}
+
+// objectFS is the state shared by the nodes of a mount.
+type objectFS struct {
+	client *minio.Client
+	// core returns the response of range requests as is
+	core *minio.Core
+	opts Options
+
+	usageMu sync.Mutex
+	usage   int64
+	objects int64
+}
+
+// Mount mounts the bucket of opts on target, the returned server is unmounted by the caller.
+func Mount(client *minio.Client, target string, opts Options) (*fuse.Server, error) {
+	if opts.Prefix != "" && !strings.HasSuffix(opts.Prefix, "/") {
+		opts.Prefix += "/"
+	}
+	if opts.CacheDir == "" {
+		opts.CacheDir = os.TempDir()
+	}
+	ofs := &objectFS{client: client, core: &minio.Core{Client: client}, opts: opts}
+	exists, err := client.BucketExists(context.Background(), opts.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("fail to check bucket %s: %s", opts.Bucket, err.Error())
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %s does not exist", opts.Bucket)
+	}
+
+	timeout := opts.AttrTimeout
+	server, err := fs.Mount(target, &dirNode{ofs: ofs}, &fs.Options{
+		EntryTimeout: &timeout,
+		AttrTimeout:  &timeout,
+		MountOptions: ofs.mountOptions(),
+		UID:          opts.UID,
+		GID:          opts.GID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opts.UsageInterval > 0 {
+		go ofs.refreshUsage(opts.UsageInterval)
+	}
+	return server, nil
+}
+
+// mountOptions returns the fuse options of the mount.
+func (ofs *objectFS) mountOptions() fuse.MountOptions {
+	opts := fuse.MountOptions{
+		AllowOther: true,
+		FsName:     ofs.opts.Bucket,
+		Name:       FSName,
+		MaxWrite:   1 << 20,
+	}
+	if ofs.opts.ReadOnly {
+		opts.Options = append(opts.Options, "ro")
+	}
+	return opts
+}
+
+// key returns the object key of a node path, relative to the mount root.
+func (ofs *objectFS) key(path string) string {
+	return ofs.opts.Prefix + path
+}
+
+// dirKey returns the prefix of the objects in the directory at path.
+func (ofs *objectFS) dirKey(path string) string {
+	if path == "" {
+		return ofs.opts.Prefix
+	}
+	return ofs.opts.Prefix + path + "/"
+}
+
+// refreshUsage lists the bucket periodically, so statfs never waits for a listing.
+func (ofs *objectFS) refreshUsage(interval time.Duration) {
+	for {
+		var size, objects int64
+		// a slow listing is abandoned well before the next one is due
+		ctx, cancel := context.WithTimeout(context.Background(), interval/2)
+		var listErr error
+		for obj := range ofs.client.ListObjects(ctx, ofs.opts.Bucket, minio.ListObjectsOptions{Prefix: ofs.opts.Prefix, Recursive: true}) {
+			if obj.Err != nil {
+				listErr = obj.Err
+				break
+			}
+			size += obj.Size
+			objects++
+		}
+		cancel()
+		if listErr != nil {
+			klog.Warningf("fail to compute usage of bucket %s: %s", ofs.opts.Bucket, listErr.Error())
+		} else {
+			ofs.usageMu.Lock()
+			ofs.usage, ofs.objects = size, objects
+			ofs.usageMu.Unlock()
+			bucketUsage.Set(float64(size))
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (ofs *objectFS) statfs(out *fuse.StatfsOut) {
+	ofs.usageMu.Lock()
+	used, objects := ofs.usage, ofs.objects
+	ofs.usageMu.Unlock()
+
+	capacity := ofs.opts.Capacity
+	if capacity <= 0 {
+		capacity = unlimitedCapacity
+	}
+	free := capacity - used
+	if free < 0 {
+		free = 0
+	}
+	out.Bsize = blockSize
+	out.Frsize = blockSize
+	out.Blocks = uint64(capacity / blockSize)
+	out.Bfree = uint64(free / blockSize)
+	out.Bavail = out.Bfree
+	out.Files = uint64(objects)
+	out.Ffree = 1 << 30
+	out.NameLen = 1024
+}
+
+// toErrno maps the errors of the object store to the errors of the filesystem.
+func toErrno(err error) syscall.Errno {
+	if err == nil {
+		return 0
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno
+	}
+	if errors.Is(err, context.Canceled) {
+		return syscall.EINTR
+	}
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchBucket":
+		return syscall.ENOENT
+	case "AccessDenied":
+		return syscall.EACCES
+	case "XMinioAdminBucketQuotaExceeded", "QuotaExceeded":
+		return syscall.ENOSPC
+	}
+	klog.Warningf("object store error: %s", err.Error())
+	return syscall.EIO
+}
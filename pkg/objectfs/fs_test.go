@@ -0,0 +1,47 @@
+package objectfs
+
+import (
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/minio/minio-go/v7"
+	"github.com/smartystreets/goconvey/convey"
+	"syscall"
+	"testing"
+)
+
+func TestObjectFS(t *testing.T) {
+	convey.Convey("test object keys", t, func() {
+		ofs := &objectFS{opts: Options{Prefix: "volume/"}}
+		convey.So(ofs.key("dir/file"), convey.ShouldEqual, "volume/dir/file")
+		convey.So(ofs.dirKey(""), convey.ShouldEqual, "volume/")
+		convey.So(ofs.dirKey("dir"), convey.ShouldEqual, "volume/dir/")
+		convey.So(inodeNumber("dir/file"), convey.ShouldEqual, inodeNumber("dir/file"))
+		convey.So(inodeNumber("dir/file"), convey.ShouldNotEqual, inodeNumber("dir/other"))
+	})
+
+	convey.Convey("test statfs", t, func() {
+		ofs := &objectFS{opts: Options{Capacity: 1 << 30}, usage: 1 << 29, objects: 3}
+		out := &fuse.StatfsOut{}
+		ofs.statfs(out)
+		convey.So(out.Blocks*uint64(out.Bsize), convey.ShouldEqual, uint64(1<<30))
+		convey.So(out.Bavail*uint64(out.Bsize), convey.ShouldEqual, uint64(1<<29))
+		convey.So(out.Files, convey.ShouldEqual, 3)
+
+		// usage above the quota reports a full filesystem
+		ofs.usage = 1 << 31
+		ofs.statfs(out)
+		convey.So(out.Bavail, convey.ShouldEqual, 0)
+	})
+
+	convey.Convey("test mount options", t, func() {
+		ofs := &objectFS{opts: Options{Bucket: "bucket"}}
+		convey.So(ofs.mountOptions().Options, convey.ShouldNotContain, "ro")
+		ofs.opts.ReadOnly = true
+		convey.So(ofs.mountOptions().Options, convey.ShouldContain, "ro")
+	})
+
+	convey.Convey("test errno", t, func() {
+		convey.So(toErrno(nil), convey.ShouldEqual, syscall.Errno(0))
+		convey.So(toErrno(minio.ErrorResponse{Code: "NoSuchKey"}), convey.ShouldEqual, syscall.ENOENT)
+		convey.So(toErrno(minio.ErrorResponse{Code: "AccessDenied"}), convey.ShouldEqual, syscall.EACCES)
+	})
+}
@@ -0,0 +1,194 @@
+package objectfs
+
+import (
+	"context"
+	"errors"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/minio/minio-go/v7"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// fileHandle is an open file. Reads stream the object with range requests, reusing the response
+// while reads are sequential. Writes go to a local spool file uploaded when the file is flushed,
+// with multipart uploads for large files.
+type fileHandle struct {
+	node *fileNode
+	key  string
+
+	mu sync.Mutex
+	// body is the response of the last range request, positioned at offset
+	body   io.ReadCloser
+	offset int64
+	// spool is the local copy of a file opened for writing
+	spool *os.File
+	dirty bool
+}
+
+var (
+	_ fs.FileReader   = (*fileHandle)(nil)
+	_ fs.FileWriter   = (*fileHandle)(nil)
+	_ fs.FileFlusher  = (*fileHandle)(nil)
+	_ fs.FileFsyncer  = (*fileHandle)(nil)
+	_ fs.FileReleaser = (*fileHandle)(nil)
+)
+
+func newFileHandle(node *fileNode, key string, writable bool) (*fileHandle, error) {
+	h := &fileHandle{node: node, key: key}
+	if writable {
+		spool, err := spoolFile(node.ofs.opts.CacheDir)
+		if err != nil {
+			return nil, err
+		}
+		h.spool = spool
+	}
+	return h, nil
+}
+
+// fillSpool downloads the object, so writes can modify any part of it.
+func (h *fileHandle) fillSpool(ctx context.Context) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	obj, err := h.node.ofs.client.GetObject(ctx, h.node.ofs.opts.Bucket, h.key, minio.GetObjectOptions{})
+	if err != nil {
+		return toErrno(err)
+	}
+	defer obj.Close()
+	n, err := io.Copy(h.spool, obj)
+	if err != nil {
+		return toErrno(err)
+	}
+	bytesRead.Add(float64(n))
+	return 0
+}
+
+func (h *fileHandle) Read(ctx context.Context, dest []byte, off int64) (result fuse.ReadResult, errno syscall.Errno) {
+	defer observe("read", time.Now(), &errno)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.spool != nil {
+		n, err := h.spool.ReadAt(dest, off)
+		if err != nil && err != io.EOF {
+			return nil, toErrno(err)
+		}
+		return fuse.ReadResultData(dest[:n]), 0
+	}
+
+	if h.body == nil || h.offset != off {
+		if h.body != nil {
+			_ = h.body.Close()
+			h.body = nil
+		}
+		opts := minio.GetObjectOptions{}
+		if off > 0 {
+			if err := opts.SetRange(off, 0); err != nil {
+				return nil, syscall.EINVAL
+			}
+		}
+		// the response outlives the request of the kernel, it is closed when reads stop being sequential
+		body, _, _, err := h.node.ofs.core.GetObject(context.Background(), h.node.ofs.opts.Bucket, h.key, opts)
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "InvalidRange" {
+				return fuse.ReadResultData(nil), 0
+			}
+			return nil, toErrno(err)
+		}
+		h.body, h.offset = body, off
+	}
+
+	n, err := io.ReadFull(h.body, dest)
+	h.offset += int64(n)
+	bytesRead.Add(float64(n))
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		_ = h.body.Close()
+		h.body = nil
+		return nil, toErrno(err)
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+func (h *fileHandle) Write(ctx context.Context, data []byte, off int64) (written uint32, errno syscall.Errno) {
+	defer observe("write", time.Now(), &errno)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.spool == nil {
+		return 0, syscall.EBADF
+	}
+	n, err := h.spool.WriteAt(data, off)
+	if err != nil {
+		return uint32(n), toErrno(err)
+	}
+	h.dirty = true
+	bytesWritten.Add(float64(n))
+	return uint32(n), 0
+}
+
+func (h *fileHandle) truncate(size int64) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.spool.Truncate(size); err != nil {
+		return toErrno(err)
+	}
+	h.dirty = true
+	return 0
+}
+
+// spoolSize returns the size of the local copy, false when the file is not open for writing.
+func (h *fileHandle) spoolSize() (int64, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.spool == nil || !h.dirty {
+		return 0, false
+	}
+	info, err := h.spool.Stat()
+	if err != nil {
+		return 0, false
+	}
+	return info.Size(), true
+}
+
+// Flush uploads the file when it was modified. It is called on every close of the file.
+func (h *fileHandle) Flush(ctx context.Context) (errno syscall.Errno) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.spool == nil || !h.dirty {
+		return 0
+	}
+	defer observe("upload", time.Now(), &errno)
+
+	info, err := h.spool.Stat()
+	if err != nil {
+		return toErrno(err)
+	}
+	// minio-go switches to multipart uploads above partSize
+	if _, err := h.node.ofs.client.PutObject(ctx, h.node.ofs.opts.Bucket, h.key, io.NewSectionReader(h.spool, 0, info.Size()), info.Size(),
+		minio.PutObjectOptions{PartSize: partSize}); err != nil {
+		return toErrno(err)
+	}
+	h.dirty = false
+	h.node.setStat(info.Size(), time.Now())
+	return 0
+}
+
+func (h *fileHandle) Fsync(ctx context.Context, flags uint32) syscall.Errno {
+	return h.Flush(ctx)
+}
+
+func (h *fileHandle) Release(ctx context.Context) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.body != nil {
+		_ = h.body.Close()
+		h.body = nil
+	}
+	if h.spool != nil {
+		_ = h.spool.Close()
+		h.spool = nil
+	}
+	return 0
+}
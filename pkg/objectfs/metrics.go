@@ -0,0 +1,60 @@
+package objectfs
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"syscall"
+	"time"
+)
+
+var (
+	operations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "open_object",
+		Subsystem: "fuse",
+		Name:      "operations_total",
+		Help:      "Filesystem operations, by operation.",
+	}, []string{"op"})
+	operationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "open_object",
+		Subsystem: "fuse",
+		Name:      "operation_errors_total",
+		Help:      "Filesystem operations that failed, by operation and errno.",
+	}, []string{"op", "errno"})
+	operationLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "open_object",
+		Subsystem: "fuse",
+		Name:      "operation_duration_seconds",
+		Help:      "Latency of filesystem operations, by operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+	}, []string{"op"})
+	bytesRead = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "open_object",
+		Subsystem: "fuse",
+		Name:      "read_bytes_total",
+		Help:      "Bytes read from the object store.",
+	})
+	bytesWritten = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "open_object",
+		Subsystem: "fuse",
+		Name:      "written_bytes_total",
+		Help:      "Bytes written to files.",
+	})
+	bucketUsage = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "open_object",
+		Subsystem: "fuse",
+		Name:      "usage_bytes",
+		Help:      "Bytes stored under the mounted prefix, as reported by statfs.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(operations, operationErrors, operationLatency, bytesRead, bytesWritten, bucketUsage)
+}
+
+// observe records an operation started at start, errno points to its result when it can fail.
+func observe(op string, start time.Time, errno *syscall.Errno) {
+	operations.WithLabelValues(op).Inc()
+	operationLatency.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if errno != nil && *errno != 0 {
+		operationErrors.WithLabelValues(op, (*errno).Error()).Inc()
+	}
+}
@@ -0,0 +1,283 @@
+package objectfs
+
+import (
+	"bytes"
+	"context"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/minio/minio-go/v7"
+	"hash/fnv"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// dirNode is a directory, which is the common prefix of the objects below it.
+// Empty directories are kept as zero sized objects whose key ends with a slash.
+type dirNode struct {
+	fs.Inode
+	ofs *objectFS
+}
+
+var (
+	_ fs.NodeLookuper  = (*dirNode)(nil)
+	_ fs.NodeReaddirer = (*dirNode)(nil)
+	_ fs.NodeMkdirer   = (*dirNode)(nil)
+	_ fs.NodeCreater   = (*dirNode)(nil)
+	_ fs.NodeUnlinker  = (*dirNode)(nil)
+	_ fs.NodeRmdirer   = (*dirNode)(nil)
+	_ fs.NodeRenamer   = (*dirNode)(nil)
+	_ fs.NodeGetattrer = (*dirNode)(nil)
+	_ fs.NodeStatfser  = (*dirNode)(nil)
+)
+
+func (n *dirNode) path() string {
+	return n.Path(n.Root())
+}
+
+func (n *dirNode) childPath(name string) string {
+	return path.Join(n.path(), name)
+}
+
+func (n *dirNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = syscall.S_IFDIR | n.ofs.opts.DirMode
+	out.Nlink = 2
+	return 0
+}
+
+func (n *dirNode) Statfs(ctx context.Context, out *fuse.StatfsOut) syscall.Errno {
+	defer observe("statfs", time.Now(), nil)
+	n.ofs.statfs(out)
+	return 0
+}
+
+func (n *dirNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (inode *fs.Inode, errno syscall.Errno) {
+	defer observe("lookup", time.Now(), &errno)
+	childPath := n.childPath(name)
+
+	info, err := n.ofs.client.StatObject(ctx, n.ofs.opts.Bucket, n.ofs.key(childPath), minio.StatObjectOptions{})
+	if err == nil {
+		file := &fileNode{ofs: n.ofs, size: info.Size, mtime: info.LastModified}
+		file.fillAttr(&out.Attr)
+		return n.NewInode(ctx, file, fs.StableAttr{Mode: syscall.S_IFREG, Ino: inodeNumber(childPath)}), 0
+	}
+	if errno := toErrno(err); errno != syscall.ENOENT {
+		return nil, errno
+	}
+
+	dirKey := n.ofs.dirKey(childPath)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for obj := range n.ofs.client.ListObjects(ctx, n.ofs.opts.Bucket, minio.ListObjectsOptions{Prefix: dirKey, MaxKeys: 1}) {
+		if obj.Err != nil {
+			return nil, toErrno(obj.Err)
+		}
+		dir := &dirNode{ofs: n.ofs}
+		out.Mode = syscall.S_IFDIR | n.ofs.opts.DirMode
+		return n.NewInode(ctx, dir, fs.StableAttr{Mode: syscall.S_IFDIR, Ino: inodeNumber(dirKey)}), 0
+	}
+	return nil, syscall.ENOENT
+}
+
+func (n *dirNode) Readdir(ctx context.Context) (stream fs.DirStream, errno syscall.Errno) {
+	defer observe("readdir", time.Now(), &errno)
+	dirKey := n.ofs.dirKey(n.path())
+
+	var entries []fuse.DirEntry
+	seen := map[string]bool{}
+	for obj := range n.ofs.client.ListObjects(ctx, n.ofs.opts.Bucket, minio.ListObjectsOptions{Prefix: dirKey}) {
+		if obj.Err != nil {
+			return nil, toErrno(obj.Err)
+		}
+		name := strings.TrimPrefix(obj.Key, dirKey)
+		mode := uint32(syscall.S_IFREG)
+		if strings.HasSuffix(name, "/") {
+			name, mode = strings.TrimSuffix(name, "/"), syscall.S_IFDIR
+		}
+		// the marker of the directory itself, and files shadowed by a directory of the same name
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		entries = append(entries, fuse.DirEntry{Name: name, Mode: mode})
+	}
+	return fs.NewListDirStream(entries), 0
+}
+
+func (n *dirNode) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (inode *fs.Inode, errno syscall.Errno) {
+	defer observe("mkdir", time.Now(), &errno)
+	dirKey := n.ofs.dirKey(n.childPath(name))
+	if _, err := n.ofs.client.PutObject(ctx, n.ofs.opts.Bucket, dirKey, bytes.NewReader(nil), 0, minio.PutObjectOptions{}); err != nil {
+		return nil, toErrno(err)
+	}
+	out.Mode = syscall.S_IFDIR | n.ofs.opts.DirMode
+	return n.NewInode(ctx, &dirNode{ofs: n.ofs}, fs.StableAttr{Mode: syscall.S_IFDIR, Ino: inodeNumber(dirKey)}), 0
+}
+
+func (n *dirNode) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (inode *fs.Inode, fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer observe("create", time.Now(), &errno)
+	childPath := n.childPath(name)
+	file := &fileNode{ofs: n.ofs, mtime: time.Now()}
+	handle, err := newFileHandle(file, n.ofs.key(childPath), true)
+	if err != nil {
+		return nil, nil, 0, toErrno(err)
+	}
+	// the object is created when the file is flushed
+	handle.dirty = true
+	file.fillAttr(&out.Attr)
+	return n.NewInode(ctx, file, fs.StableAttr{Mode: syscall.S_IFREG, Ino: inodeNumber(childPath)}), handle, 0, 0
+}
+
+func (n *dirNode) Unlink(ctx context.Context, name string) (errno syscall.Errno) {
+	defer observe("unlink", time.Now(), &errno)
+	return toErrno(n.ofs.client.RemoveObject(ctx, n.ofs.opts.Bucket, n.ofs.key(n.childPath(name)), minio.RemoveObjectOptions{}))
+}
+
+func (n *dirNode) Rmdir(ctx context.Context, name string) (errno syscall.Errno) {
+	defer observe("rmdir", time.Now(), &errno)
+	dirKey := n.ofs.dirKey(n.childPath(name))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for obj := range n.ofs.client.ListObjects(ctx, n.ofs.opts.Bucket, minio.ListObjectsOptions{Prefix: dirKey, Recursive: true, MaxKeys: 2}) {
+		if obj.Err != nil {
+			return toErrno(obj.Err)
+		}
+		if obj.Key != dirKey {
+			return syscall.ENOTEMPTY
+		}
+	}
+	return toErrno(n.ofs.client.RemoveObject(ctx, n.ofs.opts.Bucket, dirKey, minio.RemoveObjectOptions{}))
+}
+
+// Rename copies the object on the server and removes the source. Directories would need every object
+// below them to be copied, EXDEV lets tools like mv fall back to copying them.
+func (n *dirNode) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) (errno syscall.Errno) {
+	defer observe("rename", time.Now(), &errno)
+	child := n.GetChild(name)
+	if child == nil || child.IsDir() {
+		return syscall.EXDEV
+	}
+	parent, ok := newParent.(*dirNode)
+	if !ok {
+		return syscall.EXDEV
+	}
+	srcKey := n.ofs.key(n.childPath(name))
+	dstKey := n.ofs.key(parent.childPath(newName))
+	if _, err := n.ofs.client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: n.ofs.opts.Bucket, Object: dstKey},
+		minio.CopySrcOptions{Bucket: n.ofs.opts.Bucket, Object: srcKey}); err != nil {
+		return toErrno(err)
+	}
+	return toErrno(n.ofs.client.RemoveObject(ctx, n.ofs.opts.Bucket, srcKey, minio.RemoveObjectOptions{}))
+}
+
+// fileNode is an object. Its size and mtime are updated when a handle uploads it.
+type fileNode struct {
+	fs.Inode
+	ofs *objectFS
+
+	mu    sync.Mutex
+	size  int64
+	mtime time.Time
+}
+
+var (
+	_ fs.NodeOpener    = (*fileNode)(nil)
+	_ fs.NodeGetattrer = (*fileNode)(nil)
+	_ fs.NodeSetattrer = (*fileNode)(nil)
+)
+
+func (f *fileNode) key() string {
+	return f.ofs.key(f.Path(f.Root()))
+}
+
+func (f *fileNode) fillAttr(out *fuse.Attr) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out.Mode = syscall.S_IFREG | f.ofs.opts.FileMode
+	out.Nlink = 1
+	out.Size = uint64(f.size)
+	out.Blocks = (out.Size + 511) / 512
+	out.Blksize = blockSize
+	out.SetTimes(nil, &f.mtime, &f.mtime)
+}
+
+func (f *fileNode) setStat(size int64, mtime time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.size, f.mtime = size, mtime
+}
+
+func (f *fileNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	if h, ok := fh.(*fileHandle); ok {
+		if size, ok := h.spoolSize(); ok {
+			f.setStat(size, time.Now())
+		}
+	}
+	f.fillAttr(&out.Attr)
+	return 0
+}
+
+// Setattr only supports truncation, objects have no mode, owner or settable times.
+func (f *fileNode) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer observe("setattr", time.Now(), &errno)
+	if size, ok := in.GetSize(); ok {
+		if h, ok := fh.(*fileHandle); ok && h.spool != nil {
+			if errno := h.truncate(int64(size)); errno != 0 {
+				return errno
+			}
+		} else if size == 0 {
+			if _, err := f.ofs.client.PutObject(ctx, f.ofs.opts.Bucket, f.key(), bytes.NewReader(nil), 0, minio.PutObjectOptions{}); err != nil {
+				return toErrno(err)
+			}
+		} else {
+			return syscall.ENOTSUP
+		}
+		f.setStat(int64(size), time.Now())
+	}
+	f.fillAttr(&out.Attr)
+	return 0
+}
+
+func (f *fileNode) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer observe("open", time.Now(), &errno)
+	writable := flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0
+	h, err := newFileHandle(f, f.key(), writable)
+	if err != nil {
+		return nil, 0, toErrno(err)
+	}
+	if writable {
+		if flags&syscall.O_TRUNC != 0 {
+			h.dirty = true
+		} else if errno := h.fillSpool(ctx); errno != 0 {
+			_ = h.Release(ctx)
+			return nil, 0, errno
+		}
+	}
+	return h, 0, 0
+}
+
+// inodeNumber keeps inode numbers stable across lookups of the same path.
+func inodeNumber(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	// 0 asks for a generated number and 1 is the root
+	if ino := h.Sum64(); ino > 1 {
+		return ino
+	}
+	return 2
+}
+
+// spoolFile creates the local copy of a file being written.
+func spoolFile(dir string) (*os.File, error) {
+	f, err := os.CreateTemp(dir, "open-object-spool-")
+	if err != nil {
+		return nil, err
+	}
+	// only the handle keeps it
+	_ = os.Remove(f.Name())
+	return f, nil
+}